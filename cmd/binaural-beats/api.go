@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// apiMaxRequestBytes limits the size of a configuration posted to the API.
+const apiMaxRequestBytes = 1 << 20
+
+// apiRenderTimeout limits how long a single render request may take.
+const apiRenderTimeout = 10 * time.Minute
+
+// apiSendTimeout limits how long sending a rendered WAV to the client may take once the render
+// is done, so a client that stops reading can't hold the connection open.
+const apiSendTimeout = 10 * time.Minute
+
+// apiIdleTimeout limits how long a keep-alive connection may wait for its next request.
+const apiIdleTimeout = 2 * time.Minute
+
+// apiMaxDuration limits the length in seconds of a session rendered by the API, so a single
+// request can't keep the server busy for the whole render timeout.
+const apiMaxDuration = 3600.0
+
+// apiMaxHarmonics limits the number of harmonics of a session rendered by the API, as each
+// adds a sine per tone to every sample.
+const apiMaxHarmonics = 16
+
+// apiSampleRate is the sample rate of audio rendered by the API.
+const apiSampleRate = beep.SampleRate(44100)
+
+// validationResponse is the JSON body returned by the /validate endpoint.
+type validationResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// runAPI serves the /render, /stream and /validate endpoints on addr.
+func runAPI(addr string) error {
+	infof("Serving render API on %s...\n", addr)
+	return newAPIServer(addr).ListenAndServe()
+}
+
+// newAPIServer returns the server for the API endpoints on addr. It has no write timeout, as
+// /stream responds for as long as its session plays; /render sets its own write deadline.
+func newAPIServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/render", handleRender)
+	mux.HandleFunc("/stream", handleStream)
+	mux.HandleFunc("/validate", handleValidate)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       apiIdleTimeout,
+	}
+}
+
+// readAPIConfig reads and validates the configuration posted in the request body.
+// The returned problems are empty when the configuration is valid.
+func readAPIConfig(w http.ResponseWriter, r *http.Request) (*Config, []string, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxRequestBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := parseConfigData(data, false)
+	if err != nil {
+		return nil, []string{err.Error()}, nil
+	}
+
+	problems := validateConfig(cfg)
+	if err := checkNyquist(cfg, apiSampleRate); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if duration := getTotalPlaybackTime(cfg.FrequencyChanges); duration > apiMaxDuration {
+		problems = append(problems, fmt.Sprintf("the session is %.0f s long, more than the API's limit of %.0f s", duration, apiMaxDuration))
+	}
+	if len(cfg.Harmonics) > apiMaxHarmonics {
+		problems = append(problems, fmt.Sprintf("%d harmonics are more than the API's limit of %d", len(cfg.Harmonics), apiMaxHarmonics))
+	}
+	if cfg.NoiseFile != "" {
+		// Never read files from the server's disk on behalf of a client
+		problems = append(problems, "noise_file is not supported by the API")
+	}
+	return cfg, problems, nil
+}
+
+// readErrorStatus returns the status to respond with when readAPIConfig fails: 413 when the
+// body is larger than apiMaxRequestBytes, and 400 when it couldn't be read otherwise.
+func readErrorStatus(err error) int {
+	if errors.As(err, new(*http.MaxBytesError)) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// handleValidate reports the validation errors for the posted configuration as JSON.
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, problems, err := readAPIConfig(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), readErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(validationResponse{
+		Valid:  len(problems) == 0,
+		Errors: append([]string{}, problems...),
+	})
+}
+
+// handleRender synthesizes the posted configuration and responds with the WAV file.
+func handleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Nothing is written until the render is done, so the deadline covers the render and the
+	// send. Writers without deadlines, such as test recorders, are left as they are
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(apiRenderTimeout + apiSendTimeout))
+
+	cfg, problems, err := readAPIConfig(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), readErrorStatus(err))
+		return
+	}
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(validationResponse{Valid: false, Errors: problems})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), apiRenderTimeout)
+	defer cancel()
+
+	// The WAV encoder needs to seek back to finalize the header, so render to a temporary file
+	tmpFile, err := os.CreateTemp("", "binaural-beats-*.wav")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	sr := apiSampleRate
+	session, err := newSession(cfg, sr, defaultSessionOptions(time.Now().UnixNano()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+	streamer := &contextStreamer{ctx: ctx, stream: session.streamer}
+
+	format := beep.Format{
+		SampleRate:  sr,
+		NumChannels: 2,
+		Precision:   2, // 16-bit audio
+	}
+	if err := encodeWAV(tmpFile, streamer, format, cfg.metadata()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := streamer.Err(); err != nil {
+		http.Error(w, "render timed out", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeContent(w, r, "session.wav", time.Now(), tmpFile)
+}
+
+// contextStreamer stops streaming once its context is done.
+type contextStreamer struct {
+	ctx    context.Context
+	stream beep.Streamer
+}
+
+// Stream streams from the wrapped streamer until the context is done.
+func (cs *contextStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	if cs.ctx.Err() != nil {
+		return 0, false
+	}
+	return cs.stream.Stream(samples)
+}
+
+// Err returns the context error, or the error state of the wrapped streamer.
+func (cs *contextStreamer) Err() error {
+	if err := cs.ctx.Err(); err != nil {
+		return err
+	}
+	return cs.stream.Err()
+}
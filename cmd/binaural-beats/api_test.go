@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gopxl/beep/wav"
+)
+
+// apiTestConfig is a short valid session posted to the API.
+const apiTestConfig = `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 0.5, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`
+
+// postAPI posts body to handler and returns the recorded response.
+func postAPI(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantValid  bool
+		wantError  string
+	}{
+		{"valid", http.MethodPost, apiTestConfig, http.StatusOK, true, ""},
+		{"unparsable", http.MethodPost, "frequency_changes: [", http.StatusUnprocessableEntity, false, "did not find expected"},
+		{"empty", http.MethodPost, "", http.StatusUnprocessableEntity, false, "empty"},
+		{"noise file", http.MethodPost, apiTestConfig + "noise_file: /etc/passwd\n", http.StatusUnprocessableEntity, false, "noise_file"},
+		{"too long", http.MethodPost, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 3601, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`, http.StatusUnprocessableEntity, false, "limit of 3600 s"},
+		{"too many harmonics", http.MethodPost, apiTestConfig + "harmonics: [1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]\n", http.StatusUnprocessableEntity, false, "17 harmonics"},
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed, false, ""},
+		{"too large", http.MethodPost, strings.Repeat(" ", apiMaxRequestBytes+1), http.StatusRequestEntityTooLarge, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postAPI(handleValidate, tt.method, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+			var resp validationResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v", resp.Valid, tt.wantValid)
+			}
+			if tt.wantValid && len(resp.Errors) != 0 {
+				t.Errorf("errors = %q, want none", resp.Errors)
+			}
+			if tt.wantError != "" && !strings.Contains(strings.Join(resp.Errors, "\n"), tt.wantError) {
+				t.Errorf("errors = %q, want one containing %q", resp.Errors, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestUnreadableRequestBody(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{"validate": handleValidate, "render": handleRender, "stream": handleStream} {
+		req := httptest.NewRequest(http.MethodPost, "/", iotest.ErrReader(errors.New("connection reset")))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status for a body that fails to read = %d, want %d", name, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// deadlineRecorder is a response recorder that records the write deadline set through an
+// http.ResponseController.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (r *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	r.deadline = deadline
+	return nil
+}
+
+func TestAPIServerTimeouts(t *testing.T) {
+	server := newAPIServer(":0")
+	if server.IdleTimeout <= 0 || server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 {
+		t.Errorf("timeouts: idle %v, read header %v, read %v, want all set", server.IdleTimeout, server.ReadHeaderTimeout, server.ReadTimeout)
+	}
+	if server.WriteTimeout != 0 {
+		t.Errorf("write timeout = %v, want none, so /stream isn't cut off", server.WriteTimeout)
+	}
+
+	// Only /render has a write deadline
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		want    bool
+	}{
+		{"render", handleRender, true},
+		{"stream", handleStream, false},
+	} {
+		rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		tt.handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(apiTestConfig)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", tt.name, rec.Code, http.StatusOK)
+		}
+		if !tt.want {
+			if !rec.deadline.IsZero() {
+				t.Errorf("%s set a write deadline of %v", tt.name, rec.deadline)
+			}
+			continue
+		}
+		if limit := start.Add(apiRenderTimeout + apiSendTimeout); rec.deadline.Before(start) || rec.deadline.After(limit.Add(time.Second)) {
+			t.Errorf("%s: write deadline %v, want about %v", tt.name, rec.deadline, limit)
+		}
+	}
+}
+
+func TestRenderEndpoint(t *testing.T) {
+	rec := postAPI(handleRender, http.MethodPost, apiTestConfig)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "audio/wav" {
+		t.Errorf("Content-Type = %q, want audio/wav", got)
+	}
+
+	decoded, format, err := wav.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding the rendered WAV: %v", err)
+	}
+	if format.SampleRate != apiSampleRate || format.NumChannels != 2 {
+		t.Errorf("format = %+v, want stereo at %d Hz", format, apiSampleRate)
+	}
+	if want := apiSampleRate.N(500 * time.Millisecond); decoded.Len() != want {
+		t.Errorf("rendered %d frames, want %d", decoded.Len(), want)
+	}
+	if rms := rmsOf(channel(drainFrames(decoded), 0)); rms == 0 {
+		t.Error("the rendered session is silent")
+	}
+
+	// Invalid configurations are rejected with the validation errors
+	rec = postAPI(handleRender, http.MethodPost, apiTestConfig+"harmonics: [1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]\n")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status of an invalid render = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	var resp validationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Valid || len(resp.Errors) == 0 {
+		t.Errorf("response to an invalid render = %+v (%v), want the validation errors", resp, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// beatReportInterval is the time between the points sampled by -beat-report, in seconds.
+const beatReportInterval = 0.1
+
+// beatReportTolerance is the difference in Hz between the effective and configured beat above
+// which -beat-report flags a deviation.
+const beatReportTolerance = 0.01
+
+// beatDeviation is a stretch of time where the effective beat differs from the configured one.
+type beatDeviation struct {
+	start, end float64 // First and last sampled times in seconds
+	largest    float64 // Effective minus configured beat where they differ most, in Hz
+	effective  float64 // Effective beat at that point
+	configured float64 // Configured beat at that point
+}
+
+// beatStats summarizes the effective beat of a session.
+type beatStats struct {
+	min, max, mean float64
+	deviations     []beatDeviation
+}
+
+// measureEffectiveBeat samples the difference between the right and left tone frequencies of
+// the session every beatReportInterval seconds, and compares it with the configured beat.
+func measureEffectiveBeat(session *Session) beatStats {
+	stats := beatStats{min: math.Inf(1), max: math.Inf(-1)}
+	var sum float64
+	var count int
+	var current *beatDeviation
+	for i := 0; float64(i)*beatReportInterval <= session.totalPlaybackTime; i++ {
+		t := float64(i) * beatReportInterval
+		effective := session.rightFreqFunc(t) - session.leftFreqFunc(t)
+		configured := session.beatFreqFunc(t)
+		stats.min = math.Min(stats.min, effective)
+		stats.max = math.Max(stats.max, effective)
+		sum += effective
+		count++
+
+		diff := effective - configured
+		if math.Abs(diff) <= beatReportTolerance {
+			current = nil
+			continue
+		}
+		if current == nil {
+			stats.deviations = append(stats.deviations, beatDeviation{start: t})
+			current = &stats.deviations[len(stats.deviations)-1]
+		}
+		current.end = t
+		if math.Abs(diff) > math.Abs(current.largest) {
+			current.largest, current.effective, current.configured = diff, effective, configured
+		}
+	}
+	stats.mean = sum / float64(count)
+	return stats
+}
+
+// writeBeatReport prints the range of the session's effective beat and any stretches where it
+// deviates from the configured beat.
+func writeBeatReport(w io.Writer, session *Session) {
+	stats := measureEffectiveBeat(session)
+	fmt.Fprintf(w, "Effective beat over %.2f s, sampled every %.2f s:\n", session.totalPlaybackTime, beatReportInterval)
+	fmt.Fprintf(w, "  Min: %.2f Hz, Max: %.2f Hz, Mean: %.2f Hz\n", stats.min, stats.max, stats.mean)
+	if len(stats.deviations) == 0 {
+		fmt.Fprintf(w, "The effective beat matches the configured beat throughout (within %.2f Hz).\n", beatReportTolerance)
+		return
+	}
+	fmt.Fprintf(w, "Deviations from the configured beat of more than %.2f Hz:\n", beatReportTolerance)
+	for _, d := range stats.deviations {
+		fmt.Fprintf(w, "  %.2f s to %.2f s: up to %+.2f Hz (effective %.2f Hz, configured %.2f Hz)\n",
+			d.start, d.end, d.largest, d.effective, d.configured)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMeasureEffectiveBeat(t *testing.T) {
+	tests := []struct {
+		name           string
+		changes        string
+		min, max, mean float64
+	}{
+		{"constant carrier and beat", `
+  - {time: 0, frequency: 200, beat_frequency: 7.5}
+  - {time: 10, frequency: 200, beat_frequency: 7.5}
+`, 7.5, 7.5, 7.5},
+		{"constant carrier, gliding beat", `
+  - {time: 0, frequency: 200, beat_frequency: 10}
+  - {time: 10, frequency: 200, beat_frequency: 4}
+`, 4, 10, 7},
+		{"sweeping carrier", `
+  - {time: 0, frequency: 100, beat_frequency: 6}
+  - {time: 5, frequency: 500, beat_frequency: 6, easing: ease_in_out}
+  - {time: 10, frequency: 150, beat_frequency: 6}
+`, 6, 6, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := newSession(mustParseConfig(t, "frequency_changes:"+tt.changes), testSampleRate, testOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			stats := measureEffectiveBeat(session)
+			if math.Abs(stats.min-tt.min) > 1e-9 || math.Abs(stats.max-tt.max) > 1e-9 || math.Abs(stats.mean-tt.mean) > 1e-9 {
+				t.Errorf("effective beat min %v, max %v, mean %v, want %v, %v, %v", stats.min, stats.max, stats.mean, tt.min, tt.max, tt.mean)
+			}
+			if len(stats.deviations) > 0 {
+				t.Errorf("effective beat deviates from the configured beat: %+v", stats.deviations)
+			}
+
+			var out bytes.Buffer
+			writeBeatReport(&out, session)
+			if !strings.Contains(out.String(), "matches the configured beat throughout") {
+				t.Errorf("report doesn't say the beat matches:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestBeatReportFlagsDeviations(t *testing.T) {
+	// Below a 10 Hz carrier, a beat of -10 Hz would take the right tone under 0 Hz, where it is
+	// held, so the effective beat is smaller than the configured one
+	session, err := newSession(mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 20, beat_frequency: -10}
+  - {time: 2, frequency: 5, beat_frequency: -10}
+  - {time: 3, frequency: 5, beat_frequency: -10}
+`), testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stats := measureEffectiveBeat(session)
+	if len(stats.deviations) != 1 {
+		t.Fatalf("found %d deviations, want one: %+v", len(stats.deviations), stats.deviations)
+	}
+	d := stats.deviations[0]
+	if math.Abs(d.start-1.4) > 1e-9 || d.end != 3 || math.Abs(d.largest-5) > 1e-9 || d.configured != -10 {
+		t.Errorf("deviation = %+v, want 1.4 s to 3 s, up to +5 Hz of a -10 Hz beat", d)
+	}
+	var out bytes.Buffer
+	writeBeatReport(&out, session)
+	if want := "1.40 s to 3.00 s: up to +5.00 Hz (effective -5.00 Hz, configured -10.00 Hz)"; !strings.Contains(out.String(), want) {
+		t.Errorf("report doesn't flag the deviation as %q:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// calibrationSegment is one reference tone of the -calibrate sequence.
+type calibrationSegment struct {
+	label     string
+	frequency float64       // Tone frequency in Hz
+	level     float64       // Peak level in dBFS
+	channel   int           // 0 for left, 1 for right, 2 for both
+	duration  time.Duration // Length of the tone, excluding the surrounding silence
+}
+
+// calibrationSequence is played by -calibrate: a reference tone to set the volume against,
+// then the same tone in each ear to check the channels aren't swapped.
+var calibrationSequence = []calibrationSegment{
+	{label: "1 kHz reference at -20 dBFS, both channels", frequency: 1000, level: -20, channel: 2, duration: 5 * time.Second},
+	{label: "1 kHz at -20 dBFS, left channel only", frequency: 1000, level: -20, channel: 0, duration: 3 * time.Second},
+	{label: "1 kHz at -20 dBFS, right channel only", frequency: 1000, level: -20, channel: 1, duration: 3 * time.Second},
+}
+
+// calibrationGap is the silence before, between and after the calibration tones.
+const calibrationGap = time.Second
+
+// calibrationRamp is the fade at the start and end of each calibration tone, which keeps the
+// tone from clicking on and off.
+const calibrationRamp = 20 * time.Millisecond
+
+// newCalibrationStreamer returns the calibration sequence padded with silence. onSegment is
+// called from the streaming goroutine as each tone starts.
+func newCalibrationStreamer(sr beep.SampleRate, segments []calibrationSegment, onSegment func(calibrationSegment)) beep.Streamer {
+	var parts []beep.Streamer
+	for _, seg := range segments {
+		seg := seg
+		parts = append(parts,
+			beep.Silence(sr.N(calibrationGap)),
+			beep.Callback(func() { onSegment(seg) }),
+			beep.Take(sr.N(seg.duration), newCalibrationTone(sr, seg)),
+		)
+	}
+	parts = append(parts, beep.Silence(sr.N(calibrationGap)))
+	return beep.Seq(parts...)
+}
+
+// newCalibrationTone returns a steady tone peaking at the segment's level, ramped in and out
+// over calibrationRamp.
+func newCalibrationTone(sr beep.SampleRate, seg calibrationSegment) *VariableTone {
+	volume := dbToLinear(seg.level)
+	duration := seg.duration.Seconds()
+	ramp := calibrationRamp.Seconds()
+	return &VariableTone{
+		sr:       sr,
+		headroom: 1, // The level is already below full scale
+		freqFunc: func(t float64) float64 {
+			return seg.frequency
+		},
+		volumeFunc: func(t float64) float64 {
+			gain := 1.0
+			if t < ramp {
+				gain = t / ramp
+			} else if t > duration-ramp {
+				gain = (duration - t) / ramp
+			}
+			return volume * math.Max(0, math.Min(1, gain))
+		},
+		channel: seg.channel,
+	}
+}
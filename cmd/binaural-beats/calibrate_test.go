@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCalibrationSequence(t *testing.T) {
+	var started []string
+	frames := drainFrames(newCalibrationStreamer(testSampleRate, calibrationSequence, func(seg calibrationSegment) {
+		started = append(started, seg.label)
+	}))
+
+	// Four gaps around 5 s, 3 s and 3 s of tones
+	if want := testSampleRate.N(4*calibrationGap + 11*time.Second); len(frames) != want {
+		t.Fatalf("sequence is %d frames, want %d", len(frames), want)
+	}
+	if len(started) != len(calibrationSequence) {
+		t.Fatalf("%d segments started, want %d", len(started), len(calibrationSequence))
+	}
+
+	pos := 0
+	gap := testSampleRate.N(calibrationGap)
+	for i, seg := range calibrationSequence {
+		if started[i] != seg.label {
+			t.Errorf("segment %d started as %q, want %q", i, started[i], seg.label)
+		}
+		for j, frame := range frames[pos : pos+gap] {
+			if frame != [2]float64{} {
+				t.Fatalf("frame %d of the gap before %q = %v, want silence", j, seg.label, frame)
+			}
+		}
+		pos += gap
+
+		n := testSampleRate.N(seg.duration)
+		tone := frames[pos : pos+n]
+		level := dbToLinear(seg.level)
+		for c := 0; c < 2; c++ {
+			amp := toneAmplitude(channel(tone, c), testSampleRate, seg.frequency)
+			wantOn := seg.channel == 2 || seg.channel == c
+			switch {
+			case wantOn && math.Abs(amp-level) > level*0.02:
+				t.Errorf("%q: channel %d has %.4f at %v Hz, want the %.4f reference level", seg.label, c, amp, seg.frequency, level)
+			case !wantOn && amp != 0:
+				t.Errorf("%q: channel %d has %.4f at %v Hz, want it silent", seg.label, c, amp, seg.frequency)
+			}
+		}
+		// The ramps keep the tone from starting or ending at a step
+		if first, last := math.Abs(tone[0][0]+tone[0][1]), math.Abs(tone[n-1][0]+tone[n-1][1]); first > 1e-3 || last > 1e-3 {
+			t.Errorf("%q starts at %.4f and ends at %.4f, want both ramped from silence", seg.label, first, last)
+		}
+		pos += n
+	}
+	for j, frame := range frames[pos:] {
+		if frame != [2]float64{} {
+			t.Fatalf("frame %d of the closing gap = %v, want silence", j, frame)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep"
+)
+
+// OutputChannel maps the tones and noise of the session to one channel of a multichannel
+// export, such as a speaker of a quad array.
+type OutputChannel struct {
+	Tone          string  `yaml:"tone"`           // Tone played in the channel: "left", "right" or "none" (default)
+	CarrierOffset float64 `yaml:"carrier_offset"` // Hz added to the carrier of the channel's tone
+	Noise         string  `yaml:"noise"`          // Noise channel played in the channel: "left", "right" or "none" (default)
+}
+
+// Sources of an output channel.
+const (
+	channelSourceLeft  = "left"
+	channelSourceRight = "right"
+	channelSourceNone  = "none"
+)
+
+// validateChannels checks the output channels of cfg.
+func validateChannels(cfg *Config) error {
+	if len(cfg.Channels) > maxWAVChannels {
+		return fmt.Errorf("%d channels are more than the %d a WAV file can hold here", len(cfg.Channels), maxWAVChannels)
+	}
+	silent := true
+	for i, ch := range cfg.Channels {
+		for _, source := range []struct{ field, value string }{{"tone", ch.Tone}, {"noise", ch.Noise}} {
+			switch source.value {
+			case "", channelSourceLeft, channelSourceRight, channelSourceNone:
+			default:
+				return fmt.Errorf("unknown %s %q of channel %d (expected %q, %q or %q)", source.field, source.value, i+1, channelSourceLeft, channelSourceRight, channelSourceNone)
+			}
+		}
+		if ch.Tone == channelSourceLeft || ch.Tone == channelSourceRight || ch.Noise == channelSourceLeft || ch.Noise == channelSourceRight {
+			silent = false
+		}
+		if ch.CarrierOffset != 0 && (ch.Tone == "" || ch.Tone == channelSourceNone) {
+			return fmt.Errorf("channel %d has a carrier_offset but no tone", i+1)
+		}
+		for _, change := range cfg.FrequencyChanges {
+			if change.Frequency > 0 && change.Frequency+ch.CarrierOffset <= 0 {
+				return fmt.Errorf("carrier_offset %.2f Hz of channel %d takes the carrier at time %.2f below 0 Hz", ch.CarrierOffset, i+1, change.Time)
+			}
+		}
+	}
+	if len(cfg.Channels) > 0 && silent {
+		return errors.New("none of the channels plays a tone or noise")
+	}
+	return nil
+}
+
+// channelSource is a stem of the session, rendered with the carrier shifted by offset Hz.
+type channelSource struct {
+	stem   string
+	offset float64
+}
+
+// channelPick takes one side of a source's stereo frames into an output channel.
+type channelPick struct {
+	source int // Index into the sources of the channelStreamer
+	side   int // 0 for the left channel of the source, 1 for the right
+}
+
+// newChannelStream renders the stems each output channel of cfg needs and returns a streamer
+// of its multichannel frames. Each tone is taken from its stem, so a channel gets only that
+// tone, and the noise from the noise stem; as the stems sum to the mix, two channels taking
+// the left and right tone and noise reproduce the stereo session. Channels with the same tone
+// and carrier_offset share one render.
+func newChannelStream(cfg *Config, sr beep.SampleRate, opts SessionOptions) (*channelStreamer, error) {
+	cs := &channelStreamer{picks: make([][]channelPick, len(cfg.Channels))}
+	sources := map[channelSource]int{}
+	pick := func(source channelSource, side int) (channelPick, error) {
+		index, ok := sources[source]
+		if !ok {
+			shifted := *cfg
+			shifted.FrequencyChanges = append([]ConfigFrequencyChange(nil), cfg.FrequencyChanges...)
+			for i := range shifted.FrequencyChanges {
+				shifted.FrequencyChanges[i].Frequency += source.offset
+			}
+			opts.Stem = source.stem
+			session, err := newSession(&shifted, sr, opts)
+			if err != nil {
+				return channelPick{}, err
+			}
+			index = len(cs.sessions)
+			sources[source] = index
+			cs.sessions = append(cs.sessions, session)
+		}
+		return channelPick{source: index, side: side}, nil
+	}
+
+	for i, ch := range cfg.Channels {
+		if ch.Tone == channelSourceLeft || ch.Tone == channelSourceRight {
+			stem, side := stemTones, 0
+			if ch.Tone == channelSourceRight {
+				side = 1
+			}
+			if isBinaural(cfg) {
+				stem = [2]string{stemLeftTone, stemRightTone}[side]
+			}
+			p, err := pick(channelSource{stem: stem, offset: ch.CarrierOffset}, side)
+			if err != nil {
+				cs.Close()
+				return nil, err
+			}
+			cs.picks[i] = append(cs.picks[i], p)
+		}
+		if ch.Noise == channelSourceLeft || ch.Noise == channelSourceRight {
+			side := 0
+			if ch.Noise == channelSourceRight {
+				side = 1
+			}
+			p, err := pick(channelSource{stem: stemNoise}, side)
+			if err != nil {
+				cs.Close()
+				return nil, err
+			}
+			cs.picks[i] = append(cs.picks[i], p)
+		}
+	}
+	cs.buffers = make([][][2]float64, len(cs.sessions))
+	return cs, nil
+}
+
+// channelStreamer streams the sources of a multichannel session in step and routes them to
+// the output channels.
+type channelStreamer struct {
+	sessions []*Session
+	picks    [][]channelPick // Sides of the sources summed into each output channel
+	buffers  [][][2]float64  // Frames read from each source
+}
+
+// Stream fills frames with the next multichannel frames, each as long as the number of output
+// channels. It returns false once every source has ended.
+func (cs *channelStreamer) Stream(frames [][]float64) (n int, ok bool) {
+	for i, session := range cs.sessions {
+		if cap(cs.buffers[i]) < len(frames) {
+			cs.buffers[i] = make([][2]float64, len(frames))
+		}
+		cs.buffers[i] = cs.buffers[i][:len(frames)]
+		sn, _ := fillSamples(session.streamer, cs.buffers[i])
+		for j := sn; j < len(frames); j++ {
+			cs.buffers[i][j] = [2]float64{}
+		}
+		n = max(n, sn)
+	}
+	for f := range frames[:n] {
+		for c, picks := range cs.picks {
+			frames[f][c] = 0
+			for _, p := range picks {
+				frames[f][c] += cs.buffers[p.source][f][p.side]
+			}
+		}
+	}
+	return n, n > 0
+}
+
+// Err returns the first error of the sources.
+func (cs *channelStreamer) Err() error {
+	for _, session := range cs.sessions {
+		if err := session.streamer.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the resources of the sources.
+func (cs *channelStreamer) Close() {
+	for _, session := range cs.sessions {
+		session.Close()
+	}
+}
+
+// exportChannels renders the output channels of cfg to a multichannel WAV file in format at
+// each of targets.
+func exportChannels(cfg *Config, opts SessionOptions, targets []outputTarget, format beep.Format) error {
+	for _, target := range targets {
+		if target.sampleRate != 0 {
+			return fmt.Errorf("%s: multichannel files are written at the session's rate and can't be resampled", target.path)
+		}
+		if strings.ToLower(filepath.Ext(target.path)) != ".wav" {
+			return fmt.Errorf("%s: multichannel files can only be written as WAV", target.path)
+		}
+	}
+
+	for _, target := range targets {
+		if err := exportChannelFile(cfg, opts, format, target.path); err != nil {
+			return fmt.Errorf("%s: %v", target.path, err)
+		}
+		infof("Wrote %s (%d channels)\n", target.path, format.NumChannels)
+	}
+	return nil
+}
+
+// exportChannelFile renders the output channels of cfg to the WAV file at path.
+func exportChannelFile(cfg *Config, opts SessionOptions, format beep.Format, path string) error {
+	cs, err := newChannelStream(cfg, format.SampleRate, opts)
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	ww, err := NewWAVWriter(outFile, format)
+	if err != nil {
+		return err
+	}
+	meta := cfg.metadata()
+	ww.SetInfo("INAM", meta.Title)
+	ww.SetInfo("IART", meta.Artist)
+	ww.SetInfo("ICMT", meta.Comment)
+
+	frames := make([][]float64, 512)
+	for i := range frames {
+		frames[i] = make([]float64, format.NumChannels)
+	}
+	for {
+		n, ok := cs.Stream(frames)
+		if err := ww.WriteFrames(frames[:n]); err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	if err := cs.Err(); err != nil {
+		return err
+	}
+	return ww.Close()
+}
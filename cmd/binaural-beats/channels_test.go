@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopxl/beep"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExportFourChannels(t *testing.T) {
+	cfg := mustParseConfig(t, `
+channels:
+  - {tone: left}
+  - {tone: right}
+  - {tone: left, carrier_offset: 100}
+  - {tone: right, carrier_offset: -50}
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0}
+`)
+	path := filepath.Join(t.TempDir(), "quad.wav")
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: len(cfg.Channels), Precision: 2}
+	if err := exportChannelFile(cfg, testOptions(), format, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint16(data[22:]); got != 4 {
+		t.Fatalf("header has %d channels, want 4", got)
+	}
+	frames := (len(data) - wavHeaderSize) / format.Width()
+	if frames != int(testSampleRate) {
+		t.Fatalf("file holds %d frames, want %d", frames, testSampleRate)
+	}
+	channels := make([][]float64, 4)
+	for i := 0; i < frames; i++ {
+		for c := range channels {
+			v := int16(binary.LittleEndian.Uint16(data[wavHeaderSize+(i*4+c)*2:]))
+			channels[c] = append(channels[c], float64(v)/math.MaxInt16)
+		}
+	}
+
+	// Each channel holds its own tone and none of the others'
+	freqs := []float64{200, 210, 300, 160}
+	full := toneAmplitude(channels[0], testSampleRate, 200)
+	if full < 0.01 {
+		t.Fatalf("first channel's tone is %.4f, want it audible", full)
+	}
+	for c, samples := range channels {
+		for f, freq := range freqs {
+			got := toneAmplitude(samples, testSampleRate, freq)
+			if f == c && math.Abs(got-full) > 0.01*full {
+				t.Errorf("channel %d has its %.0f Hz tone at %.4f, want %.4f", c+1, freq, got, full)
+			}
+			if f != c && got > 0.01*full {
+				t.Errorf("channel %d has a %.0f Hz tone of %.4f, want only its own", c+1, freq, got)
+			}
+		}
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	tests := []struct {
+		channels string
+		want     string // Expected in the error, or empty for none
+	}{
+		{"[{tone: left, noise: left}, {tone: right, noise: right}, {noise: left}]", ""},
+		{"[{tone: centre}]", `unknown tone "centre" of channel 1`},
+		{"[{tone: left}, {noise: both}]", `unknown noise "both" of channel 2`},
+		{"[{noise: left, carrier_offset: 10}]", "channel 1 has a carrier_offset but no tone"},
+		{"[{tone: left, carrier_offset: -250}]", "takes the carrier at time 0.00 below 0 Hz"},
+		{"[{tone: none}, {noise: none}]", "none of the channels plays a tone or noise"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{FrequencyChanges: []ConfigFrequencyChange{{Time: 0, Frequency: 200}, {Time: 1, Frequency: 200}}}
+		if err := yaml.Unmarshal([]byte(tt.channels), &cfg.Channels); err != nil {
+			t.Fatal(err)
+		}
+		err := validateChannels(cfg)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validateChannels(%s) = %v, want %q", tt.channels, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gopxl/beep"
+)
+
+// sessionDiff summarizes the sample differences between two rendered sessions.
+type sessionDiff struct {
+	framesA     int     // Length of the first session in frames
+	framesB     int     // Length of the second session in frames
+	maxDiff     float64 // Largest absolute difference of any sample
+	sumSquares  float64 // Sum of the squared differences of every sample
+	firstDiffAt int     // First frame that differs, or -1 if none does
+}
+
+// framesCompared returns the number of frames compared so far.
+func (d sessionDiff) framesCompared() int {
+	if d.framesA > d.framesB {
+		return d.framesA
+	}
+	return d.framesB
+}
+
+// rms returns the RMS difference across both channels of the longer session.
+func (d sessionDiff) rms() float64 {
+	frames := d.framesCompared()
+	if frames == 0 {
+		return 0
+	}
+	return math.Sqrt(d.sumSquares / float64(2*frames))
+}
+
+// compareStreams streams a and b to the end and compares them sample by sample. Once the
+// shorter stream ends, it is compared as silence.
+func compareStreams(a, b beep.Streamer) (sessionDiff, error) {
+	diff := sessionDiff{firstDiffAt: -1}
+	bufA := make([][2]float64, 512)
+	bufB := make([][2]float64, 512)
+	aDone, bDone := false, false
+	for !aDone || !bDone {
+		var na, nb int
+		if !aDone {
+			na, aDone = fillSamples(a, bufA)
+		}
+		if !bDone {
+			nb, bDone = fillSamples(b, bufB)
+		}
+		for i := na; i < len(bufA); i++ {
+			bufA[i] = [2]float64{}
+		}
+		for i := nb; i < len(bufB); i++ {
+			bufB[i] = [2]float64{}
+		}
+
+		n := na
+		if nb > n {
+			n = nb
+		}
+		for i := 0; i < n; i++ {
+			for c := range bufA[i] {
+				d := math.Abs(bufA[i][c] - bufB[i][c])
+				if d > 0 && diff.firstDiffAt == -1 {
+					diff.firstDiffAt = diff.framesCompared() + i
+				}
+				diff.maxDiff = math.Max(diff.maxDiff, d)
+				diff.sumSquares += d * d
+			}
+		}
+		diff.framesA += na
+		diff.framesB += nb
+	}
+
+	if err := a.Err(); err != nil {
+		return diff, err
+	}
+	return diff, b.Err()
+}
+
+// fillSamples streams from s until samples is full or s ends. It returns the number of frames
+// streamed and whether s has ended.
+func fillSamples(s beep.Streamer, samples [][2]float64) (n int, done bool) {
+	for n < len(samples) {
+		sn, ok := s.Stream(samples[n:])
+		n += sn
+		if !ok {
+			return n, true
+		}
+	}
+	return n, false
+}
+
+// writeComparison prints the statistics of a comparison between the sessions named a and b.
+func writeComparison(w io.Writer, a, b string, diff sessionDiff, sr beep.SampleRate) {
+	if diff.framesA != diff.framesB {
+		fmt.Fprintf(w, "%s is %.2f s and %s is %.2f s; the shorter one is compared as silence after it ends.\n",
+			a, sr.D(diff.framesA).Seconds(), b, sr.D(diff.framesB).Seconds())
+	}
+	fmt.Fprintf(w, "Compared %d frames (%.2f s).\n", diff.framesCompared(), sr.D(diff.framesCompared()).Seconds())
+	if diff.firstDiffAt == -1 {
+		fmt.Fprintln(w, "The renders are identical.")
+		return
+	}
+	fmt.Fprintf(w, "Max difference: %.6f (%.2f dBFS)\n", diff.maxDiff, linearToDB(diff.maxDiff))
+	fmt.Fprintf(w, "RMS difference: %.6f (%.2f dBFS)\n", diff.rms(), linearToDB(diff.rms()))
+	fmt.Fprintf(w, "First difference: frame %d (%.4f s)\n", diff.firstDiffAt, sr.D(diff.firstDiffAt).Seconds())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompareStreams(t *testing.T) {
+	const base = `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`
+	tests := []struct {
+		name      string
+		other     string
+		identical bool
+		firstDiff int // First differing frame, when not identical
+		framesB   int
+	}{
+		{"identical", base, true, -1, 16000},
+		{"changed last segment", strings.Replace(base, "time: 2, frequency: 200", "time: 2, frequency: 300", 1), false, 8001, 16000},
+		{"shorter", `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`, false, 8000, 8000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newSession(mustParseConfig(t, base), testSampleRate, testOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+			b, err := newSession(mustParseConfig(t, tt.other), testSampleRate, testOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			diff, err := compareStreams(a.streamer, b.streamer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff.framesA != 16000 || diff.framesB != tt.framesB {
+				t.Errorf("compared %d and %d frames, want 16000 and %d", diff.framesA, diff.framesB, tt.framesB)
+			}
+			if tt.identical {
+				if diff.maxDiff != 0 || diff.rms() != 0 || diff.firstDiffAt != -1 {
+					t.Errorf("identical configs differ: max %v, RMS %v, first at %d", diff.maxDiff, diff.rms(), diff.firstDiffAt)
+				}
+				return
+			}
+			if diff.maxDiff == 0 || diff.rms() == 0 {
+				t.Errorf("changed config reports max %v, RMS %v, want both above zero", diff.maxDiff, diff.rms())
+			}
+			if diff.firstDiffAt != tt.firstDiff {
+				t.Errorf("first difference at frame %d, want %d", diff.firstDiffAt, tt.firstDiff)
+			}
+		})
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	var out bytes.Buffer
+	writeComparison(&out, "a.yaml", "b.yaml", sessionDiff{framesA: 8000, framesB: 8000, firstDiffAt: -1}, testSampleRate)
+	if want := "Compared 8000 frames (1.00 s).\nThe renders are identical.\n"; out.String() != want {
+		t.Errorf("identical comparison:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	writeComparison(&out, "a.yaml", "b.yaml", sessionDiff{framesA: 8000, framesB: 4000, maxDiff: 0.5, sumSquares: 4000, firstDiffAt: 4000}, testSampleRate)
+	for _, want := range []string{
+		"a.yaml is 1.00 s and b.yaml is 0.50 s",
+		"Max difference: 0.500000 (-6.02 dBFS)",
+		"RMS difference: 0.500000",
+		"First difference: frame 4000 (0.5000 s)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("comparison is missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/speaker"
+)
+
+// defaultDevice names the system's default output device.
+const defaultDevice = "default"
+
+// audioBackend is the audio output that sessions are played through. Only playback uses it, so
+// exports and dry runs work on machines without an audio device.
+type audioBackend interface {
+	// Devices lists the names of the output devices that can be selected with -device.
+	Devices() ([]string, error)
+	// Init opens device, one of those listed by Devices, at sr with a buffer of bufferSize
+	// frames.
+	Init(device string, sr beep.SampleRate, bufferSize int) error
+	// Play starts playing s alongside anything already playing.
+	Play(s beep.Streamer)
+	// Clear stops everything that is playing.
+	Clear()
+	// Lock and Unlock keep the output from reading the streams while they are changed.
+	Lock()
+	Unlock()
+}
+
+// speakerBackend plays through the beep speaker. It can only open the system's default output
+// and has no API to list or open other devices.
+type speakerBackend struct{}
+
+// Devices lists the default device, the only one the speaker plays to.
+func (speakerBackend) Devices() ([]string, error) {
+	return []string{defaultDevice}, nil
+}
+
+// Init initializes the speaker. The device is always the default, the only one Devices lists.
+func (speakerBackend) Init(device string, sr beep.SampleRate, bufferSize int) error {
+	return speaker.Init(sr, bufferSize)
+}
+
+// Play plays s on the speaker.
+func (speakerBackend) Play(s beep.Streamer) {
+	speaker.Play(s)
+}
+
+// Clear stops everything playing on the speaker.
+func (speakerBackend) Clear() {
+	speaker.Clear()
+}
+
+// Lock locks the speaker.
+func (speakerBackend) Lock() {
+	speaker.Lock()
+}
+
+// Unlock unlocks the speaker.
+func (speakerBackend) Unlock() {
+	speaker.Unlock()
+}
+
+// backend is the audio output used for playback.
+var backend audioBackend = speakerBackend{}
+
+// selectDevice looks up the output device of b named name, ignoring case. If b has no such
+// device, it falls back to the default device and returns false.
+func selectDevice(b audioBackend, name string) (device string, found bool, err error) {
+	devices, err := b.Devices()
+	if err != nil {
+		return "", false, err
+	}
+	for _, d := range devices {
+		if strings.EqualFold(d, name) {
+			return d, true, nil
+		}
+	}
+	return defaultDevice, false, nil
+}
+
+// outputDevice returns the device of the backend to play to for -device name, warning when it
+// is not available and the default is used instead.
+func outputDevice(name string) string {
+	device, found, err := selectDevice(backend, name)
+	if err != nil {
+		fatalf("Error listing output devices: %v", err)
+	}
+	if !found {
+		warnf("Warning: output device %q is not available (see -list-devices); using %s.", name, defaultDevice)
+	}
+	return device
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopxl/beep"
+)
+
+// fakeBackend is an audio backend with a fixed list of devices and no output. It counts the
+// calls that would open or play to the output.
+type fakeBackend struct {
+	devices []string
+	err     error // Returned by Devices
+	initErr error // Returned by Init
+	calls   int
+
+	device     string // Device Init was last called with
+	bufferSize int    // Buffer size Init was last called with
+}
+
+func (b *fakeBackend) Devices() ([]string, error) {
+	return b.devices, b.err
+}
+
+func (b *fakeBackend) Init(device string, sr beep.SampleRate, bufferSize int) error {
+	b.calls++
+	b.device = device
+	b.bufferSize = bufferSize
+	return b.initErr
+}
+
+func (b *fakeBackend) Play(s beep.Streamer) { b.calls++ }
+func (b *fakeBackend) Clear()               { b.calls++ }
+func (b *fakeBackend) Lock()                { b.calls++ }
+func (b *fakeBackend) Unlock()              { b.calls++ }
+
+// panicBackend is an audio backend whose Init panics, as the speaker does on some machines
+// without an audio device.
+type panicBackend struct{ fakeBackend }
+
+func (b *panicBackend) Init(device string, sr beep.SampleRate, bufferSize int) error {
+	panic("no audio device")
+}
+
+// useBackend replaces the audio backend for the rest of the test.
+func useBackend(t *testing.T, b audioBackend) {
+	t.Helper()
+	previous := backend
+	backend = b
+	t.Cleanup(func() { backend = previous })
+}
+
+func TestSelectDevice(t *testing.T) {
+	devices := &fakeBackend{devices: []string{defaultDevice, "Headphones", "HDMI Output"}}
+	tests := []struct {
+		name    string
+		backend audioBackend
+		device  string
+		want    string
+		found   bool
+	}{
+		{"default", devices, "default", defaultDevice, true},
+		{"exact name", devices, "Headphones", "Headphones", true},
+		{"case-insensitive", devices, "hdmi output", "HDMI Output", true},
+		{"unknown falls back to the default", devices, "Speakers", defaultDevice, false},
+		{"prefix is not a match", devices, "Head", defaultDevice, false},
+		{"speaker has only the default", speakerBackend{}, "Headphones", defaultDevice, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := selectDevice(tt.backend, tt.device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || found != tt.found {
+				t.Errorf("selectDevice(%q) = %q, %v, want %q, %v", tt.device, got, found, tt.want, tt.found)
+			}
+		})
+	}
+
+	t.Run("enumeration error", func(t *testing.T) {
+		failed := errors.New("no sound server")
+		if _, _, err := selectDevice(&fakeBackend{err: failed}, "default"); !errors.Is(err, failed) {
+			t.Errorf("error = %v, want %v", err, failed)
+		}
+	})
+}
+
+func TestOutputDeviceOpensSelection(t *testing.T) {
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{"hdmi output", "HDMI Output"},
+		{"Speakers", defaultDevice},
+	}
+	for _, tt := range tests {
+		fake := &fakeBackend{devices: []string{defaultDevice, "HDMI Output"}}
+		useBackend(t, fake)
+		if err := initSpeaker(outputDevice(tt.device), testSampleRate, 512); err != nil {
+			t.Fatal(err)
+		}
+		if fake.device != tt.want {
+			t.Errorf("-device %q opened %q, want %q", tt.device, fake.device, tt.want)
+		}
+	}
+}
+
+func TestInitSpeakerFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend audioBackend
+	}{
+		{"error", &fakeBackend{initErr: errors.New("no audio device")}},
+		{"panic", &panicBackend{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBackend(t, tt.backend)
+			if err := initSpeaker(defaultDevice, testSampleRate, 512); err == nil {
+				t.Error("initSpeaker succeeded without an audio device")
+			}
+		})
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices []string
+		want    string
+	}{
+		{"default only", []string{defaultDevice}, defaultDevice + " (the system's default output)\n"},
+		{"several", []string{defaultDevice, "Headphones"}, defaultDevice + " (the system's default output)\nHeadphones\n"},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBackend{devices: tt.devices}
+			useBackend(t, fake)
+			out := captureStdout(t, func() { runMain(t, "-list-devices") })
+			if out != tt.want {
+				t.Errorf("-list-devices printed %q, want %q", out, tt.want)
+			}
+			if fake.calls > 0 {
+				t.Errorf("listing devices made %d calls that open the output, want none", fake.calls)
+			}
+		})
+	}
+}
+
+func TestSpeakerBufferSize(t *testing.T) {
+	tests := []struct {
+		sr   beep.SampleRate
+		ms   int
+		want int
+	}{
+		{44100, 100, 4410}, // The default, the tenth of a second the speaker always used
+		{44100, minSpeakerBufferMS, 441},
+		{44100, maxSpeakerBufferMS, 44100},
+		{48000, 25, 1200},
+		{22050, 15, 330}, // 330.75 frames, rounded down to whole frames
+	}
+	for _, tt := range tests {
+		if got := speakerBufferSize(tt.sr, tt.ms); got != tt.want {
+			t.Errorf("speakerBufferSize(%d, %d) = %d, want %d", tt.sr, tt.ms, got, tt.want)
+		}
+	}
+
+	fake := &fakeBackend{}
+	useBackend(t, fake)
+	if err := initSpeaker(defaultDevice, 48000, speakerBufferSize(48000, 25)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.bufferSize != 1200 {
+		t.Errorf("backend initialized with a %d-frame buffer, want 1200", fake.bufferSize)
+	}
+}
+
+func TestExportWithoutAudioDevice(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"export", []string{"-config", config, "-seed", "1", "-output", filepath.Join(dir, "out.wav")}},
+		{"stems", []string{"-config", config, "-seed", "1", "-stems", filepath.Join(dir, "stems")}},
+		{"sample dump", []string{"-config", config, "-seed", "1", "-dump-samples", filepath.Join(dir, "samples.f32")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBackend{initErr: errors.New("no audio device")}
+			useBackend(t, fake)
+			runMain(t, tt.args...)
+			if fake.calls > 0 {
+				t.Errorf("%s made %d calls to the audio backend, want none", tt.name, fake.calls)
+			}
+		})
+	}
+	if _, frames := readTestWAV(t, filepath.Join(dir, "out.wav")); len(frames) != 44100 {
+		t.Errorf("exported %d frames, want 44100", len(frames))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+
+	"github.com/gopxl/beep"
+)
+
+// dumpSamples streams s to path as raw interleaved little-endian 32-bit float stereo samples,
+// left then right for each frame, with no header and no clamping. It returns the number of
+// frames written.
+func dumpSamples(path string, s beep.Streamer) (frames int, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	samples := make([][2]float64, 512)
+	buf := make([]byte, len(samples)*8)
+	for {
+		n, ok := s.Stream(samples)
+		for i, sample := range samples[:n] {
+			binary.LittleEndian.PutUint32(buf[i*8:], math.Float32bits(float32(sample[0])))
+			binary.LittleEndian.PutUint32(buf[i*8+4:], math.Float32bits(float32(sample[1])))
+		}
+		if _, err := w.Write(buf[:n*8]); err != nil {
+			return frames, err
+		}
+		frames += n
+		if !ok {
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		return frames, err
+	}
+	if err := w.Flush(); err != nil {
+		return frames, err
+	}
+	return frames, f.Close()
+}
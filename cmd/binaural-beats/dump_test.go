@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpSamples(t *testing.T) {
+	cfg := testSession(t)
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	path := filepath.Join(t.TempDir(), "samples.f32")
+	frames, err := dumpSamples(path, session.streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != session.totalSamples {
+		t.Errorf("dumped %d frames, want the session's %d", frames, session.totalSamples)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(data)/4, session.totalSamples*2; len(data)%4 != 0 || got != want {
+		t.Fatalf("file holds %d bytes, want %d interleaved float32 samples", len(data), want)
+	}
+
+	// The samples are the unquantized mix, left then right for each frame
+	mix := renderFrames(t, cfg, testSampleRate, testOptions())
+	for i, frame := range mix {
+		for c, want := range frame {
+			got := math.Float32frombits(binary.LittleEndian.Uint32(data[(i*2+c)*4:]))
+			if got != float32(want) {
+				t.Fatalf("sample %d of frame %d = %v, want %v", c, i, got, float32(want))
+			}
+		}
+	}
+}
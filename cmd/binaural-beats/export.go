@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// encodeFunc writes all audio streamed from s to w in a particular file format, tagged with meta.
+type encodeFunc func(w io.WriteSeeker, s beep.Streamer, format beep.Format, meta exportMetadata) error
+
+// encoders maps lower-case output file extensions to the encoder for that format.
+var encoders = map[string]encodeFunc{
+	".wav": encodeWAV,
+}
+
+// splitChunkSize is the number of samples handed to each branch of a split streamer at once.
+const splitChunkSize = 4096
+
+// exportProgressInterval is the length of audio exported between the progress lines printed
+// with -verbose.
+const exportProgressInterval = time.Minute
+
+// exportProgress reports with debugf how much of a stream has been exported.
+type exportProgress struct {
+	stream beep.Streamer
+	sr     beep.SampleRate
+	total  int // Expected length of the stream in frames
+	pos    int
+	next   int // Frame after which the next line is printed
+}
+
+// newExportProgress reports the progress of exporting stream, which is expected to be total
+// frames long.
+func newExportProgress(stream beep.Streamer, sr beep.SampleRate, total int) *exportProgress {
+	return &exportProgress{stream: stream, sr: sr, total: total, next: sr.N(exportProgressInterval)}
+}
+
+// Stream passes the samples on, printing a line each exportProgressInterval of audio.
+func (ep *exportProgress) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = ep.stream.Stream(samples)
+	ep.pos += n
+	for ep.pos >= ep.next && ep.total > 0 {
+		debugf("Exported %.0f s of %.0f s (%.0f%%)\n", ep.sr.D(ep.next).Seconds(), ep.sr.D(ep.total).Seconds(), 100*float64(ep.next)/float64(ep.total))
+		ep.next += ep.sr.N(exportProgressInterval)
+	}
+	return n, ok
+}
+
+// Err returns the error state of the stream.
+func (ep *exportProgress) Err() error {
+	return ep.stream.Err()
+}
+
+// outputTarget is one file to export and the sample rate to write it at.
+type outputTarget struct {
+	path       string
+	sampleRate beep.SampleRate // Rate of the file, or 0 for the session's rate
+}
+
+// String returns the path of the output, with its sample rate if it is resampled.
+func (ot outputTarget) String() string {
+	if ot.sampleRate == 0 {
+		return ot.path
+	}
+	return fmt.Sprintf("%s@%d", ot.path, ot.sampleRate)
+}
+
+// parseOutputTargets splits a comma-separated list of output paths, each optionally followed
+// by @ and a sample rate in Hz, and checks that each one has a supported extension.
+func parseOutputTargets(list string) ([]outputTarget, error) {
+	var targets []outputTarget
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		target := outputTarget{path: item}
+		// Only an all-digit suffix is a rate, so paths such as mix@home/out.wav are kept whole
+		if at := strings.LastIndex(item, "@"); at >= 0 && isDigits(item[at+1:]) {
+			rate, err := strconv.Atoi(item[at+1:])
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("invalid sample rate %q for %s (expected a positive number of Hz)", item[at+1:], item[:at])
+			}
+			target = outputTarget{path: item[:at], sampleRate: beep.SampleRate(rate)}
+		}
+		if _, ok := encoders[strings.ToLower(filepath.Ext(target.path))]; !ok {
+			return nil, fmt.Errorf("unsupported output format for %s (supported: .wav)", target.path)
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no output paths given")
+	}
+	return targets, nil
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// targetStream returns s resampled from format's rate to the target's, and the format to
+// encode it with. quality is passed to beep.Resample.
+func targetStream(s beep.Streamer, format beep.Format, target outputTarget, quality int) (beep.Streamer, beep.Format) {
+	if target.sampleRate == 0 || target.sampleRate == format.SampleRate {
+		return s, format
+	}
+	resampled := beep.Resample(quality, format.SampleRate, target.sampleRate, s)
+	format.SampleRate = target.sampleRate
+	return resampled, format
+}
+
+// fileSizeUnits are the units accepted by parseFileSize, in bytes.
+var fileSizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"kb": 1000,
+	"mb": 1000 * 1000,
+	"gb": 1000 * 1000 * 1000,
+}
+
+// parseFileSize parses a size such as 4000000, 500MB or 2GB into bytes. Units are decimal, so
+// 1MB is 1,000,000 bytes.
+func parseFileSize(text string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(text))
+	number := strings.TrimRight(lower, "bkmg")
+	unit, ok := fileSizeUnits[lower[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q (expected B, KB, MB or GB)", lower[len(number):])
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("expected a positive size such as 500MB")
+	}
+	return int64(v * float64(unit)), nil
+}
+
+// formatFileSize formats a size in bytes in the largest decimal unit that keeps it above 1.
+func formatFileSize(size int64) string {
+	switch {
+	case size >= fileSizeUnits["gb"]:
+		return fmt.Sprintf("%.2f GB", float64(size)/float64(fileSizeUnits["gb"]))
+	case size >= fileSizeUnits["mb"]:
+		return fmt.Sprintf("%.1f MB", float64(size)/float64(fileSizeUnits["mb"]))
+	case size >= fileSizeUnits["kb"]:
+		return fmt.Sprintf("%.1f KB", float64(size)/float64(fileSizeUnits["kb"]))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// estimateWAVSize returns the size in bytes of a WAV file of frames at format's rate, written
+// at the target's rate. Metadata tags are not counted.
+func estimateWAVSize(frames int, format beep.Format, target outputTarget) int64 {
+	rate := format.SampleRate
+	if target.sampleRate != 0 {
+		rate = target.sampleRate
+	}
+	targetFrames := int64(math.Ceil(float64(frames) * float64(rate) / float64(format.SampleRate)))
+	header := int64(wavHeaderSize)
+	if format.Precision == wavFloatPrecision {
+		header = floatWAVHeaderSize
+	}
+	return header + targetFrames*int64(format.Width())
+}
+
+// checkExportSize estimates the size of each output of frames in format and returns an error
+// naming the first that would be larger than limit bytes.
+func checkExportSize(frames int, format beep.Format, targets []outputTarget, limit int64) error {
+	for _, target := range targets {
+		if size := estimateWAVSize(frames, format, target); size > limit {
+			return fmt.Errorf("%s would be about %s, over the -max-file-size limit of %s", target.path, formatFileSize(size), formatFileSize(limit))
+		}
+	}
+	return nil
+}
+
+// exportSession renders the streamer once and encodes it to every output concurrently,
+// resampling the outputs that have their own sample rate.
+func exportSession(s beep.Streamer, format beep.Format, meta exportMetadata, targets []outputTarget, quality int) error {
+	branches := splitStreamer(s, len(targets))
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target outputTarget) {
+			defer wg.Done()
+			stream, targetFormat := targetStream(branches[i], format, target, quality)
+			errs[i] = exportFile(stream, targetFormat, meta, target.path)
+			if errs[i] != nil {
+				// Keep consuming so the other outputs aren't blocked
+				drain(branches[i])
+				return
+			}
+			infof("Wrote %s (%d Hz)\n", target.path, targetFormat.SampleRate)
+		}(i, target)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s: %v", targets[i].path, err)
+		}
+	}
+	return nil
+}
+
+// exportFile encodes the streamer to path using the encoder for its extension.
+func exportFile(s beep.Streamer, format beep.Format, meta exportMetadata, path string) error {
+	encode := encoders[strings.ToLower(filepath.Ext(path))]
+
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	return encode(outFile, s, format, meta)
+}
+
+// exportStems renders each stem of the session separately to a 16-bit WAV file named after the
+// stem in dir. Every stem goes through the same fades, pans and gain as the full mix, so the
+// stems sum to it. The intro is not included.
+func exportStems(cfg *Config, sr beep.SampleRate, opts SessionOptions, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	format := beep.Format{
+		SampleRate:  sr,
+		NumChannels: 2,
+		Precision:   2, // 16-bit audio
+	}
+	for _, stem := range sessionStems(cfg) {
+		opts.Stem = stem
+		session, err := newSession(cfg, sr, opts)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, stem+".wav")
+		err = exportFile(session.streamer, format, cfg.metadata(), path)
+		session.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		infof("Wrote %s\n", path)
+	}
+	return nil
+}
+
+// drain streams s until it is exhausted, discarding the samples.
+func drain(s beep.Streamer) {
+	samples := make([][2]float64, splitChunkSize)
+	for {
+		if _, ok := s.Stream(samples); !ok {
+			return
+		}
+	}
+}
+
+// splitStreamer fans s out to n streamers that each receive every sample. The source is
+// streamed once in a background goroutine, so all branches must be consumed concurrently.
+func splitStreamer(s beep.Streamer, n int) []beep.Streamer {
+	chans := make([]chan [][2]float64, n)
+	branches := make([]beep.Streamer, n)
+	for i := range chans {
+		chans[i] = make(chan [][2]float64, 16)
+		branches[i] = &splitBranch{source: s, chunks: chans[i]}
+	}
+
+	go func() {
+		for {
+			chunk := make([][2]float64, splitChunkSize)
+			sn, ok := s.Stream(chunk)
+			if sn > 0 {
+				for _, ch := range chans {
+					ch <- chunk[:sn]
+				}
+			}
+			if !ok {
+				break
+			}
+		}
+		for _, ch := range chans {
+			close(ch)
+		}
+	}()
+
+	return branches
+}
+
+// splitBranch is one of the streamers returned by splitStreamer.
+type splitBranch struct {
+	source  beep.Streamer
+	chunks  chan [][2]float64
+	pending [][2]float64
+}
+
+// Stream copies samples from the chunks received from the source.
+func (sb *splitBranch) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) {
+		if len(sb.pending) == 0 {
+			chunk, open := <-sb.chunks
+			if !open {
+				break
+			}
+			sb.pending = chunk
+		}
+		copied := copy(samples[n:], sb.pending)
+		sb.pending = sb.pending[copied:]
+		n += copied
+	}
+	return n, n > 0
+}
+
+// Err returns the error state of the source streamer.
+func (sb *splitBranch) Err() error {
+	return sb.source.Err()
+}
+
+// verifyWAV decodes the WAV file at path and compares it sample by sample against expected.
+// It returns the largest deviation found, and an error if the file doesn't match the format
+// or any sample differs by more than one quantization step.
+func verifyWAV(path string, expected beep.Streamer, format beep.Format) (float64, error) {
+	decoded, decodedFormat, err := loadWAV(path)
+	if err != nil {
+		return 0, err
+	}
+	defer decoded.Close()
+
+	if decodedFormat != format {
+		return 0, fmt.Errorf("file format %+v does not match %+v", decodedFormat, format)
+	}
+
+	step := 1 / (math.Exp2(float64(format.Precision*8-1)) - 1)
+	want := make([][2]float64, 512)
+	got := make([][2]float64, 512)
+	var maxDeviation float64
+	frame := 0
+	for {
+		n, ok := expected.Stream(want)
+		m := 0
+		for m < n {
+			dn, dok := decoded.Stream(got[m:n])
+			m += dn
+			// The decoder of a truncated file keeps returning no frames rather than ending
+			if !dok || dn == 0 {
+				break
+			}
+		}
+		if m < n {
+			return maxDeviation, fmt.Errorf("file ends after %d frames, expected more", frame+m)
+		}
+
+		if format.NumChannels == 1 {
+			// Mono files hold the average of both channels, decoded into each
+			for i := range want[:n] {
+				mono := (want[i][0] + want[i][1]) / 2
+				want[i] = [2]float64{mono, mono}
+			}
+		}
+		for i := range want[:n] {
+			for c := 0; c < 2; c++ {
+				deviation := math.Abs(math.Max(-1, math.Min(1, want[i][c])) - got[i][c])
+				maxDeviation = math.Max(maxDeviation, deviation)
+				if deviation > step+1e-12 {
+					return maxDeviation, fmt.Errorf("frame %d deviates by %.6f, more than the quantization step %.6f", frame+i, deviation, step)
+				}
+			}
+		}
+		frame += n
+
+		if !ok {
+			break
+		}
+	}
+
+	if dn, _ := decoded.Stream(got[:1]); dn > 0 {
+		return maxDeviation, fmt.Errorf("file has more than the expected %d frames", frame)
+	}
+
+	return maxDeviation, nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// readTestWAV decodes the WAV file at path and returns its format and frames.
+func readTestWAV(t *testing.T, path string) (beep.Format, [][2]float64) {
+	t.Helper()
+	decoded, format, err := loadWAV(path)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+	defer decoded.Close()
+	return format, drainFrames(decoded)
+}
+
+// encodeFloatStub is an encoder for tests that writes each sample as a little-endian float64.
+func encodeFloatStub(w io.WriteSeeker, s beep.Streamer, format beep.Format, meta exportMetadata) error {
+	for _, frame := range drainFrames(s) {
+		for _, v := range frame {
+			if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// testSession returns a short session with tones and noise.
+func testSession(t *testing.T) *Config {
+	t.Helper()
+	return mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 300, beat_frequency: 6, tone_volume: 0.4, pink_noise_volume: 0.2}
+`)
+}
+
+func TestExportToSeveralFormats(t *testing.T) {
+	encoders[".stub"] = encodeFloatStub
+	t.Cleanup(func() { delete(encoders, ".stub") })
+
+	dir := t.TempDir()
+	targets, err := parseOutputTargets(filepath.Join(dir, "master.wav") + "," + filepath.Join(dir, "share.stub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testSession(t)
+	want := renderFrames(t, cfg, testSampleRate, testOptions())
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: 2, Precision: 2}
+	if err := exportSession(session.streamer, format, exportMetadata{}, targets, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// The WAV holds the session quantized to 16 bits
+	wavFormat, got := readTestWAV(t, targets[0].path)
+	if wavFormat != format {
+		t.Errorf("WAV format = %+v, want %+v", wavFormat, format)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("WAV has %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		for c := 0; c < 2; c++ {
+			if math.Abs(got[i][c]-want[i][c]) > 1.0/32767 {
+				t.Fatalf("WAV frame %d = %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// The stub encoder received exactly the same samples from the one render
+	data, err := os.ReadFile(targets[1].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(want)*16 {
+		t.Fatalf("stub output has %d bytes, want %d", len(data), len(want)*16)
+	}
+	for i := range want {
+		for c := 0; c < 2; c++ {
+			v := math.Float64frombits(binary.LittleEndian.Uint64(data[(2*i+c)*8:]))
+			if v != want[i][c] {
+				t.Fatalf("stub frame %d channel %d = %v, want %v", i, c, v, want[i][c])
+			}
+		}
+	}
+}
+
+func TestVerifyWAV(t *testing.T) {
+	cfg := testSession(t)
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: 2, Precision: 2}
+	render := func() beep.Streamer {
+		session, err := newSession(cfg, testSampleRate, testOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { session.Close() })
+		return session.streamer
+	}
+	path := filepath.Join(t.TempDir(), "session.wav")
+	if err := exportSession(render(), format, exportMetadata{}, []outputTarget{{path: path}}, 4); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Index(written, []byte("data")) + 8
+
+	tests := []struct {
+		name    string
+		tamper  func(file []byte) []byte
+		wantErr bool
+	}{
+		{"as written", func(file []byte) []byte { return file }, false},
+		{"one sample changed", func(file []byte) []byte {
+			binary.LittleEndian.PutUint16(file[data+400:], binary.LittleEndian.Uint16(file[data+400:])+40)
+			return file
+		}, true},
+		{"zeroed block", func(file []byte) []byte {
+			copy(file[data+1000:data+1100], make([]byte, 100))
+			return file
+		}, true},
+		{"truncated", func(file []byte) []byte { return file[:len(file)-400] }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "tampered.wav")
+			if err := os.WriteFile(tampered, tt.tamper(bytes.Clone(written)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			deviation, err := verifyWAV(tampered, render(), format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyWAV error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && deviation > 1.0/32767 {
+				t.Errorf("max deviation %.6f is more than one quantization step", deviation)
+			}
+		})
+	}
+
+	t.Run("wrong format", func(t *testing.T) {
+		float := beep.Format{SampleRate: testSampleRate, NumChannels: 2, Precision: 4}
+		if _, err := verifyWAV(path, render(), float); err == nil {
+			t.Error("verifyWAV accepted a 16-bit file as 32-bit")
+		}
+	})
+}
+
+func TestStemsSumToMix(t *testing.T) {
+	cfg := testSession(t)
+	dir := t.TempDir()
+	if err := exportStems(cfg, testSampleRate, testOptions(), dir); err != nil {
+		t.Fatal(err)
+	}
+	mix := renderFrames(t, cfg, testSampleRate, testOptions())
+
+	sum := make([][2]float64, len(mix))
+	for _, stem := range []string{stemLeftTone, stemRightTone, stemNoise} {
+		_, frames := readTestWAV(t, filepath.Join(dir, stem+".wav"))
+		if len(frames) != len(mix) {
+			t.Fatalf("%s stem has %d frames, want %d", stem, len(frames), len(mix))
+		}
+		for i, frame := range frames {
+			sum[i][0] += frame[0]
+			sum[i][1] += frame[1]
+		}
+	}
+	// Each stem is quantized to 16 bits, so the sum is off by up to a step per stem
+	const tolerance = 3.0 / (1 << 15)
+	for i := range mix {
+		for c := 0; c < 2; c++ {
+			if d := math.Abs(sum[i][c] - mix[i][c]); d > tolerance {
+				t.Fatalf("stems sum to %.5f at frame %d channel %d, mix is %.5f", sum[i][c], i, c, mix[i][c])
+			}
+		}
+	}
+}
+
+func TestDownmixBeatHasBeatEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 2, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 2, frequency: 200, beat_frequency: 2, tone_volume: 0.5, pink_noise_volume: 0}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stereoPath, monoPath := filepath.Join(dir, "stereo.wav"), filepath.Join(dir, "mono.wav")
+	runMain(t, "-config", config, "-seed", "1", "-output", stereoPath)
+	runMain(t, "-config", config, "-seed", "1", "-output", monoPath, "-downmix-beat")
+
+	format, mono := readTestWAV(t, monoPath)
+	if format.NumChannels != 1 {
+		t.Fatalf("-downmix-beat wrote %d channels, want 1", format.NumChannels)
+	}
+	_, stereo := readTestWAV(t, stereoPath)
+
+	// Both tones start in phase, so the sum peaks every half second and cancels in between
+	windowRMS := func(frames [][2]float64, at float64) float64 {
+		center := format.SampleRate.N(time.Duration(at * float64(time.Second)))
+		window := format.SampleRate.N(10 * time.Millisecond)
+		return rmsOf(channel(frames[center-window/2:center+window/2], 0))
+	}
+	for _, peak := range []float64{0.5, 1, 1.5} {
+		peakRMS, nullRMS := windowRMS(mono, peak), windowRMS(mono, peak-0.25)
+		if nullRMS > peakRMS/10 {
+			t.Errorf("summed channel RMS is %.4f at the beat peak at %.2fs and %.4f at the null before, want a deep envelope", peakRMS, peak, nullRMS)
+		}
+	}
+	// A single channel of the stereo export holds one steady tone
+	if left, ratio := windowRMS(stereo, 0.25), windowRMS(stereo, 0.5)/windowRMS(stereo, 0.25); left == 0 || math.Abs(ratio-1) > 0.05 {
+		t.Errorf("left channel of the stereo export varies by %.3f between beat peak and null, want steady", ratio)
+	}
+}
+
+func TestExportMetadataTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		want   [][2]string // INFO tags expected in the file
+	}{
+		{"all fields", "title: Deep Rest\nartist: Someone\ncomment: Theta session\n", [][2]string{{"INAM", "Deep Rest"}, {"IART", "Someone"}, {"ICMT", "Theta session"}}},
+		{"title only", "title: Focus\n", [][2]string{{"INAM", "Focus"}}},
+		{"none", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config, output := filepath.Join(dir, "session.yaml"), filepath.Join(dir, "out.wav")
+			if err := os.WriteFile(config, []byte(tt.fields+`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			runMain(t, "-config", config, "-seed", "1", "-output", output)
+
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if bytes.Contains(data, []byte("INFO")) {
+					t.Error("file has an INFO chunk without any metadata fields")
+				}
+				return
+			}
+			if !bytes.HasSuffix(data, infoChunk(tt.want)) {
+				t.Errorf("file doesn't end with the INFO chunk %q", infoChunk(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseOutputTargets(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []outputTarget
+		wantErr string
+	}{
+		{"out.wav", []outputTarget{{path: "out.wav"}}, ""},
+		{"master.wav@48000, share.wav", []outputTarget{{path: "master.wav", sampleRate: 48000}, {path: "share.wav"}}, ""},
+		// An @ followed by anything but digits is part of the path
+		{"mix@home/out.wav", []outputTarget{{path: "mix@home/out.wav"}}, ""},
+		{"take@2/out.wav@22050", []outputTarget{{path: "take@2/out.wav", sampleRate: 22050}}, ""},
+		{"out.wav@0", nil, `invalid sample rate "0" for out.wav`},
+		{"out.wav@48k", nil, "unsupported output format for out.wav@48k"},
+		{"out.flac", nil, "unsupported output format for out.flac"},
+		{" , ", nil, "no output paths given"},
+	}
+	for _, tt := range tests {
+		targets, err := parseOutputTargets(tt.list)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseOutputTargets(%q) error = %v, want %q", tt.list, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseOutputTargets(%q): %v", tt.list, err)
+			continue
+		}
+		if fmt.Sprint(targets) != fmt.Sprint(tt.want) {
+			t.Errorf("parseOutputTargets(%q) = %v, want %v", tt.list, targets, tt.want)
+		}
+	}
+}
+
+func TestOutputsAtTheirOwnRates(t *testing.T) {
+	dir := t.TempDir()
+	targets, err := parseOutputTargets(filepath.Join(dir, "master.wav@16000") + "," + filepath.Join(dir, "share.wav@11025") + "," + filepath.Join(dir, "base.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := newSession(testSession(t), testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: 2, Precision: 2}
+	if err := exportSession(session.streamer, format, exportMetadata{}, targets, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		rate beep.SampleRate
+	}{{"master.wav", 16000}, {"share.wav", 11025}, {"base.wav", testSampleRate}} {
+		got, frames := readTestWAV(t, filepath.Join(dir, tt.name))
+		if got.SampleRate != tt.rate {
+			t.Errorf("%s header reports %d Hz, want %d", tt.name, got.SampleRate, tt.rate)
+		}
+		// One second of audio at each rate, give or take the resampler's edges
+		if want := int(tt.rate); math.Abs(float64(len(frames)-want)) > float64(want)/100 {
+			t.Errorf("%s holds %d frames, want about %d", tt.name, len(frames), want)
+		}
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int64
+		wantErr string
+	}{
+		{"4000000", 4000000, ""},
+		{"512b", 512, ""},
+		{"1.5KB", 1500, ""},
+		{"500MB", 500 * 1000 * 1000, ""},
+		{" 2gb ", 2 * 1000 * 1000 * 1000, ""},
+		{" 5MBB", 0, `unknown unit "mbb"`}, // The unit is taken from the trimmed text
+		{"5kg", 0, `unknown unit "kg"`},
+		{"0MB", 0, "positive size"},
+		{"-3", 0, "positive size"},
+		{"lots", 0, "positive size"},
+	}
+	for _, tt := range tests {
+		got, err := parseFileSize(tt.text)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFileSize(%q) error = %v, want %q", tt.text, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseFileSize(%q) = %d, %v, want %d", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestMaxFileSizeRefusesLargeExports(t *testing.T) {
+	writeConfig := func(dir string) string {
+		config := filepath.Join(dir, "session.yaml")
+		os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`), 0644)
+		return config
+	}
+	// One second of 16-bit stereo at 44.1 kHz is 176,444 bytes with the header
+	if dir := os.Getenv(fatalfChildEnv); dir != "" {
+		runMain(t, "-config", writeConfig(dir), "-output", filepath.Join(dir, "refused.wav"), "-max-file-size", "100KB")
+		t.Fatal("main returned despite the export being over -max-file-size")
+	}
+
+	format := beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}
+	targets := []outputTarget{{path: "full.wav"}, {path: "half.wav", sampleRate: 22050}}
+	if err := checkExportSize(44100, format, targets, 176444); err != nil {
+		t.Errorf("exports at the limit were refused: %v", err)
+	}
+	if err := checkExportSize(44100, format, targets[1:], 100000); err != nil {
+		t.Errorf("a half-rate export of 88,244 bytes was refused under a 100 KB limit: %v", err)
+	}
+	if err := checkExportSize(44100, format, targets, 176443); err == nil || !strings.Contains(err.Error(), "full.wav would be about 176.4 KB, over the -max-file-size limit of 176.4 KB") {
+		t.Errorf("export over the limit gave %v, want it refused", err)
+	}
+
+	// Over the limit, main exits before writing anything
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMaxFileSizeRefusesLargeExports$")
+	cmd.Env = append(os.Environ(), fatalfChildEnv+"="+dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("child exited with %v, want status 1\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Refusing to export") || !strings.Contains(stderr.String(), "use -force") {
+		t.Errorf("child didn't report the refused export:\n%s", stderr.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "refused.wav")); !os.IsNotExist(err) {
+		t.Errorf("refused export was written: %v", err)
+	}
+
+	// With -force it is written in full
+	output := filepath.Join(dir, "forced.wav")
+	runMain(t, "-config", writeConfig(dir), "-seed", "1", "-output", output, "-max-file-size", "100KB", "-force")
+	if _, frames := readTestWAV(t, output); len(frames) != 44100 {
+		t.Errorf("forced export holds %d frames, want 44100", len(frames))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// requiredChangeFields are the frequency change fields written even when zero.
+var requiredChangeFields = map[string]bool{
+	"time":              true,
+	"frequency":         true,
+	"beat_frequency":    true,
+	"pink_noise_volume": true,
+	"tone_volume":       true,
+}
+
+// formatConfigFile rewrites filename in the canonical form produced by formatConfig.
+func formatConfigFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := formatConfig(data)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, formatted, info.Mode().Perm())
+}
+
+// formatConfig returns the configuration in canonical form: frequency changes sorted by time,
+// fields in a fixed order, optional fields left out when unset and volumes rounded. Tone set
+// references are written out in full. Formatting its own output returns it unchanged. Comments
+// are not preserved.
+func formatConfig(data []byte) ([]byte, error) {
+	cfg, err := parseConfigData(data, false)
+	if err != nil {
+		return nil, err
+	}
+	return encodeConfig(cfg)
+}
+
+// encodeConfig writes a parsed configuration in the canonical form of formatConfig. cfg is
+// left unchanged.
+func encodeConfig(parsed *Config) ([]byte, error) {
+	cfg := *parsed
+	cfg.FrequencyChanges = append([]ConfigFrequencyChange(nil), parsed.FrequencyChanges...)
+	cfg.ToneSets = nil
+	for i := range cfg.FrequencyChanges {
+		fc := &cfg.FrequencyChanges[i]
+		fc.Use = ""
+		fc.PinkNoiseVolume = roundTo(fc.PinkNoiseVolume, 4)
+		fc.ToneVolume = roundTo(fc.ToneVolume, 4)
+		if fc.PinkNoiseVolumeDB != nil {
+			db := roundTo(*fc.PinkNoiseVolumeDB, 2)
+			fc.PinkNoiseVolumeDB = &db
+		}
+	}
+
+	var root yaml.Node
+	if err := root.Encode(&cfg); err != nil {
+		return nil, err
+	}
+
+	// Write the top-level options first and the frequency changes last
+	var options, changes []*yaml.Node
+	for j := 0; j+1 < len(root.Content); j += 2 {
+		key, value := root.Content[j], root.Content[j+1]
+		if key.Value == "frequency_changes" {
+			for k, item := range value.Content {
+				item.Content = formatChangeNode(item.Content, cfg.FrequencyChanges[k])
+			}
+			changes = append(changes, key, value)
+			continue
+		}
+		if isZeroNode(value) {
+			continue
+		}
+		options = append(options, key, value)
+	}
+	root.Content = append(options, changes...)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatChangeNode drops unset optional fields from the encoded frequency change. When the
+// pink noise volume was given in dB or the beat in cents, only that form is kept. The tone
+// switches are kept whenever they are set, even to false.
+func formatChangeNode(content []*yaml.Node, fc ConfigFrequencyChange) []*yaml.Node {
+	var kept []*yaml.Node
+	for j := 0; j+1 < len(content); j += 2 {
+		key, value := content[j], content[j+1]
+		if key.Value == "pink_noise_volume" && fc.PinkNoiseVolumeDB != nil {
+			continue
+		}
+		if key.Value == "beat_frequency" && fc.BeatCents != nil {
+			continue
+		}
+		if key.Value == "left_on" || key.Value == "right_on" {
+			// Only set switches are encoded, and false must be kept
+			kept = append(kept, key, value)
+			continue
+		}
+		if !requiredChangeFields[key.Value] && isZeroNode(value) {
+			continue
+		}
+		kept = append(kept, key, value)
+	}
+	return kept
+}
+
+// isZeroNode reports whether an encoded value is a zero number, false, an empty string or an
+// empty list.
+func isZeroNode(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(n.Content) == 0
+	case yaml.ScalarNode:
+		return n.Value == "" || n.Value == "0" || n.Value == "false" || n.Tag == "!!null"
+	}
+	return false
+}
+
+// roundTo rounds v to the given number of decimal places.
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
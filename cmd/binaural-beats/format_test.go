@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestFormatConfig(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name: "sorted by time with fields in order",
+			input: `
+frequency_changes:
+  - {tone_volume: 0.5, time: 60, beat_frequency: 6, frequency: 180, pink_noise_volume: 0.2}
+  - {frequency: 200, time: 0, tone_volume: 0.5, beat_frequency: 10, pink_noise_volume: 0.3}
+`,
+			want: `frequency_changes:
+  - time: 0
+    frequency: 200
+    beat_frequency: 10
+    pink_noise_volume: 0.3
+    tone_volume: 0.5
+  - time: 60
+    frequency: 180
+    beat_frequency: 6
+    pink_noise_volume: 0.2
+    tone_volume: 0.5
+`,
+		},
+		{
+			name: "volumes rounded and dB kept",
+			input: `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.333333333, pink_noise_volume_db: -12.3456}
+  - {time: 30, frequency: 200, beat_frequency: 10, tone_volume: 0.25, pink_noise_volume: 0.123456}
+`,
+			want: `frequency_changes:
+  - time: 0
+    frequency: 200
+    beat_frequency: 10
+    pink_noise_volume_db: -12.35
+    tone_volume: 0.3333
+  - time: 30
+    frequency: 200
+    beat_frequency: 10
+    pink_noise_volume: 0.1235
+    tone_volume: 0.25
+`,
+		},
+		{
+			name: "tone sets written out",
+			input: `
+tone_sets:
+  calm: {frequency: 150, beat_frequency: 4, tone_volume: 0.4}
+mode: monaural
+frequency_changes:
+  - {time: 0, use: calm}
+  - {time: 10, use: calm, right_on: false}
+`,
+			want: `mode: monaural
+frequency_changes:
+  - time: 0
+    frequency: 150
+    beat_frequency: 4
+    pink_noise_volume: 0
+    tone_volume: 0.4
+  - time: 10
+    frequency: 150
+    beat_frequency: 4
+    pink_noise_volume: 0
+    tone_volume: 0.4
+    right_on: false
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			once, err := formatConfig([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(once) != tt.want {
+				t.Errorf("formatted:\n%s\nwant:\n%s", once, tt.want)
+			}
+			twice, err := formatConfig(once)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(twice) != string(once) {
+				t.Errorf("formatting again changed the output:\n%s\nto:\n%s", once, twice)
+			}
+		})
+	}
+
+	if _, err := formatConfig([]byte("frequency_changes: [")); err == nil {
+		t.Error("formatConfig accepted invalid YAML")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/gopxl/beep"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the rendered audio")
+
+// pcm16 converts a sample to a signed 16-bit value, clamping to [-1, 1] and rounding to the
+// nearest step so the conversion is stable across encoders.
+func pcm16(x float64) int16 {
+	x = math.Max(-1, math.Min(1, x))
+	return int16(math.Round(x * math.MaxInt16))
+}
+
+// renderPCM16 streams s to w as interleaved little-endian 16-bit stereo PCM.
+func renderPCM16(w io.Writer, s beep.Streamer) error {
+	samples := make([][2]float64, 512)
+	buf := make([]byte, len(samples)*4)
+	for {
+		n, ok := s.Stream(samples)
+		for i, sample := range samples[:n] {
+			binary.LittleEndian.PutUint16(buf[i*4:], uint16(pcm16(sample[0])))
+			binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(pcm16(sample[1])))
+		}
+		if _, err := w.Write(buf[:n*4]); err != nil {
+			return err
+		}
+		if !ok {
+			return s.Err()
+		}
+	}
+}
+
+// TestGolden renders each golden config with seed 1 and compares it sample by sample against
+// its committed PCM. Once a change to the output has been reviewed, regenerate the goldens
+// with go test -run Golden -update.
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		config, golden string
+	}{
+		{"testdata/golden.yaml", "testdata/golden.pcm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			sr := beep.SampleRate(44100)
+			cfg, err := parseConfig(tt.config, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := newSession(cfg, sr, defaultSessionOptions(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			var rendered bytes.Buffer
+			if err := renderPCM16(&rendered, session.streamer); err != nil {
+				t.Fatal(err)
+			}
+			got := rendered.Bytes()
+			if *update {
+				if err := os.WriteFile(tt.golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			golden, err := os.ReadFile(tt.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(golden) {
+				t.Fatalf("rendered %d frames, golden file has %d", len(got)/4, len(golden)/4)
+			}
+			mismatches, first := 0, -1
+			for i := 0; i < len(got); i += 2 {
+				if got[i] != golden[i] || got[i+1] != golden[i+1] {
+					if first == -1 {
+						first = i / 4
+					}
+					mismatches++
+				}
+			}
+			if mismatches > 0 {
+				t.Errorf("%d samples differ, first at frame %d (%.4f s)", mismatches, first, sr.D(first).Seconds())
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Formats of the diagnostic output, selected with -log-format.
+const (
+	logFormatText = "text" // Progress on stdout and warnings on stderr, as plain text
+	logFormatJSON = "json" // One JSON object with a level and message per line on stderr
+)
+
+// Levels of the diagnostic output, selected with -quiet and -verbose.
+const (
+	logLevelQuiet   = iota // Only errors
+	logLevelNormal         // Progress and warnings
+	logLevelVerbose        // Progress and warnings, with detail such as export progress
+)
+
+// logLevel is the most detailed level of diagnostic output that is printed. Errors are always
+// printed.
+var logLevel = logLevelNormal
+
+// jsonLogger receives all diagnostic output when the log format is JSON, and is nil otherwise.
+var jsonLogger *slog.Logger
+
+// setLogFormat routes the diagnostic output in format, writing JSON lines to w.
+func setLogFormat(format string, w io.Writer) {
+	jsonLogger = nil
+	if format == logFormatJSON {
+		// Levels are filtered by logLevel, so the handler passes debug lines through
+		jsonLogger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+}
+
+// infof reports progress. In text format it is printed to stdout as is.
+func infof(format string, args ...any) {
+	if logLevel < logLevelNormal {
+		return
+	}
+	if jsonLogger == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	jsonLogger.Info(logMessage(format, args))
+}
+
+// warnf reports a problem that doesn't stop the program. In text format it goes to the
+// standard logger.
+func warnf(format string, args ...any) {
+	if logLevel < logLevelNormal {
+		return
+	}
+	if jsonLogger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	jsonLogger.Warn(logMessage(format, args))
+}
+
+// debugf reports detail that is only printed with -verbose. In text format it is printed to
+// stdout like progress.
+func debugf(format string, args ...any) {
+	if logLevel < logLevelVerbose {
+		return
+	}
+	if jsonLogger == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	jsonLogger.Debug(logMessage(format, args))
+}
+
+// exitHooks are run by fatalf, newest first, before it exits. os.Exit skips deferred calls, so
+// main registers the cleanup that must not be lost, such as writing the profiles.
+var exitHooks []func()
+
+// atExit registers f to run when fatalf exits and returns a function that runs it instead, for
+// main to defer. f runs at most once.
+func atExit(f func()) (run func()) {
+	var once sync.Once
+	run = func() { once.Do(f) }
+	exitHooks = append(exitHooks, run)
+	return run
+}
+
+// fatalf reports an error, runs the exit hooks and exits with status 1.
+func fatalf(format string, args ...any) {
+	if jsonLogger == nil {
+		log.Printf(format, args...)
+	} else {
+		jsonLogger.Error(logMessage(format, args))
+	}
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(1)
+}
+
+// logMessage formats a message for a JSON line, without the trailing newline text output uses.
+func logMessage(format string, args []any) string {
+	return strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogFormat(t *testing.T) {
+	savedLevel, savedLogger := logLevel, jsonLogger
+	t.Cleanup(func() { logLevel, jsonLogger = savedLevel, savedLogger })
+
+	var out bytes.Buffer
+	setLogFormat(logFormatJSON, &out)
+	logLevel = logLevelVerbose
+	infof("Wrote %s (%d Hz)\n", "out.wav", 44100)
+	warnf("Warning: %d clipped samples", 3)
+	debugf("Rendered %d%%\n", 50)
+
+	want := []struct{ level, msg string }{
+		{"INFO", "Wrote out.wav (44100 Hz)"},
+		{"WARN", "Warning: 3 clipped samples"},
+		{"DEBUG", "Rendered 50%"},
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %d is not JSON: %v\n%s", i+1, err, line)
+		}
+		if entry["level"] != want[i].level || entry["msg"] != want[i].msg {
+			t.Errorf("line %d has level %v and message %q, want %s %q", i+1, entry["level"], entry["msg"], want[i].level, want[i].msg)
+		}
+		if _, ok := entry["time"]; !ok {
+			t.Errorf("line %d has no time: %s", i+1, line)
+		}
+	}
+
+	setLogFormat(logFormatText, &out)
+	if jsonLogger != nil {
+		t.Error("text format left the JSON logger set")
+	}
+}
+
+// fatalfChildEnv is set when the test binary is run to call fatalf in a child process.
+const fatalfChildEnv = "BINAURAL_BEATS_FATALF_CHILD"
+
+func TestFatalfRunsExitHooks(t *testing.T) {
+	if dir := os.Getenv(fatalfChildEnv); dir != "" {
+		// A fatal error once profiling has started, from the missing noise file
+		config := filepath.Join(dir, "session.yaml")
+		os.WriteFile(config, []byte(`
+noise_file: missing.wav
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`), 0644)
+		runMain(t, "-config", config, "-output", filepath.Join(dir, "out.wav"),
+			"-cpuprofile", filepath.Join(dir, "cpu.prof"), "-memprofile", filepath.Join(dir, "mem.prof"))
+		t.Fatal("main returned despite the fatal error")
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalfRunsExitHooks$")
+	cmd.Env = append(os.Environ(), fatalfChildEnv+"="+dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("child exited with %v, want status 1\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "error loading noise file") {
+		t.Errorf("child didn't report the fatal error:\n%s", stderr.String())
+	}
+	for _, name := range []string{"cpu.prof", "mem.prof"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() == 0 {
+			t.Errorf("%s wasn't written before exiting: %v", name, err)
+		}
+	}
+}
+
+func TestQuietAndVerbose(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// -stretch alongside -target-duration prints a warning
+	args := []string{"-config", config, "-seed", "1", "-output", filepath.Join(dir, "out.wav"), "-target-duration", "2", "-stretch", "3"}
+
+	tests := []struct {
+		name, flag        string
+		progress, details bool // Whether progress and warnings, and -verbose detail, are printed
+	}{
+		{"normal", "", true, false},
+		{"quiet", "-quiet", false, false},
+		{"verbose", "-verbose", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+			flags := args
+			if tt.flag != "" {
+				flags = append([]string{tt.flag}, args...)
+			}
+			out := captureStdout(t, func() { runMain(t, flags...) })
+
+			if got := strings.Contains(out, "Wrote "); got != tt.progress {
+				t.Errorf("progress printed = %v, want %v:\n%s", got, tt.progress, out)
+			}
+			if got := strings.Contains(logged.String(), "-target-duration overrides -stretch"); got != tt.progress {
+				t.Errorf("warning printed = %v, want %v:\n%s", got, tt.progress, logged.String())
+			}
+			if got := strings.Contains(out, "Resolved configuration:"); got != tt.details {
+				t.Errorf("resolved configuration printed = %v, want %v", got, tt.details)
+			}
+			if tt.flag == "-quiet" && (out != "" || logged.Len() > 0) {
+				t.Errorf("-quiet printed %q and logged %q, want nothing", out, logged.String())
+			}
+		})
+	}
+}
+
+// quietChildEnv is set when the test binary is run to fail under -quiet in a child process.
+const quietChildEnv = "BINAURAL_BEATS_QUIET_CHILD"
+
+func TestQuietKeepsErrors(t *testing.T) {
+	if dir := os.Getenv(quietChildEnv); dir != "" {
+		runMain(t, "-quiet", "-config", filepath.Join(dir, "missing.yaml"), "-output", filepath.Join(dir, "out.wav"))
+		t.Fatal("main returned despite the missing config")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestQuietKeepsErrors$")
+	cmd.Env = append(os.Environ(), quietChildEnv+"="+t.TempDir())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("child exited with %v, want status 1\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "missing.yaml") {
+		t.Errorf("-quiet hid the error:\n%s", stderr.String())
+	}
+}
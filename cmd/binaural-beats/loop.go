@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// loopCrossfade is the length of the crossfade from the end of a -loopable export back into
+// its start.
+const loopCrossfade = 50 * time.Millisecond
+
+// newLoopStream returns the session as a seamless loop of the same length. It plays the session
+// from loopCrossfade in, and crossfades its end into the session's first loopCrossfade. When
+// the file wraps around, the last sample is followed by the one the session would have played
+// next, so the tones keep their phase and the noise continues without a click.
+//
+// head streams the session from its start. The end is rendered by a second session, held at
+// the last change's values for the crossfade, so it runs past the end of head.
+func newLoopStream(cfg *Config, sr beep.SampleRate, opts SessionOptions, head *Session) (beep.Streamer, error) {
+	crossfade := sr.N(loopCrossfade)
+	if head.totalSamples < 2*crossfade {
+		return nil, fmt.Errorf("the session must be at least %v long to loop", 2*loopCrossfade)
+	}
+
+	extended := *cfg
+	extended.FrequencyChanges = holdLastChange(append([]ConfigFrequencyChange(nil), cfg.FrequencyChanges...), loopCrossfade.Seconds())
+	tail, err := newSession(&extended, sr, opts)
+	if err != nil {
+		return nil, err
+	}
+	head.closers = append(head.closers, tail)
+
+	// Keep the start of the session for the crossfade, and skip it in the body
+	start := make([][2]float64, crossfade)
+	fillSamples(head.streamer, start)
+	fillSamples(tail.streamer, make([][2]float64, crossfade))
+
+	return &loopStreamer{
+		body:      tail.streamer,
+		start:     start,
+		remaining: head.totalSamples,
+	}, nil
+}
+
+// loopStreamer plays the body of a loop and crossfades its last frames into the saved start.
+type loopStreamer struct {
+	body      beep.Streamer
+	start     [][2]float64 // First frames of the session, faded in over the end of the loop
+	remaining int          // Frames left to play
+}
+
+// Stream plays the body, crossfading it into the start over the last len(start) frames with
+// an equal-power curve that reaches the start exactly on the final frame.
+func (ls *loopStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	if ls.remaining <= 0 {
+		return 0, false
+	}
+	if len(samples) > ls.remaining {
+		samples = samples[:ls.remaining]
+	}
+	sn, _ := fillSamples(ls.body, samples)
+	for i := sn; i < len(samples); i++ {
+		samples[i] = [2]float64{}
+	}
+
+	crossfade := len(ls.start)
+	for i := range samples {
+		left := ls.remaining - i // Frames left including this one
+		if left > crossfade {
+			continue
+		}
+		j := crossfade - left
+		angle := float64(j+1) / float64(crossfade) * math.Pi / 2
+		fadeOut, fadeIn := math.Cos(angle), math.Sin(angle)
+		for c := range samples[i] {
+			samples[i][c] = samples[i][c]*fadeOut + ls.start[j][c]*fadeIn
+		}
+	}
+	ls.remaining -= len(samples)
+	return len(samples), true
+}
+
+// Err returns the error state of the body.
+func (ls *loopStreamer) Err() error {
+	return ls.body.Err()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoopWrapsContinuously(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 310, beat_frequency: 6, tone_volume: 0.4, pink_noise_volume: 0.2}
+  - {time: 2, frequency: 310, beat_frequency: 6, tone_volume: 0.4, pink_noise_volume: 0.2}
+`)
+	session := renderFrames(t, cfg, testSampleRate, testOptions())
+	head, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer head.Close()
+	stream, err := newLoopStream(cfg, testSampleRate, testOptions(), head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := drainFrames(stream)
+
+	if len(loop) != len(session) {
+		t.Fatalf("loop is %d frames, want the session's %d", len(loop), len(session))
+	}
+	// The loop plays the session from the crossfade's length in, and its last frame is the one
+	// just before that, so the wrap steps between neighbouring frames of the session
+	crossfade := testSampleRate.N(loopCrossfade)
+	for i := 0; i < len(loop)-crossfade; i++ {
+		if loop[i] != session[i+crossfade] {
+			t.Fatalf("loop frame %d = %v, want session frame %d's %v", i, loop[i], i+crossfade, session[i+crossfade])
+		}
+	}
+	if last := loop[len(loop)-1]; math.Abs(last[0]-session[crossfade-1][0]) > 1e-12 || math.Abs(last[1]-session[crossfade-1][1]) > 1e-12 {
+		t.Errorf("last loop frame = %v, want session frame %d's %v", last, crossfade-1, session[crossfade-1])
+	}
+
+	// No step in the loop, the wrap included, is larger than the steepest step of the session
+	var steepest float64
+	for i := 1; i < len(session); i++ {
+		for c := 0; c < 2; c++ {
+			steepest = math.Max(steepest, math.Abs(session[i][c]-session[i-1][c]))
+		}
+	}
+	for i := range loop {
+		prev := loop[(i+len(loop)-1)%len(loop)]
+		for c := 0; c < 2; c++ {
+			if step := math.Abs(loop[i][c] - prev[c]); step > steepest+1e-9 {
+				t.Errorf("loop steps by %.4f into frame %d, more than the session's steepest %.4f", step, i, steepest)
+			}
+		}
+	}
+}
+
+func TestLoopNeedsTwoCrossfades(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 0.09, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+`)
+	head, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer head.Close()
+	if _, err := newLoopStream(cfg, testSampleRate, testOptions(), head); err == nil {
+		t.Error("a 90 ms session was made loopable, want an error as it is shorter than two crossfades")
+	}
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"math"
 	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gopxl/beep"
-	"github.com/gopxl/beep/speaker"
+	"github.com/gopxl/beep/mp3"
+	"github.com/gopxl/beep/vorbis"
 	"github.com/gopxl/beep/wav"
 	"gopkg.in/yaml.v3"
 )
@@ -19,39 +27,150 @@ import (
 // Config represents the structure of the YAML configuration file.
 type Config struct {
 	FrequencyChanges []ConfigFrequencyChange `yaml:"frequency_changes"`
+	PhaseOffset      float64                 `yaml:"phase_offset"`     // Right channel phase offset in degrees
+	NoiseFadeCurve   string                  `yaml:"noise_fade_curve"` // Pink noise volume ramp: "linear" (default), "equal_power" or "step"
+	Harmonics        []float64               `yaml:"harmonics"`        // Relative amplitudes of the fundamental and its overtones
+	NoiseFile        string                  `yaml:"noise_file"`       // WAV, MP3 or Ogg Vorbis file looped as the noise instead of pink noise
+	Mode             string                  `yaml:"mode"`             // Synthesis mode: "binaural" (default), "monaural", "isochronic" or "dual"
+	StereoNoise      bool                    `yaml:"stereo_noise"`     // Independent pink noise in each channel instead of the same noise in both
+	Title            string                  `yaml:"title"`            // Session title written to exported file metadata
+	Artist           string                  `yaml:"artist"`           // Artist written to exported file metadata
+	Comment          string                  `yaml:"comment"`          // Comment written to exported file metadata
+	MasterEnvelope   []EnvelopePoint         `yaml:"master_envelope"`  // Gain over time applied to the whole mix
+	BeatSchedule     *BeatSchedule           `yaml:"beat_schedule"`    // Beats to hold and ramp between, expanded into frequency_changes
+	NoiseLayers      []NoiseLayer            `yaml:"noise_layers"`     // Bands of filtered noise added to the noise bed
+	Channels         []OutputChannel         `yaml:"channels"`         // Channels of a multichannel export, instead of stereo
+
+	// Named sets of frequency change fields, referenced from a change with use
+	ToneSets map[string]ConfigFrequencyChange `yaml:"tone_sets"`
 }
 
+// EnvelopePoint is a point of the master envelope.
+type EnvelopePoint struct {
+	Time float64 `yaml:"time"` // Time in seconds
+	Gain float64 `yaml:"gain"` // Linear gain applied to the whole mix (1.0 leaves it unchanged)
+}
+
+// BeatSchedule describes a session as a sequence of beat frequencies at a constant carrier.
+type BeatSchedule struct {
+	Frequency       float64             `yaml:"frequency"`         // Carrier frequency held for the whole schedule
+	ToneVolume      float64             `yaml:"tone_volume"`       // Tone volume held for the whole schedule
+	PinkNoiseVolume float64             `yaml:"pink_noise_volume"` // Pink noise volume held for the whole schedule
+	Entries         []BeatScheduleEntry `yaml:"entries"`
+}
+
+// BeatScheduleEntry is a beat frequency held for a time and then ramped to the next entry's.
+type BeatScheduleEntry struct {
+	Beat float64 `yaml:"beat"` // Beat frequency in Hz
+	Hold float64 `yaml:"hold"` // Seconds the beat is held
+	Ramp float64 `yaml:"ramp"` // Seconds of the ramp to the next entry's beat (ignored on the last entry)
+}
+
+// exportMetadata holds the tags written to exported files.
+type exportMetadata struct {
+	Title   string
+	Artist  string
+	Comment string
+}
+
+// metadata returns the export tags set in the configuration.
+func (cfg *Config) metadata() exportMetadata {
+	return exportMetadata{Title: cfg.Title, Artist: cfg.Artist, Comment: cfg.Comment}
+}
+
+// Synthesis modes.
+const (
+	modeBinaural   = "binaural"
+	modeMonaural   = "monaural"
+	modeIsochronic = "isochronic"
+	modeDual       = "dual" // Binaural tones pulsed together at a second beat frequency
+)
+
+// Pink noise volume ramp curves.
+const (
+	noiseFadeLinear     = "linear"
+	noiseFadeEqualPower = "equal_power"
+	noiseFadeStep       = "step"
+)
+
 // ConfigFrequencyChange represents a frequency change event.
 type ConfigFrequencyChange struct {
-	Time            float64 `yaml:"time"`              // Time in seconds
-	Frequency       float64 `yaml:"frequency"`         // Base frequency in Hz
-	BeatFrequency   float64 `yaml:"beat_frequency"`    // Beat frequency in Hz
-	PinkNoiseVolume float64 `yaml:"pink_noise_volume"` // Volume for pink noise (0.0 to 1.0)
-	ToneVolume      float64 `yaml:"tone_volume"`       // Volume for the sine wave (0.0 to 1.0)
+	Time              float64   `yaml:"time"`                           // Time in seconds
+	Frequency         float64   `yaml:"frequency"`                      // Base frequency in Hz
+	BeatFrequency     float64   `yaml:"beat_frequency"`                 // Beat frequency in Hz
+	BeatFrequency2    float64   `yaml:"beat_frequency2"`                // Isochronic pulse rate in Hz in dual mode
+	BeatCents         *float64  `yaml:"beat_cents,omitempty"`           // Beat as an interval in cents above the carrier, instead of beat_frequency
+	PinkNoiseVolume   float64   `yaml:"pink_noise_volume"`              // Volume for pink noise (0.0 to 1.0)
+	PinkNoiseVolumeDB *float64  `yaml:"pink_noise_volume_db,omitempty"` // Volume for pink noise in dBFS, instead of pink_noise_volume
+	ToneVolume        float64   `yaml:"tone_volume"`                    // Volume for the sine wave (0.0 to 1.0)
+	NoiseTilt         float64   `yaml:"noise_tilt"`                     // Pink noise tilt (-1.0 brown-ish to 1.0 white-ish)
+	Label             string    `yaml:"label"`                          // Optional description of the segment starting here
+	AutopanRate       float64   `yaml:"autopan_rate"`                   // Tone auto-pan oscillation rate in Hz
+	AutopanDepth      float64   `yaml:"autopan_depth"`                  // Tone auto-pan depth (0.0 centered to 1.0 fully left/right)
+	BeatShape         string    `yaml:"beat_shape"`                     // Isochronic or dual mode pulse shape: "square" (default), "sine" or "trapezoid"
+	BeatRamp          float64   `yaml:"beat_ramp"`                      // Fraction of a trapezoid pulse spent on attack and release
+	MixFile           string    `yaml:"mix_file"`                       // Soundtrack kept from converted Sbagen files; not rendered yet
+	Easing            string    `yaml:"easing"`                         // Curve towards the next change: "linear" (default), "ease_in", "ease_out" or "ease_in_out"
+	Use               string    `yaml:"use"`                            // Name of a tone set supplying the fields not given here
+	FrequencyWander   float64   `yaml:"frequency_wander"`               // Maximum slow random carrier deviation in Hz, applied to both channels
+	NoiseLayerVolumes []float64 `yaml:"noise_layer_volumes"`            // Volume of each noise layer (0.0 to 1.0), overriding the layer's own
+	LeftOn            *bool     `yaml:"left_on,omitempty"`              // Whether the left tone plays from this change on (default true)
+	RightOn           *bool     `yaml:"right_on,omitempty"`             // Whether the right tone plays from this change on (default true)
+	PinkNoisePan      float64   `yaml:"pink_noise_pan"`                 // Pink noise pan position (-1.0 left to 1.0 right, default 0.0 centered)
+	DetuneCents       float64   `yaml:"detune_cents"`                   // Offset in cents of a second oscillator thickening each tone (0 disables)
 }
 
+// pinkNoiseAmplitude is the default scale applied to the sum of the five white noise rows.
+// The sum peaks at ±5, so the generator peaks at ±0.5 and, after the default 0.5 headroom
+// applied by PinkNoiseControl, a pink_noise_volume of 1.0 peaks at a quarter of full scale.
+const pinkNoiseAmplitude = 0.1
+
+// defaultNoiseWarmup is the default number of samples the pink noise generator is advanced
+// before the session starts. The slowest of the five rows is first set after 16 samples, so
+// this is well past the point where the generator no longer starts from zeroed rows.
+const defaultNoiseWarmup = 64
+
+// pinkNoiseFullScaleAmplitude is the amplitude at which a pink_noise_volume of 1.0 can reach
+// full scale with the default headroom.
+const pinkNoiseFullScaleAmplitude = 0.4
+
 // PinkNoise implements a pink noise generator using the Voss-McCartney algorithm.
 type PinkNoise struct {
-	rand   *rand.Rand
-	maxKey uint32
-	key    uint32
-	white  [5]float64
+	rand      *rand.Rand
+	maxKey    uint32
+	key       uint32
+	white     [5]float64
+	amplitude float64 // Scale applied to the summed rows
 }
 
-// NewPinkNoise creates a new PinkNoise generator.
-func NewPinkNoise() *PinkNoise {
+// NewPinkNoise creates a new PinkNoise generator seeded with seed.
+func NewPinkNoise(seed int64) *PinkNoise {
 	return &PinkNoise{
-		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
-		maxKey: 0x1F, // Five bits set
+		rand:      rand.New(rand.NewSource(seed)),
+		maxKey:    0x1F, // Five bits set
+		amplitude: pinkNoiseAmplitude,
+	}
+}
+
+// Warmup advances the generator by samples, discarding them, so every row holds a random value
+// and the spectrum is settled from the first sample played.
+func (pn *PinkNoise) Warmup(samples int) {
+	for i := 0; i < samples; i++ {
+		pn.nextSample()
 	}
 }
 
+// SetAmplitude changes the scale applied to the generated noise.
+func (pn *PinkNoise) SetAmplitude(amplitude float64) {
+	pn.amplitude = amplitude
+}
+
 // Stream generates pink noise samples.
 func (pn *PinkNoise) Stream(samples [][2]float64) (n int, ok bool) {
 	for i := range samples {
 		sample := pn.nextSample()
-		samples[i][0] += sample // Left channel
-		samples[i][1] += sample // Right channel
+		samples[i][0] = sample // Left channel
+		samples[i][1] = sample // Right channel
 	}
 	return len(samples), true
 }
@@ -61,6 +180,17 @@ func (pn *PinkNoise) Err() error {
 	return nil
 }
 
+// Reseed restarts the random source with seed. The generator state is kept so the noise
+// continues without a discontinuity.
+func (pn *PinkNoise) Reseed(seed int64) {
+	pn.rand.Seed(seed)
+}
+
+// segmentSeed derives a reproducible seed for a segment from the base seed.
+func segmentSeed(seed int64, index int) int64 {
+	return int64(uint64(seed) ^ uint64(index)*0x9E3779B97F4A7C15)
+}
+
 // nextSample generates the next pink noise sample.
 func (pn *PinkNoise) nextSample() float64 {
 	lastKey := pn.key
@@ -75,68 +205,441 @@ func (pn *PinkNoise) nextSample() float64 {
 		}
 	}
 	sum := pn.white[0] + pn.white[1] + pn.white[2] + pn.white[3] + pn.white[4]
-	return sum * 0.1 // Reduced amplitude to prevent clipping
+	return sum * pn.amplitude
+}
+
+// StereoPinkNoise generates independent pink noise in each channel for a wider noise image.
+type StereoPinkNoise struct {
+	left  *PinkNoise
+	right *PinkNoise
+}
+
+// NewStereoPinkNoise creates a StereoPinkNoise generator. The left channel is seeded with seed,
+// so it matches the mono PinkNoise, and the right channel with a seed derived from it.
+func NewStereoPinkNoise(seed int64) *StereoPinkNoise {
+	return &StereoPinkNoise{
+		left:  NewPinkNoise(seed),
+		right: NewPinkNoise(rightChannelSeed(seed)),
+	}
+}
+
+// Stream generates a separate pink noise sample for each channel.
+func (sp *StereoPinkNoise) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		samples[i][0] = sp.left.nextSample()
+		samples[i][1] = sp.right.nextSample()
+	}
+	return len(samples), true
+}
+
+// Err returns nil, as StereoPinkNoise doesn't produce any errors.
+func (sp *StereoPinkNoise) Err() error {
+	return nil
+}
+
+// Reseed restarts both random sources, deriving the right channel's seed from seed.
+func (sp *StereoPinkNoise) Reseed(seed int64) {
+	sp.left.Reseed(seed)
+	sp.right.Reseed(rightChannelSeed(seed))
+}
+
+// Warmup advances both channels' generators by samples.
+func (sp *StereoPinkNoise) Warmup(samples int) {
+	sp.left.Warmup(samples)
+	sp.right.Warmup(samples)
+}
+
+// SetAmplitude changes the scale applied to the noise in both channels.
+func (sp *StereoPinkNoise) SetAmplitude(amplitude float64) {
+	sp.left.SetAmplitude(amplitude)
+	sp.right.SetAmplitude(amplitude)
+}
+
+// rightChannelSeed derives the right channel's noise seed from the left channel's.
+func rightChannelSeed(seed int64) int64 {
+	return int64(uint64(seed) ^ 0xD1B54A32D192ED03)
 }
 
 // VariableTone generates a sine wave with a frequency that changes over time.
 type VariableTone struct {
-	sr         beep.SampleRate
-	pos        int
-	phase      float64
-	freqFunc   func(t float64) float64
-	volumeFunc func(t float64) float64
-	channel    int // 0 for left, 1 for right
+	sr          beep.SampleRate
+	pos         int
+	phase       float64
+	phaseOffset float64   // Constant phase offset in radians
+	harmonics   []float64 // Normalized amplitudes of the fundamental and its overtones (empty for a pure sine)
+	freqFunc    func(t float64) float64
+	volumeFunc  func(t float64) float64
+	channel     int     // 0 for left, 1 for right, 2 for both
+	lastVolume  float64 // Last finite volume, used in place of a non-finite one
+
+	headroom     float64 // Linear scale applied to the output to leave headroom against clipping
+	smoothing    float64 // Per-sample coefficient of the control smoothing (0 disables)
+	smoothFreq   controlSmoother
+	smoothVolume controlSmoother
+
+	attack     int  // Samples of the ramp applied when the volume rises from zero (0 disables)
+	attackLeft int  // Samples remaining in the current attack ramp
+	silent     bool // Whether the previous sample's configured volume was zero
+}
+
+// nonFiniteWarning makes sure the warning about non-finite values is only logged once.
+var nonFiniteWarning sync.Once
+
+// finiteOr returns v, or fallback with a one-time warning if v is NaN or infinite.
+func finiteOr(v, fallback float64) float64 {
+	if !math.IsNaN(v) && !math.IsInf(v, 0) {
+		return v
+	}
+	nonFiniteWarning.Do(func() {
+		warnf("Warning: the config produced a non-finite frequency or volume; it has been replaced to keep the audio valid.")
+	})
+	return fallback
+}
+
+// controlSmoother is a one-pole low-pass filter for a control value that is updated once per
+// sample. It removes the steps in frequency and volume that would otherwise be audible as
+// zipper noise at waypoints.
+type controlSmoother struct {
+	value  float64
+	primed bool // Whether value holds a previous output
+}
+
+// next moves the smoothed value towards target by coef and returns it. The first call returns
+// target unchanged, so a session doesn't start with a ramp from zero. A coef of 0 disables
+// smoothing.
+func (cs *controlSmoother) next(target, coef float64) float64 {
+	if coef == 0 || !cs.primed {
+		cs.value, cs.primed = target, true
+		return target
+	}
+	cs.value += coef * (target - cs.value)
+	return cs.value
+}
+
+// defaultHeadroomDB is the headroom the tones and noise have always been mixed with, a scale of
+// exactly 0.5, so a tone and the noise at full volume together don't clip.
+var defaultHeadroomDB = 20 * math.Log10(2)
+
+// defaultAttackMS is the default length in milliseconds of the ramp applied when a tone's
+// volume rises from zero, which keeps the tone from starting with a thump.
+const defaultAttackMS = 10.0
+
+// defaultSmoothMS is the default time constant of the control smoothing in milliseconds. It is
+// short enough not to blur deliberate changes but removes the steps between samples.
+const defaultSmoothMS = 5.0
+
+// smoothingCoefficient returns the per-sample coefficient of a one-pole filter with a time
+// constant of timeConstant seconds, or 0 to disable smoothing.
+func smoothingCoefficient(sr beep.SampleRate, timeConstant float64) float64 {
+	if timeConstant <= 0 {
+		return 0
+	}
+	return 1 - math.Exp(-1/(timeConstant*float64(sr)))
 }
 
 // Stream generates the sine wave samples.
 func (vt *VariableTone) Stream(samples [][2]float64) (n int, ok bool) {
 	for i := range samples {
-		t := float64(vt.pos) / float64(vt.sr) // Time in seconds
-		f := vt.freqFunc(t)                   // Frequency at time t
-		vol := vt.volumeFunc(t)               // Volume at time t
+		t := float64(vt.pos) / float64(vt.sr)            // Time in seconds
+		f := finiteOr(vt.freqFunc(t), 0)                 // Frequency at time t
+		vol := finiteOr(vt.volumeFunc(t), vt.lastVolume) // Volume at time t
+		vt.lastVolume = vol
+
+		// Start the attack ramp when the configured volume rises from zero. The smoothed volume
+		// only approaches zero, so the edge is found before smoothing
+		if vol > 0 && vt.silent {
+			vt.attackLeft = vt.attack
+		}
+		vt.silent = vol <= 0
+
+		f = vt.smoothFreq.next(f, vt.smoothing)
+		vol = vt.smoothVolume.next(vol, vt.smoothing)
+		if vt.attackLeft > 0 {
+			vol *= 1 - float64(vt.attackLeft)/float64(vt.attack)
+			vt.attackLeft--
+		}
 		deltaPhase := 2 * math.Pi * f / float64(vt.sr)
-		vt.phase += deltaPhase
-		s := math.Sin(vt.phase) * vol * 0.5 // Scaled down to prevent clipping
-		if vt.channel == 0 {
-			samples[i][0] += s
-		} else {
-			samples[i][1] += s
+		vt.phase = finiteOr(vt.phase+deltaPhase, 0) // A finite but huge frequency can overflow the phase
+		s := vt.waveform(vt.phase+vt.phaseOffset, f) * vol * vt.headroom
+		samples[i] = [2]float64{}
+		switch vt.channel {
+		case 0:
+			samples[i][0] = s
+		case 1:
+			samples[i][1] = s
+		default:
+			samples[i][0] = s
+			samples[i][1] = s
 		}
 		vt.pos++
 	}
 	return len(samples), true
 }
 
+// waveform returns the tone's value at phase for a fundamental of f Hz. Harmonics at or above
+// the Nyquist frequency are left out to avoid aliasing.
+func (vt *VariableTone) waveform(phase, f float64) float64 {
+	if len(vt.harmonics) == 0 {
+		return math.Sin(phase)
+	}
+
+	var sum float64
+	for k, amp := range vt.harmonics {
+		multiple := float64(k + 1)
+		if multiple*f >= float64(vt.sr)/2 {
+			break
+		}
+		sum += amp * math.Sin(multiple*phase)
+	}
+	return sum
+}
+
+// normalizeHarmonics scales the harmonic amplitudes so they sum to 1.0, keeping the peak
+// level within tone_volume. It returns nil if no harmonic has a non-zero amplitude.
+func normalizeHarmonics(harmonics []float64) []float64 {
+	var total float64
+	for _, amp := range harmonics {
+		total += math.Abs(amp)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	normalized := make([]float64, len(harmonics))
+	for i, amp := range harmonics {
+		normalized[i] = amp / total
+	}
+	return normalized
+}
+
 // Err returns nil, as VariableTone doesn't produce any errors.
 func (vt *VariableTone) Err() error {
 	return nil
 }
 
+// detuneLevel is the level of a tone's detuned copy relative to the tone.
+const detuneLevel = 0.5
+
+// maxDetuneCents is the largest detune_cents accepted, a semitone either way.
+const maxDetuneCents = 100.0
+
+// withDetune mixes vt with a copy of it detuned by detuneFunc cents, at detuneLevel of its
+// level. While the detune is set, both are scaled by 1/(1+detuneLevel) so they peak no higher
+// than the tone alone; while it is 0, the copy is silent and the tone plays as configured.
+func withDetune(vt *VariableTone, detuneFunc func(t float64) float64) beep.Streamer {
+	freqFunc, volumeFunc := vt.freqFunc, vt.volumeFunc
+	copied := *vt
+	copied.freqFunc = func(t float64) float64 {
+		return freqFunc(t) * math.Pow(2, detuneFunc(t)/1200)
+	}
+	copied.volumeFunc = func(t float64) float64 {
+		if detuneFunc(t) == 0 {
+			return 0
+		}
+		return volumeFunc(t) * detuneLevel / (1 + detuneLevel)
+	}
+	vt.volumeFunc = func(t float64) float64 {
+		if detuneFunc(t) == 0 {
+			return volumeFunc(t)
+		}
+		return volumeFunc(t) / (1 + detuneLevel)
+	}
+	return beep.Mix(vt, &copied)
+}
+
+// noiseTiltCrossover is the corner frequency in Hz of the noise tilt shelving filter.
+const noiseTiltCrossover = 1000.0
+
+// noiseTiltMaxDB is the shelf attenuation in dB applied at a tilt of -1.0 or 1.0.
+const noiseTiltMaxDB = 12.0
+
+// NoiseTiltFilter tilts the spectrum of a noise stream with a first-order shelving filter.
+// Negative tilt attenuates content above the crossover (brown-ish), positive tilt attenuates
+// content below it (white-ish). A tilt of 0 passes the stream through unchanged.
+type NoiseTiltFilter struct {
+	stream   beep.Streamer
+	tiltFunc func(t float64) float64
+	sr       beep.SampleRate
+	pos      int
+	low      [2]float64 // One-pole low-pass state per channel
+}
+
+// Stream filters the noise samples according to the tilt at time t.
+func (ntf *NoiseTiltFilter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = ntf.stream.Stream(samples)
+	alpha := 1 - math.Exp(-2*math.Pi*noiseTiltCrossover/float64(ntf.sr))
+	for i := range samples[:n] {
+		t := float64(ntf.pos) / float64(ntf.sr)
+		tilt := math.Max(-1, math.Min(1, ntf.tiltFunc(t)))
+		lowGain, highGain := 1.0, 1.0
+		if tilt > 0 {
+			lowGain = math.Pow(10, -noiseTiltMaxDB*tilt/20)
+		} else if tilt < 0 {
+			highGain = math.Pow(10, noiseTiltMaxDB*tilt/20)
+		}
+		for c := 0; c < 2; c++ {
+			x := samples[i][c]
+			ntf.low[c] += alpha * (x - ntf.low[c])
+			if tilt != 0 {
+				samples[i][c] = ntf.low[c]*lowGain + (x-ntf.low[c])*highGain
+			}
+		}
+		ntf.pos++
+	}
+	return n, ok
+}
+
+// Err returns the error state of the noise stream.
+func (ntf *NoiseTiltFilter) Err() error {
+	return ntf.stream.Err()
+}
+
+// jitterControlRate is the number of carrier jitter control points generated per second.
+const jitterControlRate = 10.0
+
+// jitterSmoothing is the one-pole low-pass coefficient applied per jitter control point.
+const jitterSmoothing = 0.02
+
+// jitterGain scales the smoothed noise before it is soft-limited to the jitter bound.
+const jitterGain = 20.0
+
+// CarrierJitter produces a slow random walk for the carrier frequency, made from heavily
+// low-passed pink noise and soft-limited to within the jitter amount. Control points are
+// generated on demand and kept, so the offset at a given time is the same for both channels.
+type CarrierJitter struct {
+	noise    *PinkNoise
+	amount   float64
+	smoothed float64
+	points   []float64
+}
+
+// NewCarrierJitter creates a CarrierJitter varying by up to amount Hz.
+func NewCarrierJitter(seed int64, amount float64) *CarrierJitter {
+	return &CarrierJitter{
+		noise:  NewPinkNoise(seed),
+		amount: amount,
+	}
+}
+
+// At returns the carrier offset in Hz at time t.
+func (cj *CarrierJitter) At(t float64) float64 {
+	if t < 0 {
+		t = 0
+	}
+	pos := t * jitterControlRate
+	i := int(pos)
+	for len(cj.points) <= i+1 {
+		cj.smoothed += jitterSmoothing * (cj.noise.nextSample() - cj.smoothed)
+		cj.points = append(cj.points, cj.amount*math.Tanh(cj.smoothed*jitterGain))
+	}
+	frac := pos - float64(i)
+	return cj.points[i] + (cj.points[i+1]-cj.points[i])*frac
+}
+
+// DitherFloor generates triangular dither at a tiny level, independent in each channel, so a
+// mix it is added to never falls to digital silence.
+type DitherFloor struct {
+	rand  *rand.Rand
+	level float64 // Peak level of the dither
+}
+
+// NewDitherFloor creates a DitherFloor peaking at level.
+func NewDitherFloor(seed int64, level float64) *DitherFloor {
+	return &DitherFloor{rand: rand.New(rand.NewSource(seed)), level: level}
+}
+
+// Stream generates the dither samples.
+func (df *DitherFloor) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		for c := range samples[i] {
+			// The difference of two uniform values has a triangular distribution
+			samples[i][c] = (df.rand.Float64() - df.rand.Float64()) * df.level
+		}
+	}
+	return len(samples), true
+}
+
+// Err returns nil, as DitherFloor doesn't produce any errors.
+func (df *DitherFloor) Err() error {
+	return nil
+}
+
 // PinkNoiseControl controls the pink noise based on time.
 type PinkNoiseControl struct {
 	stream     beep.Streamer
 	volumeFunc func(t float64) float64
 	sr         beep.SampleRate
 	pos        int
+	lastVolume float64 // Last finite volume, used in place of a non-finite one
+
+	headroom     float64 // Linear scale applied to the output to leave headroom against clipping
+	smoothing    float64 // Per-sample coefficient of the volume smoothing (0 disables)
+	smoothVolume controlSmoother
+
+	panFunc func(t float64) float64 // Equal-power pan position over time, or nil to leave the noise centered
+
+	// Optional re-seeding of the noise generator at segment boundaries
+	boundaries   []int           // Sample positions where each new segment starts
+	nextBoundary int             // Index of the next boundary to reach
+	reseed       func(index int) // Called with the segment index at each boundary
 }
 
 // Stream processes the pink noise samples with volume control.
 func (pnc *PinkNoiseControl) Stream(samples [][2]float64) (n int, ok bool) {
-	n, ok = pnc.stream.Stream(samples)
-	for i := range samples[:n] {
+	if pnc.reseed == nil {
+		n, ok = pnc.stream.Stream(samples)
+		pnc.applyVolume(samples[:n])
+		return n, ok
+	}
+
+	// Split the request at segment boundaries so the generator is re-seeded exactly on them
+	for n < len(samples) {
+		for pnc.nextBoundary < len(pnc.boundaries) && pnc.boundaries[pnc.nextBoundary] <= pnc.pos {
+			pnc.nextBoundary++
+			pnc.reseed(pnc.nextBoundary)
+		}
+
+		end := len(samples)
+		if pnc.nextBoundary < len(pnc.boundaries) {
+			if b := n + pnc.boundaries[pnc.nextBoundary] - pnc.pos; b < end {
+				end = b
+			}
+		}
+
+		sn, sok := pnc.stream.Stream(samples[n:end])
+		pnc.applyVolume(samples[n : n+sn])
+		n += sn
+		if !sok {
+			return n, n > 0
+		}
+	}
+	return n, true
+}
+
+// applyVolume scales the noise samples by the volume at their time.
+func (pnc *PinkNoiseControl) applyVolume(samples [][2]float64) {
+	for i := range samples {
 		t := float64(pnc.pos) / float64(pnc.sr)
-		vol := pnc.volumeFunc(t)
+		vol := finiteOr(pnc.volumeFunc(t), pnc.lastVolume)
+		pnc.lastVolume = vol
+		vol = pnc.smoothVolume.next(vol, pnc.smoothing)
 		if vol <= 0 {
 			samples[i][0] = 0
 			samples[i][1] = 0
 		} else {
-			s := samples[i][0] * vol * 0.5 // Scaled down to prevent clipping
-			samples[i][0] = s
-			samples[i][1] = s
+			left, right := vol*pnc.headroom, vol*pnc.headroom
+			if pnc.panFunc != nil {
+				// A centered pan is skipped so the level is exactly that of unpanned noise
+				if pan := pnc.panFunc(t); pan != 0 {
+					panLeft, panRight := equalPowerGains(pan)
+					left, right = left*panLeft, right*panRight
+				}
+			}
+			samples[i][0] *= left
+			samples[i][1] *= right
 		}
 		pnc.pos++
 	}
-	return n, ok
 }
 
 // Err returns the error state of the pink noise stream.
@@ -144,325 +647,2629 @@ func (pnc *PinkNoiseControl) Err() error {
 	return pnc.stream.Err()
 }
 
-// parseConfig reads and parses the YAML configuration file.
-func parseConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
+// Isochronic pulse envelope shapes.
+const (
+	beatShapeSquare    = "square"
+	beatShapeSine      = "sine"
+	beatShapeTrapezoid = "trapezoid"
+)
 
-	var cfg Config
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
-	}
+// defaultBeatRamp is the fraction of each pulse used for the trapezoid attack and release
+// when beat_ramp is not set.
+const defaultBeatRamp = 0.2
+
+// IsochronicGate pulses a stream on and off at the beat frequency. Each beat period is split
+// into an on half, shaped by the beat shape, and a silent off half.
+type IsochronicGate struct {
+	stream    beep.Streamer
+	rateFunc  func(t float64) float64
+	shapeFunc func(t float64) string
+	rampFunc  func(t float64) float64
+	sr        beep.SampleRate
+	pos       int
+	phase     float64 // Position within the current beat period, 0.0 to 1.0
+}
 
-	// Sort frequency changes by time
-	sort.Slice(cfg.FrequencyChanges, func(i, j int) bool {
-		return cfg.FrequencyChanges[i].Time < cfg.FrequencyChanges[j].Time
-	})
+// Stream applies the pulse envelope at time t to the samples.
+func (ig *IsochronicGate) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = ig.stream.Stream(samples)
+	for i := range samples[:n] {
+		t := float64(ig.pos) / float64(ig.sr)
+		gain := 1.0
+		if rate := ig.rateFunc(t); rate > 0 {
+			gain = beatEnvelope(ig.shapeFunc(t), ig.phase, ig.rampFunc(t))
+			ig.phase += rate / float64(ig.sr)
+			ig.phase -= math.Floor(ig.phase)
+		}
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+		ig.pos++
+	}
+	return n, ok
+}
 
-	return &cfg, nil
+// Err returns the error state of the wrapped stream.
+func (ig *IsochronicGate) Err() error {
+	return ig.stream.Err()
 }
 
-// createFreqFunc creates a function that returns the frequency at time t based on the frequency changes.
-func createFreqFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
-	return func(t float64) float64 {
-		if len(changes) == 0 {
+// beatEnvelope returns the isochronic pulse gain at phase (0.0 to 1.0) within a beat period.
+// For the trapezoid shape, ramp is the fraction of the on half spent on each of the attack
+// and release.
+func beatEnvelope(shape string, phase, ramp float64) float64 {
+	switch shape {
+	case beatShapeSine:
+		return 0.5 - 0.5*math.Cos(2*math.Pi*phase)
+	case beatShapeTrapezoid:
+		if phase >= 0.5 {
 			return 0
 		}
-
-		// If t is before the first change
-		if t <= changes[0].Time {
-			return changes[0].Frequency
+		ramp = math.Max(0, math.Min(0.5, ramp)) * 0.5
+		if ramp == 0 {
+			return 1
 		}
-
-		// If t is after the last change
-		if t >= changes[len(changes)-1].Time {
-			return changes[len(changes)-1].Frequency
+		return math.Min(1, math.Min(phase, 0.5-phase)/ramp)
+	default:
+		if phase < 0.5 {
+			return 1
 		}
+		return 0
+	}
+}
 
-		// Find the interval in which t falls
-		for i := 0; i < len(changes)-1; i++ {
-			if t >= changes[i].Time && t < changes[i+1].Time {
-				// Linear interpolation
-				t1 := changes[i].Time
-				t2 := changes[i+1].Time
-				f1 := changes[i].Frequency
-				f2 := changes[i+1].Frequency
-				return f1 + (f2-f1)*(t-t1)/(t2-t1)
-			}
-		}
+// AutoPan sweeps a stream across the stereo field with an oscillating equal-power pan.
+type AutoPan struct {
+	stream    beep.Streamer
+	rateFunc  func(t float64) float64
+	depthFunc func(t float64) float64
+	sr        beep.SampleRate
+	pos       int
+	phase     float64
+}
 
-		return changes[len(changes)-1].Frequency
+// Stream applies the pan position at time t to the samples.
+func (ap *AutoPan) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = ap.stream.Stream(samples)
+	for i := range samples[:n] {
+		t := float64(ap.pos) / float64(ap.sr)
+		ap.phase += 2 * math.Pi * ap.rateFunc(t) / float64(ap.sr)
+		left, right := equalPowerGains(ap.depthFunc(t) * math.Sin(ap.phase))
+		samples[i][0] *= left
+		samples[i][1] *= right
+		ap.pos++
 	}
+	return n, ok
 }
 
-// createBeatFreqFunc creates a function that returns the beat frequency at time t based on the frequency changes.
-func createBeatFreqFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
-	return func(t float64) float64 {
-		if len(changes) == 0 {
-			return 0
-		}
-
-		// If t is before the first change
-		if t <= changes[0].Time {
-			return changes[0].BeatFrequency
-		}
+// Err returns the error state of the wrapped stream.
+func (ap *AutoPan) Err() error {
+	return ap.stream.Err()
+}
 
-		// If t is after the last change
-		if t >= changes[len(changes)-1].Time {
-			return changes[len(changes)-1].BeatFrequency
-		}
+// equalPowerGains returns the left and right gains for a pan position from -1.0 (left) to
+// 1.0 (right), scaled so the centre position leaves the level unchanged.
+func equalPowerGains(pan float64) (left, right float64) {
+	angle := (pan + 1) * math.Pi / 4
+	return math.Cos(angle) * math.Sqrt2, math.Sin(angle) * math.Sqrt2
+}
 
-		// Find the interval in which t falls
-		for i := 0; i < len(changes)-1; i++ {
-			if t >= changes[i].Time && t < changes[i+1].Time {
-				// Linear interpolation
-				t1 := changes[i].Time
-				t2 := changes[i+1].Time
-				bf1 := changes[i].BeatFrequency
-				bf2 := changes[i+1].BeatFrequency
-				return bf1 + (bf2-bf1)*(t-t1)/(t2-t1)
-			}
-		}
+// StaticPan places a stream at a fixed position in the stereo field with an equal-power pan.
+type StaticPan struct {
+	stream beep.Streamer
+	pan    float64 // -1.0 for left to 1.0 for right
+}
 
-		return changes[len(changes)-1].BeatFrequency
+// Stream applies the pan gains to the samples.
+func (sp *StaticPan) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = sp.stream.Stream(samples)
+	left, right := equalPowerGains(sp.pan)
+	for i := range samples[:n] {
+		samples[i][0] *= left
+		samples[i][1] *= right
 	}
+	return n, ok
 }
 
-// createVolumeFunc creates a function that returns the volume at time t based on the tone volumes.
-func createVolumeFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
-	return func(t float64) float64 {
-		if len(changes) == 0 {
-			return 1.0
-		}
-
-		// If t is before the first change
-		if t <= changes[0].Time {
-			return changes[0].ToneVolume
-		}
-
-		// If t is after the last change
-		if t >= changes[len(changes)-1].Time {
-			return changes[len(changes)-1].ToneVolume
-		}
+// Err returns the error state of the wrapped stream.
+func (sp *StaticPan) Err() error {
+	return sp.stream.Err()
+}
 
-		// Find the interval in which t falls
-		for i := 0; i < len(changes)-1; i++ {
-			if t >= changes[i].Time && t < changes[i+1].Time {
-				// Linear interpolation
-				t1 := changes[i].Time
-				t2 := changes[i+1].Time
-				v1 := changes[i].ToneVolume
-				v2 := changes[i+1].ToneVolume
-				return v1 + (v2-v1)*(t-t1)/(t2-t1)
-			}
-		}
+// ChannelMask silences one channel of a stream.
+type ChannelMask struct {
+	stream  beep.Streamer
+	channel int // Channel to silence: 0 for left, 1 for right
+}
 
-		return changes[len(changes)-1].ToneVolume
+// Stream zeroes the masked channel and leaves the other untouched.
+func (cm *ChannelMask) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = cm.stream.Stream(samples)
+	for i := range samples[:n] {
+		samples[i][cm.channel] = 0
 	}
+	return n, ok
 }
 
-// createPinkNoiseFunc creates a function that returns the pink noise volume at time t, using linear interpolation.
-func createPinkNoiseFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
-	return func(t float64) float64 {
-		if len(changes) == 0 {
-			return 0.0
-		}
+// Err returns the error state of the wrapped stream.
+func (cm *ChannelMask) Err() error {
+	return cm.stream.Err()
+}
 
-		// If t is before the first change
-		if t <= changes[0].Time {
-			return changes[0].PinkNoiseVolume
-		}
+// SegmentMonitor passes its stream through and calls onChange as the stream reaches the time of
+// each frequency change.
+type SegmentMonitor struct {
+	stream   beep.Streamer
+	sr       beep.SampleRate
+	changes  []ConfigFrequencyChange
+	onChange func(t float64, fc ConfigFrequencyChange)
+	pos      int
+	next     int // Index of the next change to reach
+}
 
-		// If t is after the last change
-		if t >= changes[len(changes)-1].Time {
-			return changes[len(changes)-1].PinkNoiseVolume
+// Stream streams the samples and reports the changes reached within them.
+func (sm *SegmentMonitor) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = sm.stream.Stream(samples)
+	sm.pos += n
+	for sm.next < len(sm.changes) {
+		fc := sm.changes[sm.next]
+		if sm.sr.N(time.Duration(fc.Time*float64(time.Second))) >= sm.pos {
+			break
 		}
+		sm.onChange(fc.Time, fc)
+		sm.next++
+	}
+	return n, ok
+}
 
-		// Find the interval in which t falls
-		for i := 0; i < len(changes)-1; i++ {
-			if t >= changes[i].Time && t < changes[i+1].Time {
-				t1 := changes[i].Time
-				t2 := changes[i+1].Time
-
-				// Linear interpolation for volume
-				vol1 := changes[i].PinkNoiseVolume
-				vol2 := changes[i+1].PinkNoiseVolume
-				vol := vol1 + (vol2-vol1)*(t-t1)/(t2-t1)
-
-				return vol
-			}
-		}
+// Err returns the error state of the wrapped stream.
+func (sm *SegmentMonitor) Err() error {
+	return sm.stream.Err()
+}
 
-		return changes[len(changes)-1].PinkNoiseVolume
-	}
+// FadeControl applies a time-varying gain to a stream.
+type FadeControl struct {
+	stream   beep.Streamer
+	gainFunc func(t float64) float64
+	sr       beep.SampleRate
+	pos      int
 }
 
-// getTotalPlaybackTime calculates the total playback time based on the highest time in frequency changes.
-func getTotalPlaybackTime(changes []ConfigFrequencyChange) float64 {
-	if len(changes) == 0 {
-		return 0
-	}
-	maxTime := changes[0].Time
-	for _, change := range changes {
-		if change.Time > maxTime {
-			maxTime = change.Time
-		}
+// Stream applies the gain at time t to both channels.
+func (fc *FadeControl) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = fc.stream.Stream(samples)
+	for i := range samples[:n] {
+		t := float64(fc.pos) / float64(fc.sr)
+		gain := fc.gainFunc(t)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+		fc.pos++
 	}
-	return maxTime
+	return n, ok
 }
 
-func main() {
-	// Command-line flags
-	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
-	outputPath := flag.String("output", "", "Path to the output WAV file (if empty, audio will be played)")
-	stretchFactor := flag.Float64("stretch", 1.0, "Stretch factor for playback time (default 1.0)")
-	flag.Parse()
+// Err returns the error state of the wrapped stream.
+func (fc *FadeControl) Err() error {
+	return fc.stream.Err()
+}
 
-	// Parse the configuration file
-	cfg, err := parseConfig(*configPath)
+// parseConfig reads and parses the YAML configuration file. In strict mode unknown fields are
+// errors.
+func parseConfig(filename string, strict bool) (*Config, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Error parsing configuration file: %v", err)
+		return nil, err
 	}
+	defer file.Close()
 
-	for i := range cfg.FrequencyChanges {
-		cfg.FrequencyChanges[i].Time *= *stretchFactor
+	cfg, err := parseConfigReader(file, strict)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate the total playback time
-	totalPlaybackTime := getTotalPlaybackTime(cfg.FrequencyChanges)
-	if totalPlaybackTime == 0 {
-		log.Fatalf("Total playback time is zero. Check your configuration.")
+	// Resolve the noise file relative to the configuration file
+	if cfg.NoiseFile != "" && !filepath.IsAbs(cfg.NoiseFile) {
+		cfg.NoiseFile = filepath.Join(filepath.Dir(filename), cfg.NoiseFile)
 	}
 
-	// Sample rate
-	sr := beep.SampleRate(44100)
+	return cfg, nil
+}
+
+// parseConfigB64 parses a base64-encoded YAML (or JSON) configuration, as given to -config-b64.
+// Whitespace is ignored, so the line-wrapped output of base64 can be passed as is. A relative
+// noise_file is resolved against the working directory.
+func parseConfigB64(encoded string, strict bool) (*Config, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("decoding base64: %w", err)
+	}
+	return parseConfigReader(bytes.NewReader(data), strict)
+}
+
+// parseConfigReader reads and parses a YAML (or JSON) configuration from r.
+func parseConfigReader(r io.Reader, strict bool) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigData(data, strict)
+}
+
+// parseConfigData parses a YAML (or JSON) configuration. In strict mode unknown fields, such as
+// a misspelled tone_volume, are errors instead of being ignored.
+func parseConfigData(data []byte, strict bool) (*Config, error) {
+	// Blank and comment-only files parse to a document with no content. Tabs can't start a
+	// YAML token, so whitespace-only files are caught before parsing
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("the configuration is empty; it needs a frequency_changes list")
+	}
+
+	if strict {
+		// Tone sets decode as frequency changes, so their fields are checked too
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&Config{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := resolveToneSets(doc.Content[0]); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	err := doc.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BeatSchedule != nil {
+		if cfg.FrequencyChanges != nil {
+			return nil, errors.New("both frequency_changes and beat_schedule are set; use one or the other")
+		}
+		cfg.FrequencyChanges, err = expandBeatSchedule(cfg.BeatSchedule)
+		if err != nil {
+			return nil, err
+		}
+		cfg.BeatSchedule = nil
+	}
+	if cfg.FrequencyChanges == nil {
+		return nil, errors.New("the configuration has no frequency_changes list")
+	}
+	if len(cfg.FrequencyChanges) == 0 {
+		return nil, errors.New("frequency_changes is empty; add at least two changes")
+	}
+
+	switch cfg.NoiseFadeCurve {
+	case "", noiseFadeLinear, noiseFadeEqualPower, noiseFadeStep:
+	default:
+		return nil, fmt.Errorf("unknown noise_fade_curve %q (expected %q, %q or %q)", cfg.NoiseFadeCurve, noiseFadeLinear, noiseFadeEqualPower, noiseFadeStep)
+	}
+
+	switch cfg.Mode {
+	case "", modeBinaural, modeMonaural, modeIsochronic, modeDual:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (expected %q, %q, %q or %q)", cfg.Mode, modeBinaural, modeMonaural, modeIsochronic, modeDual)
+	}
+
+	for i, change := range cfg.FrequencyChanges {
+		if change.PinkNoiseVolumeDB != nil {
+			if change.PinkNoiseVolume != 0 {
+				return nil, fmt.Errorf("both pink_noise_volume and pink_noise_volume_db are set at time %.2f", change.Time)
+			}
+			// Convert to linear here so the volume interpolates in linear space like pink_noise_volume
+			cfg.FrequencyChanges[i].PinkNoiseVolume = dbToLinear(*change.PinkNoiseVolumeDB)
+		}
+
+		if change.FrequencyWander < 0 {
+			return nil, fmt.Errorf("frequency_wander %.2f at time %.2f is negative", change.FrequencyWander, change.Time)
+		}
+
+		if math.Abs(change.DetuneCents) > maxDetuneCents {
+			return nil, fmt.Errorf("detune_cents %.2f at time %.2f is outside -%v to %v", change.DetuneCents, change.Time, maxDetuneCents, maxDetuneCents)
+		}
+
+		if change.PinkNoisePan < -1 || change.PinkNoisePan > 1 {
+			return nil, fmt.Errorf("pink_noise_pan %.2f at time %.2f is outside -1 to 1", change.PinkNoisePan, change.Time)
+		}
+
+		if change.BeatCents != nil && change.BeatFrequency != 0 {
+			return nil, fmt.Errorf("both beat_frequency and beat_cents are set at time %.2f", change.Time)
+		}
+
+		switch change.Easing {
+		case "", easingLinear, easingEaseIn, easingEaseOut, easingEaseInOut:
+		default:
+			return nil, fmt.Errorf("unknown easing %q at time %.2f (expected %q, %q, %q or %q)", change.Easing, change.Time, easingLinear, easingEaseIn, easingEaseOut, easingEaseInOut)
+		}
+
+		switch change.BeatShape {
+		case "", beatShapeSquare, beatShapeSine, beatShapeTrapezoid:
+		default:
+			return nil, fmt.Errorf("unknown beat_shape %q at time %.2f (expected %q, %q or %q)", change.BeatShape, change.Time, beatShapeSquare, beatShapeSine, beatShapeTrapezoid)
+		}
+	}
+
+	if err := validateNoiseLayers(&cfg); err != nil {
+		return nil, err
+	}
+	if err := validateChannels(&cfg); err != nil {
+		return nil, err
+	}
+
+	for _, point := range cfg.MasterEnvelope {
+		if point.Gain < 0 {
+			return nil, fmt.Errorf("master_envelope gain %.2f at time %.2f is negative", point.Gain, point.Time)
+		}
+	}
+
+	// Sort frequency changes and envelope points by time
+	sort.SliceStable(cfg.FrequencyChanges, func(i, j int) bool {
+		return cfg.FrequencyChanges[i].Time < cfg.FrequencyChanges[j].Time
+	})
+	sort.SliceStable(cfg.MasterEnvelope, func(i, j int) bool {
+		return cfg.MasterEnvelope[i].Time < cfg.MasterEnvelope[j].Time
+	})
+
+	return &cfg, nil
+}
+
+// expandBeatSchedule returns the frequency changes of a beat schedule. Each entry adds a change
+// at the start and, when it has a hold, at the end of its hold, so the beat interpolates only
+// during the ramp to the next entry.
+func expandBeatSchedule(schedule *BeatSchedule) ([]ConfigFrequencyChange, error) {
+	if len(schedule.Entries) == 0 {
+		return nil, errors.New("beat_schedule has no entries")
+	}
+
+	changes := []ConfigFrequencyChange{}
+	var t float64
+	for i, entry := range schedule.Entries {
+		if entry.Beat < 0 || entry.Hold < 0 || entry.Ramp < 0 {
+			return nil, fmt.Errorf("beat_schedule entry %d has a negative beat, hold or ramp", i+1)
+		}
+
+		change := ConfigFrequencyChange{
+			Time:            t,
+			Frequency:       schedule.Frequency,
+			BeatFrequency:   entry.Beat,
+			ToneVolume:      schedule.ToneVolume,
+			PinkNoiseVolume: schedule.PinkNoiseVolume,
+		}
+		changes = append(changes, change)
+		if entry.Hold > 0 {
+			t += entry.Hold
+			change.Time = t
+			changes = append(changes, change)
+		}
+		if i+1 < len(schedule.Entries) {
+			t += entry.Ramp
+		}
+	}
+	return changes, nil
+}
+
+// resolveToneSets expands each frequency change that has a use field with the fields of the
+// named tone set. Fields given in the change itself take precedence over the set's.
+func resolveToneSets(root *yaml.Node) error {
+	if root.Kind != yaml.MappingNode {
+		return nil // Reported when the document is decoded
+	}
+
+	var toneSets, changes *yaml.Node
+	for j := 0; j+1 < len(root.Content); j += 2 {
+		switch root.Content[j].Value {
+		case "tone_sets":
+			toneSets = root.Content[j+1]
+		case "frequency_changes":
+			changes = root.Content[j+1]
+		}
+	}
+	if changes == nil || changes.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	sets := map[string]*yaml.Node{}
+	if toneSets != nil && toneSets.Kind == yaml.MappingNode {
+		for j := 0; j+1 < len(toneSets.Content); j += 2 {
+			name, set := toneSets.Content[j].Value, toneSets.Content[j+1]
+			if mappingValue(set, "use") != nil {
+				return fmt.Errorf("tone set %q uses another tone set; tone sets can't be nested", name)
+			}
+			if mappingValue(set, "time") != nil {
+				return fmt.Errorf("tone set %q has a time; give the time in each change that uses it", name)
+			}
+			sets[name] = set
+		}
+	}
+
+	for _, change := range changes.Content {
+		use := mappingValue(change, "use")
+		if use == nil {
+			continue
+		}
+		set, ok := sets[use.Value]
+		if !ok {
+			return fmt.Errorf("line %d: undefined tone set %q", use.Line, use.Value)
+		}
+		if set.Kind != yaml.MappingNode {
+			continue // Reported when the document is decoded
+		}
+		for j := 0; j+1 < len(set.Content); j += 2 {
+			if mappingValue(change, set.Content[j].Value) == nil {
+				change.Content = append(change.Content, set.Content[j], set.Content[j+1])
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value for key in the mapping node n, or nil if it has none.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for j := 0; j+1 < len(n.Content); j += 2 {
+		if n.Content[j].Value == key {
+			return n.Content[j+1]
+		}
+	}
+	return nil
+}
+
+// dbToLinear converts a level in dBFS to a linear gain, where 0 dB is full scale.
+func dbToLinear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// validateConfig checks the configuration values and returns a description of each problem found.
+func validateConfig(cfg *Config) []string {
+	var problems []string
+	if len(cfg.FrequencyChanges) == 0 {
+		problems = append(problems, "no frequency changes defined")
+	}
+	for i, fc := range cfg.FrequencyChanges {
+		if fc.Time < 0 {
+			problems = append(problems, fmt.Sprintf("frequency change %d: time %.2f is negative", i, fc.Time))
+		}
+		if fc.Frequency < 0 {
+			problems = append(problems, fmt.Sprintf("frequency change %d: frequency %.2f is negative", i, fc.Frequency))
+		}
+		if fc.PinkNoiseVolume < 0 || fc.PinkNoiseVolume > 1 {
+			problems = append(problems, fmt.Sprintf("frequency change %d: pink_noise_volume %.2f is outside 0.0 to 1.0", i, fc.PinkNoiseVolume))
+		}
+		if fc.ToneVolume < 0 || fc.ToneVolume > 1 {
+			problems = append(problems, fmt.Sprintf("frequency change %d: tone_volume %.2f is outside 0.0 to 1.0", i, fc.ToneVolume))
+		}
+		if fc.NoiseTilt < -1 || fc.NoiseTilt > 1 {
+			problems = append(problems, fmt.Sprintf("frequency change %d: noise_tilt %.2f is outside -1.0 to 1.0", i, fc.NoiseTilt))
+		}
+	}
+	if len(problems) == 0 && getTotalPlaybackTime(cfg.FrequencyChanges) == 0 {
+		problems = append(problems, "total playback time is zero")
+	}
+	return problems
+}
+
+// checkNyquist returns an error if a tone or its highest overtone reaches half the sample
+// rate anywhere in the timeline, where it would alias to an unrelated frequency.
+func checkNyquist(cfg *Config, sr beep.SampleRate) error {
+	baseFreqFunc := createFreqFunc(cfg.FrequencyChanges)
+	beatFreqFunc := createBeatFreqFunc(cfg.FrequencyChanges)
+
+	overtone := 1
+	for i, amp := range cfg.Harmonics {
+		if amp != 0 {
+			overtone = i + 1
+		}
+	}
+
+	// Frequencies are interpolated linearly, so each channel peaks at one of the changes
+	nyquist := float64(sr) / 2
+	for _, fc := range cfg.FrequencyChanges {
+		carrier := baseFreqFunc(fc.Time)
+		highest := (math.Max(carrier, carrier+beatFreqFunc(fc.Time)) + fc.FrequencyWander) * float64(overtone) * math.Pow(2, math.Max(0, fc.DetuneCents)/1200)
+		if highest >= nyquist {
+			return fmt.Errorf("channel frequency %.1f Hz at time %.2f reaches the Nyquist limit of %.0f Hz for a %d Hz sample rate", highest, fc.Time, nyquist, int(sr))
+		}
+	}
+	return nil
+}
+
+// loadWAV opens and decodes a WAV file, correcting the level of the decoded samples.
+func loadWAV(filename string) (beep.StreamSeekCloser, beep.Format, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+
+	streamer, format, err := wav.Decode(file)
+	if err != nil {
+		file.Close()
+		return nil, beep.Format{}, err
+	}
+
+	return &decodedWAV{StreamSeekCloser: streamer, scale: wavDecodeScale(format.Precision)}, format, nil
+}
+
+// decodeAudioFile opens and decodes a WAV, MP3 or Ogg Vorbis file, chosen by its extension.
+// Any other extension is decoded as WAV. Only beep/wav needs its level corrected; the MP3 and
+// Vorbis decoders return samples at the level they were encoded at.
+func decodeAudioFile(filename string) (beep.StreamSeekCloser, beep.Format, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mp3":
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, beep.Format{}, err
+		}
+		streamer, format, err := mp3.Decode(file)
+		if err != nil {
+			file.Close()
+			return nil, beep.Format{}, err
+		}
+		return streamer, format, nil
+	case ".ogg", ".oga":
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, beep.Format{}, err
+		}
+		streamer, format, err := vorbis.Decode(file)
+		if err != nil {
+			file.Close()
+			return nil, beep.Format{}, err
+		}
+		return streamer, format, nil
+	}
+	return loadWAV(filename)
+}
+
+// decodedWAV scales the samples of a beep/wav decoder back to the level they were encoded at.
+type decodedWAV struct {
+	beep.StreamSeekCloser
+	scale float64
+}
+
+// Stream streams the decoded samples with the level correction applied.
+func (d *decodedWAV) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = d.StreamSeekCloser.Stream(samples)
+	for i := range samples[:n] {
+		samples[i][0] *= d.scale
+		samples[i][1] *= d.scale
+	}
+	return n, ok
+}
+
+// wavDecodeScale returns the factor that restores samples decoded by beep/wav to the level they
+// were encoded at. For signed precisions the decoder divides by 2^bits-1 while the encoder
+// multiplies by 2^(bits-1)-1, so decoded samples come back at roughly half scale.
+func wavDecodeScale(precision int) float64 {
+	if precision == 1 {
+		return 1
+	}
+	bits := float64(precision * 8)
+	return (math.Exp2(bits) - 1) / (math.Exp2(bits-1) - 1)
+}
+
+// loadNoiseFile decodes the audio file at filename and loops it forever as a noise source,
+// resampled to sr if needed.
+func loadNoiseFile(filename string, sr beep.SampleRate, quality int) (beep.Streamer, io.Closer, error) {
+	noise, format, err := decodeAudioFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if noise.Len() == 0 {
+		noise.Close()
+		return nil, nil, fmt.Errorf("%s contains no samples", filename)
+	}
+
+	var streamer beep.Streamer = beep.Loop(-1, noise)
+	if format.SampleRate != sr {
+		streamer = beep.Resample(quality, format.SampleRate, sr, streamer)
+	}
+	return streamer, noise, nil
+}
+
+// prependIntro plays the intro audio file before s, resampling it to sr if needed. It returns the
+// combined streamer, the intro duration in seconds and the intro file to close after use.
+func prependIntro(s beep.Streamer, filename string, sr beep.SampleRate, quality int) (beep.Streamer, float64, io.Closer, error) {
+	if quality < 1 || quality > 64 {
+		return nil, 0, nil, fmt.Errorf("resample quality must be between 1 and 64, got %d", quality)
+	}
+
+	intro, introFormat, err := decodeAudioFile(filename)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	introDuration := introFormat.SampleRate.D(intro.Len()).Seconds()
+
+	// Match the session sample rate so the intro plays at the right pitch
+	var introStreamer beep.Streamer = intro
+	if introFormat.SampleRate != sr {
+		introStreamer = beep.Resample(quality, introFormat.SampleRate, sr, intro)
+	}
+
+	return beep.Seq(introStreamer, s), introDuration, intro, nil
+}
+
+// interpolateChanges returns the value selected by field at time t, linearly interpolated
+// between the surrounding frequency changes. changes must be non-empty and sorted by time.
+func interpolateChanges(changes []ConfigFrequencyChange, t float64, field func(ConfigFrequencyChange) float64) float64 {
+	return interpolateChangesWith(changes, t, field, linearBlend)
+}
+
+// interpolateChangesWith is like interpolateChanges but combines the values on either side of t
+// with blend, given the fraction of the interval that has elapsed.
+func interpolateChangesWith(changes []ConfigFrequencyChange, t float64, field func(ConfigFrequencyChange) float64, blend func(v1, v2, frac float64) float64) float64 {
+	// If t is before the first change
+	if t <= changes[0].Time {
+		return field(changes[0])
+	}
+
+	// If t is after the last change
+	if t >= changes[len(changes)-1].Time {
+		return field(changes[len(changes)-1])
+	}
+
+	// Find the interval in which t falls
+	for i := 0; i < len(changes)-1; i++ {
+		if t >= changes[i].Time && t < changes[i+1].Time {
+			t1 := changes[i].Time
+			t2 := changes[i+1].Time
+			return blend(field(changes[i]), field(changes[i+1]), ease(changes[i].Easing, (t-t1)/(t2-t1)))
+		}
+	}
+
+	return field(changes[len(changes)-1])
+}
+
+// Segment easing curves.
+const (
+	easingLinear    = "linear"
+	easingEaseIn    = "ease_in"
+	easingEaseOut   = "ease_out"
+	easingEaseInOut = "ease_in_out"
+)
+
+// ease maps the elapsed fraction of a segment through a cubic easing curve. Unknown and empty
+// curves are linear.
+func ease(curve string, frac float64) float64 {
+	switch curve {
+	case easingEaseIn:
+		return frac * frac * frac
+	case easingEaseOut:
+		inv := 1 - frac
+		return 1 - inv*inv*inv
+	case easingEaseInOut:
+		if frac < 0.5 {
+			return 4 * frac * frac * frac
+		}
+		inv := 2 - 2*frac
+		return 1 - inv*inv*inv/2
+	default:
+		return frac
+	}
+}
+
+// linearBlend linearly interpolates between v1 and v2.
+func linearBlend(v1, v2, frac float64) float64 {
+	return v1 + (v2-v1)*frac
+}
+
+// stepBlend holds v1 until the next change is reached, so a switch to v2 happens at the time
+// of the change that sets it.
+func stepBlend(v1, v2, frac float64) float64 {
+	return v1
+}
+
+// equalPowerBlend interpolates the power rather than the amplitude between v1 and v2, so a fade
+// from or to silence follows the square root of the elapsed fraction.
+func equalPowerBlend(v1, v2, frac float64) float64 {
+	return math.Sqrt(v1*v1*(1-frac) + v2*v2*frac)
+}
+
+// createFreqFunc creates a function that returns the frequency at time t based on the frequency changes,
+// never below 0 Hz.
+func createFreqFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0
+		}
+		return math.Max(0, interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.Frequency }))
+	}
+}
+
+// createBeatFreqFunc creates a function that returns the beat frequency at time t based on the frequency changes.
+func createBeatFreqFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	if hasBeatCents(changes) {
+		// Interpolate the interval and apply it to the carrier at t, so the beat follows the carrier
+		freqFunc := createFreqFunc(changes)
+		return func(t float64) float64 {
+			if len(changes) == 0 {
+				return 0
+			}
+			cents := interpolateChanges(changes, t, beatCents)
+			return freqFunc(t) * (math.Pow(2, cents/1200) - 1)
+		}
+	}
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.BeatFrequency })
+	}
+}
+
+// createBeatFreq2Func creates a function that returns the dual mode pulse rate at time t based on the frequency changes.
+func createBeatFreq2Func(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.BeatFrequency2 })
+	}
+}
+
+// hasBeatCents reports whether any change gives its beat in cents.
+func hasBeatCents(changes []ConfigFrequencyChange) bool {
+	for _, c := range changes {
+		if c.BeatCents != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// beatCents returns the beat of a change as an interval in cents above its carrier, converting
+// beat_frequency when beat_cents isn't set.
+func beatCents(c ConfigFrequencyChange) float64 {
+	if c.BeatCents != nil {
+		return *c.BeatCents
+	}
+	if c.Frequency <= 0 || c.BeatFrequency <= -c.Frequency {
+		return 0
+	}
+	return 1200 * math.Log2(1+c.BeatFrequency/c.Frequency)
+}
+
+// changeBeatFrequency returns the beat frequency in Hz at a change, converting beat_cents at
+// the change's carrier when it is set.
+func changeBeatFrequency(c ConfigFrequencyChange) float64 {
+	if c.BeatCents != nil {
+		return c.Frequency * (math.Pow(2, *c.BeatCents/1200) - 1)
+	}
+	return c.BeatFrequency
+}
+
+// createVolumeFunc creates a function that returns the volume at time t based on the tone volumes,
+// clamped to the 0 to 1 range.
+func createVolumeFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 1.0
+		}
+		return clampVolume(interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.ToneVolume }))
+	}
+}
+
+// createPinkNoiseFunc creates a function that returns the pink noise volume at time t, interpolated
+// according to the noise fade curve and clamped to the 0 to 1 range.
+func createPinkNoiseFunc(changes []ConfigFrequencyChange, curve string) func(t float64) float64 {
+	blend := linearBlend
+	switch curve {
+	case noiseFadeEqualPower:
+		blend = equalPowerBlend
+	case noiseFadeStep:
+		blend = stepBlend
+	}
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return clampVolume(interpolateChangesWith(changes, t, func(c ConfigFrequencyChange) float64 { return c.PinkNoiseVolume }, blend))
+	}
+}
+
+// clampVolume limits an interpolated volume to the 0 to 1 range, so an easing or blend that
+// overshoots, or an out-of-range volume in the config, can't make a loud spot or invert the
+// signal.
+func clampVolume(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// createNoiseTiltFunc creates a function that returns the pink noise tilt at time t, using linear interpolation.
+func createNoiseTiltFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.NoiseTilt })
+	}
+}
+
+// createNoisePanFunc creates a function that returns the pink noise pan position at time t,
+// using linear interpolation, or nil if no change pans the noise.
+func createNoisePanFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	panned := false
+	for _, change := range changes {
+		if change.PinkNoisePan != 0 {
+			panned = true
+			break
+		}
+	}
+	if !panned {
+		return nil
+	}
+	return func(t float64) float64 {
+		return math.Max(-1, math.Min(1, interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.PinkNoisePan })))
+	}
+}
+
+// createDetuneFunc creates a function that returns the tone detune in cents at time t, using linear interpolation.
+func createDetuneFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.DetuneCents })
+	}
+}
+
+// createAutopanRateFunc creates a function that returns the tone auto-pan rate at time t, using linear interpolation.
+func createAutopanRateFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.AutopanRate })
+	}
+}
+
+// createAutopanDepthFunc creates a function that returns the tone auto-pan depth at time t, using linear interpolation.
+func createAutopanDepthFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.AutopanDepth })
+	}
+}
+
+// createMasterEnvelopeFunc creates a function that returns the master envelope gain at time t.
+// The points are interpolated like frequency changes, holding the first and last gains before
+// and after them. Without points the gain is 1.
+func createMasterEnvelopeFunc(points []EnvelopePoint) func(t float64) float64 {
+	if len(points) == 0 {
+		return func(t float64) float64 { return 1 }
+	}
+	changes := make([]ConfigFrequencyChange, len(points))
+	for i, point := range points {
+		changes[i] = ConfigFrequencyChange{Time: point.Time, ToneVolume: point.Gain}
+	}
+	return func(t float64) float64 {
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.ToneVolume })
+	}
+}
+
+// createFrequencyWanderFunc creates a function that returns the frequency wander amplitude at time t.
+func createFrequencyWanderFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 { return c.FrequencyWander })
+	}
+}
+
+// hasFrequencyWander reports whether any change sets a frequency wander.
+func hasFrequencyWander(changes []ConfigFrequencyChange) bool {
+	for _, c := range changes {
+		if c.FrequencyWander > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinaural reports whether the configuration uses binaural synthesis, which needs each ear
+// to hear only its own channel.
+func isBinaural(cfg *Config) bool {
+	return cfg.Mode == "" || cfg.Mode == modeBinaural || cfg.Mode == modeDual
+}
+
+// hasBeat reports whether any frequency change has a nonzero beat frequency.
+func hasBeat(changes []ConfigFrequencyChange) bool {
+	for _, change := range changes {
+		if changeBeatFrequency(change) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDetune reports whether any frequency change sets detune_cents.
+func hasDetune(changes []ConfigFrequencyChange) bool {
+	for _, change := range changes {
+		if change.DetuneCents != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAutopan reports whether any frequency change enables tone auto-panning.
+func hasAutopan(changes []ConfigFrequencyChange) bool {
+	for _, change := range changes {
+		if change.AutopanDepth != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// createBeatShapeFunc creates a function that returns the isochronic pulse shape of the segment
+// active at time t. Shapes are not interpolated; each applies from its change until the next.
+func createBeatShapeFunc(changes []ConfigFrequencyChange) func(t float64) string {
+	return func(t float64) string {
+		if len(changes) == 0 {
+			return beatShapeSquare
+		}
+
+		shape := changes[0].BeatShape
+		for _, change := range changes[1:] {
+			if change.Time > t {
+				break
+			}
+			shape = change.BeatShape
+		}
+		if shape == "" {
+			return beatShapeSquare
+		}
+		return shape
+	}
+}
+
+// createBeatRampFunc creates a function that returns the trapezoid pulse ramp at time t, using linear interpolation.
+func createBeatRampFunc(changes []ConfigFrequencyChange) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return defaultBeatRamp
+		}
+		return interpolateChanges(changes, t, func(c ConfigFrequencyChange) float64 {
+			if c.BeatRamp == 0 {
+				return defaultBeatRamp
+			}
+			return c.BeatRamp
+		})
+	}
+}
+
+// createLabelFunc creates a function that returns the label of the segment active at time t.
+// Labels are not interpolated; each one applies from its change until the next.
+func createLabelFunc(changes []ConfigFrequencyChange) func(t float64) string {
+	return func(t float64) string {
+		if len(changes) == 0 {
+			return ""
+		}
+
+		label := changes[0].Label
+		for _, change := range changes[1:] {
+			if change.Time > t {
+				break
+			}
+			label = change.Label
+		}
+		return label
+	}
+}
+
+// createToneGateFunc creates a function that returns 1 while a tone is enabled at time t and 0
+// while it is off. Each change's setting of enabled holds until the next change that sets it,
+// and a tone is on until a change turns it off.
+func createToneGateFunc(changes []ConfigFrequencyChange, enabled func(ConfigFrequencyChange) *bool) func(t float64) float64 {
+	return func(t float64) float64 {
+		on := true
+		for i, change := range changes {
+			if i > 0 && change.Time > t {
+				break
+			}
+			if e := enabled(change); e != nil {
+				on = *e
+			}
+		}
+		if on {
+			return 1
+		}
+		return 0
+	}
+}
+
+// hasToneGates reports whether any frequency change sets left_on or right_on.
+func hasToneGates(changes []ConfigFrequencyChange) bool {
+	for _, change := range changes {
+		if change.LeftOn != nil || change.RightOn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Gain curves of the -autofade-last fade.
+const (
+	fadeCurveLinear      = "linear"
+	fadeCurveExponential = "exponential" // Squared gain, which sounds more even than linear
+)
+
+// createLastSegmentFadeFunc creates a gain function that fades from 1.0 to 0.0 along curve
+// across the final interval between the last two frequency changes.
+func createLastSegmentFadeFunc(changes []ConfigFrequencyChange, curve string) func(t float64) float64 {
+	return func(t float64) float64 {
+		if len(changes) < 2 {
+			return 1.0
+		}
+
+		start := changes[len(changes)-2].Time
+		end := changes[len(changes)-1].Time
+		if end <= start || t <= start {
+			return 1.0
+		}
+		if t >= end {
+			return 0.0
+		}
+
+		gain := 1.0 - (t-start)/(end-start)
+		if curve == fadeCurveExponential {
+			// Loudness follows the level in dB, so a linear ramp seems to hold and then drop
+			return gain * gain
+		}
+		return gain
+	}
+}
+
+// isSilent reports whether a frequency change has both the tone and the pink noise turned off.
+func isSilent(fc ConfigFrequencyChange) bool {
+	return fc.ToneVolume == 0 && fc.PinkNoiseVolume == 0
+}
+
+// trimSilence removes leading and trailing segments where both the tone and the pink noise
+// are silent, shifting the remaining changes so playback starts at the first audible segment.
+// It returns the trimmed changes along with the amount of leading and trailing time removed.
+func trimSilence(changes []ConfigFrequencyChange) ([]ConfigFrequencyChange, float64, float64, error) {
+	first, last := -1, -1
+	for i, fc := range changes {
+		if !isSilent(fc) {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first == -1 {
+		return nil, 0, 0, errors.New("every frequency change is silent")
+	}
+
+	// Keep the silent changes adjacent to the audible ones so fades in and out are preserved
+	if first > 0 {
+		first--
+	}
+	if last < len(changes)-1 {
+		last++
+	}
+
+	start := changes[first].Time
+	if first == 0 {
+		start = 0
+	}
+	trimmed := make([]ConfigFrequencyChange, 0, last-first+1)
+	for _, fc := range changes[first : last+1] {
+		fc.Time -= start
+		trimmed = append(trimmed, fc)
+	}
+
+	leading := start
+	trailing := getTotalPlaybackTime(changes) - changes[last].Time
+	return trimmed, leading, trailing, nil
+}
+
+// normalizeTimes shifts every change earlier so the first one is at t=0, and returns the
+// shifted changes along with the shift in seconds.
+func normalizeTimes(changes []ConfigFrequencyChange) ([]ConfigFrequencyChange, float64) {
+	if len(changes) == 0 || changes[0].Time <= 0 {
+		return changes, 0
+	}
+
+	shift := changes[0].Time
+	shifted := make([]ConfigFrequencyChange, len(changes))
+	for i, fc := range changes {
+		fc.Time -= shift
+		shifted[i] = fc
+	}
+	return shifted, shift
+}
+
+// insertLeadInSilence makes the time before the first change silent. Without it the settings
+// of the first change are held from t=0. The silent changes keep the first change's
+// frequencies so only the volumes step up when it is reached.
+func insertLeadInSilence(changes []ConfigFrequencyChange) []ConfigFrequencyChange {
+	if len(changes) == 0 || changes[0].Time <= 0 {
+		return changes
+	}
+
+	silent := changes[0]
+	silent.PinkNoiseVolume = 0
+	silent.PinkNoiseVolumeDB = nil
+	silent.ToneVolume = 0
+	silent.Label = ""
+
+	start := silent
+	start.Time = 0
+	return append([]ConfigFrequencyChange{start, silent}, changes...)
+}
+
+// holdLastChange extends the session by hold seconds past the last change, repeating its values
+// so they play unchanged for that time.
+func holdLastChange(changes []ConfigFrequencyChange, hold float64) []ConfigFrequencyChange {
+	if len(changes) == 0 || hold <= 0 {
+		return changes
+	}
+	last := changes[len(changes)-1]
+	last.Time += hold
+	return append(changes, last)
+}
+
+// limitBeatSlope lengthens each transition whose beat frequency would change faster than
+// maxSlope Hz per second, delaying every later change by the time added. Easing is allowed for,
+// since the cubic curves peak at three times the average rate. It returns the limited changes,
+// the number of transitions lengthened and the total time added in seconds.
+func limitBeatSlope(changes []ConfigFrequencyChange, maxSlope float64) ([]ConfigFrequencyChange, int, float64) {
+	limited := make([]ConfigFrequencyChange, len(changes))
+	copy(limited, changes)
+
+	var stretched int
+	var added float64
+	for i := 1; i < len(limited); i++ {
+		from, to := changes[i-1], changes[i]
+		limited[i].Time = to.Time + added
+
+		peak := 1.0
+		if from.Easing != "" && from.Easing != easingLinear {
+			peak = 3
+		}
+		needed := peak * math.Abs(changeBeatFrequency(to)-changeBeatFrequency(from)) / maxSlope
+		if gap := to.Time - from.Time; needed > gap {
+			added += needed - gap
+			limited[i].Time += needed - gap
+			stretched++
+		}
+	}
+	return limited, stretched, added
+}
+
+// Limits of the -buffer-ms speaker buffer size, in milliseconds.
+const (
+	minSpeakerBufferMS = 10
+	maxSpeakerBufferMS = 1000
+)
+
+// speakerBufferSize returns the speaker buffer size in whole frames for a buffer of ms
+// milliseconds at sr.
+func speakerBufferSize(sr beep.SampleRate, ms int) int {
+	return sr.N(time.Duration(ms) * time.Millisecond)
+}
+
+// initSpeaker initializes the audio backend for playback to device. Failures of the backend,
+// including panics, are returned as an error.
+func initSpeaker(device string, sr beep.SampleRate, bufferSize int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return backend.Init(device, sr, bufferSize)
+}
+
+// speakerStartTimeout is how long to wait for the speaker to request its first samples.
+const speakerStartTimeout = 3 * time.Second
+
+// interruptFadeTime is how long playback fades out for when it is interrupted with Ctrl-C.
+const interruptFadeTime = 500 * time.Millisecond
+
+// InterruptFade passes its stream through until a fade is started, then fades it to silence over
+// length frames and ends it. Start the fade with the speaker locked.
+type InterruptFade struct {
+	stream    beep.Streamer
+	length    int  // Fade length in frames
+	fading    bool // Whether the fade has started
+	remaining int  // Frames left in the fade
+}
+
+// start begins the fade. Calls after the first have no effect.
+func (f *InterruptFade) start() {
+	if !f.fading {
+		f.fading = true
+		f.remaining = f.length
+	}
+}
+
+// Stream streams the samples, fading them out once the fade has started.
+func (f *InterruptFade) Stream(samples [][2]float64) (n int, ok bool) {
+	if f.fading && f.remaining == 0 {
+		return 0, false
+	}
+	n, ok = f.stream.Stream(samples)
+	if !f.fading {
+		return n, ok
+	}
+	for i := range samples[:n] {
+		if f.remaining == 0 {
+			return i, true
+		}
+		f.remaining--
+		gain := float64(f.remaining) / float64(f.length)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+	}
+	return n, ok
+}
+
+// Err returns the error state of the wrapped stream.
+func (f *InterruptFade) Err() error {
+	return f.stream.Err()
+}
+
+// startSignal closes its started channel the first time the wrapped stream is read.
+type startSignal struct {
+	stream  beep.Streamer
+	started chan struct{}
+	once    sync.Once
+}
+
+// Stream signals the start of playback and streams from the wrapped streamer.
+func (ss *startSignal) Stream(samples [][2]float64) (n int, ok bool) {
+	ss.once.Do(func() { close(ss.started) })
+	return ss.stream.Stream(samples)
+}
+
+// Err returns the error state of the wrapped stream.
+func (ss *startSignal) Err() error {
+	return ss.stream.Err()
+}
+
+// startPlayback plays s on the speaker and prints the session's status and the peak level of
+// each channel every 3 seconds. The returned channel is closed when playback finishes, and stop
+// ends playback and the status output early. fadeOut fades playback out over
+// interruptFadeTime, after which it finishes. stop must be called once playback is no longer
+// needed.
+func startPlayback(s beep.Streamer, sr beep.SampleRate, session *Session, introDuration float64) (done <-chan struct{}, stop, fadeOut func()) {
+	finished := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// Record the start time
+	startTime := time.Now()
+
+	// Play the audio, metering it after the mix
+	meter := &peakMeter{stream: s, sr: sr}
+	fade := &InterruptFade{stream: meter, length: sr.N(interruptFadeTime)}
+	started := &startSignal{stream: fade, started: make(chan struct{})}
+	backend.Play(beep.Seq(started, beep.Callback(func() {
+		close(finished)
+	})))
+
+	// Some backends report a missing device only by never requesting samples
+	select {
+	case <-started.started:
+	case <-time.After(speakerStartTimeout):
+		fatalf("No audio device available (playback did not start); use -output to export to a file instead")
+	}
+
+	// Create a ticker to output status every 3 seconds
+	ticker := time.NewTicker(3 * time.Second)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t := time.Since(startTime).Seconds() - introDuration
+				if t < 0 {
+					infof("Intro: %.2f s remaining\n", -t)
+					continue
+				}
+				if t > session.totalPlaybackTime {
+					return
+				}
+				freq := session.baseFreqFunc(t)
+				beatFreq := session.beatFreqFunc(t)
+				toneVol := session.volumeFunc(t)
+				pinkVol := session.pinkNoiseFunc(t)
+				label := ""
+				if l := session.labelFunc(t); l != "" {
+					label = fmt.Sprintf("[%s] ", l)
+				}
+				note := ""
+				if beatFreq == 0 {
+					note = " (beat 0 Hz: pure tone, no binaural effect)"
+				}
+				peakLeft, peakRight := meter.levels()
+				infof("%sTime: %.2f s, Base Frequency: %.2f Hz, Beat Frequency: %.2f Hz%s, Tone Volume: %.2f, Pink Noise Volume: %.2f, Peak L: %s, R: %s\n",
+					label, t, freq, beatFreq, note, toneVol, pinkVol, formatPeakLevel(peakLeft), formatPeakLevel(peakRight))
+			case <-finished:
+				return
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			backend.Clear()
+			close(stopped)
+		})
+	}
+	fadeOut = func() {
+		backend.Lock()
+		fade.start()
+		backend.Unlock()
+	}
+	return finished, stop, fadeOut
+}
+
+// getTotalPlaybackTime calculates the total playback time based on the highest time in frequency changes.
+func getTotalPlaybackTime(changes []ConfigFrequencyChange) float64 {
+	if len(changes) == 0 {
+		return 0
+	}
+	maxTime := changes[0].Time
+	for _, change := range changes {
+		if change.Time > maxTime {
+			maxTime = change.Time
+		}
+	}
+	return maxTime
+}
+
+// SessionOptions holds the synthesis options that are not part of the configuration file.
+type SessionOptions struct {
+	Seed            int64   // Seed for the pink noise generator
+	SeedPerSegment  bool    // Re-seed the pink noise generator at every segment boundary
+	Jitter          float64 // Maximum random carrier offset in Hz (0 disables)
+	NoiseTail       float64 // Seconds of pink noise to play after the tones end
+	ResampleQuality int     // Quality of resampling a noise file to the session sample rate
+	Isolate         string  // Channel to keep: isolateLeft, isolateRight or isolateNone
+	AutoFadeLast    bool    // Fade out across the final segment
+	FadeCurve       string  // Gain curve of the AutoFadeLast fade: fadeCurveLinear (default) or fadeCurveExponential
+	RandomPhase     bool    // Start each tone at a random phase derived from Seed
+	ToneBelowNoise  float64 // dB below the pink noise volume to set the tone volume while the noise is on (0 disables)
+	TonePan         float64 // Static tone pan from -1.0 (left) to 1.0 (right) outside binaural mode
+	Gain            float64 // Linear gain applied to the whole session, e.g. from normalization (0 means 1.0)
+	Stem            string  // Render only this component of the mix, or everything if empty
+	NoiseAmplitude  float64 // Pink noise generator amplitude (0 uses pinkNoiseAmplitude)
+	NoiseFloor      float64 // Peak level in dBFS of a dither floor added to the full mix (0 disables)
+	SmoothTime      float64 // Time constant in seconds of the frequency and volume smoothing (0 disables)
+	AttackTime      float64 // Seconds of the ramp applied when a tone's volume rises from zero (0 disables)
+	Headroom        float64 // dB the tones and noise are scaled below full scale; defaultHeadroomDB keeps the original level
+	NoiseWarmup     int     // Samples the pink noise generators are advanced before the session starts (0 disables)
+
+	// Called from the streaming goroutine when the first sample at or after each change's time
+	// is streamed, so it must return quickly
+	OnSegmentChange func(t float64, fc ConfigFrequencyChange)
+}
+
+// defaultSessionOptions returns the options the command line renders with by default, for
+// sessions built without flags such as those of the API.
+func defaultSessionOptions(seed int64) SessionOptions {
+	return SessionOptions{
+		Seed:        seed,
+		SmoothTime:  defaultSmoothMS / 1000,
+		AttackTime:  defaultAttackMS / 1000,
+		Headroom:    defaultHeadroomDB,
+		NoiseWarmup: defaultNoiseWarmup,
+	}
+}
+
+// Mix components that can be rendered on their own with -stems.
+const (
+	stemLeftTone  = "left_tone"  // The left tone in binaural mode
+	stemRightTone = "right_tone" // The right tone in binaural mode
+	stemTones     = "tones"      // All tones in monaural and isochronic modes
+	stemNoise     = "noise"      // The pink noise or noise file
+)
+
+// sessionStems returns the stems the session can be split into.
+func sessionStems(cfg *Config) []string {
+	if isBinaural(cfg) {
+		return []string{stemLeftTone, stemRightTone, stemNoise}
+	}
+	return []string{stemTones, stemNoise}
+}
+
+// Channels that can be isolated with -isolate.
+const (
+	isolateNone  = "none"
+	isolateLeft  = "left"
+	isolateRight = "right"
+)
+
+// Session is a synthesized binaural beats session ready for playback or export.
+type Session struct {
+	streamer          beep.Streamer
+	totalSamples      int
+	totalPlaybackTime float64
+	baseFreqFunc      func(t float64) float64
+	beatFreqFunc      func(t float64) float64
+	leftFreqFunc      func(t float64) float64 // Frequency of the left tone, or the only tone in isochronic mode
+	rightFreqFunc     func(t float64) float64 // Frequency of the right tone
+	volumeFunc        func(t float64) float64
+	pinkNoiseFunc     func(t float64) float64
+	labelFunc         func(t float64) string
+	closers           []io.Closer
+}
+
+// Close releases any files the session streams from.
+func (s *Session) Close() error {
+	var firstErr error
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newSession builds the tone and pink noise streamers for the configuration and mixes them
+// into a single streamer limited to the total playback time. The session should be closed
+// once it is no longer streamed.
+func newSession(cfg *Config, sr beep.SampleRate, opts SessionOptions) (*Session, error) {
+	// Create frequency functions based on configuration
+	baseFreqFunc := createFreqFunc(cfg.FrequencyChanges)
+	beatFreqFunc := createBeatFreqFunc(cfg.FrequencyChanges)
+	volumeFunc := createVolumeFunc(cfg.FrequencyChanges)
+	pinkNoiseFunc := createPinkNoiseFunc(cfg.FrequencyChanges, cfg.NoiseFadeCurve)
+	if opts.ToneBelowNoise != 0 {
+		// Follow the noise level while the noise is on; otherwise use the configured volume
+		configuredVolumeFunc := volumeFunc
+		gain := dbToLinear(-opts.ToneBelowNoise)
+		volumeFunc = func(t float64) float64 {
+			if noise := pinkNoiseFunc(t); noise > 0 {
+				return math.Min(1, noise*gain)
+			}
+			return configuredVolumeFunc(t)
+		}
+	}
+	if opts.NoiseTail > 0 {
+		// Silence the tones once the last change is reached; the noise keeps its last volume
+		toneEnd := getTotalPlaybackTime(cfg.FrequencyChanges)
+		configuredVolumeFunc := volumeFunc
+		volumeFunc = func(t float64) float64 {
+			if t >= toneEnd {
+				return 0
+			}
+			return configuredVolumeFunc(t)
+		}
+	}
+	noiseTiltFunc := createNoiseTiltFunc(cfg.FrequencyChanges)
+	noisePanFunc := createNoisePanFunc(cfg.FrequencyChanges)
+
+	// Frequency functions for left and right channels
+	carrierFunc := baseFreqFunc
+	if opts.Jitter > 0 {
+		// Seeded separately from the noise bed; applied to both channels so the beat is unchanged
+		jitter := NewCarrierJitter(opts.Seed+1, opts.Jitter)
+		carrierFunc = func(t float64) float64 {
+			return baseFreqFunc(t) + jitter.At(t)
+		}
+	}
+	if hasFrequencyWander(cfg.FrequencyChanges) {
+		// A unit random walk scaled by the interpolated wander, seeded apart from the jitter
+		wander := NewCarrierJitter(opts.Seed+3, 1)
+		wanderFunc := createFrequencyWanderFunc(cfg.FrequencyChanges)
+		jitteredFunc := carrierFunc
+		carrierFunc = func(t float64) float64 {
+			return jitteredFunc(t) + wanderFunc(t)*wander.At(t)
+		}
+	}
+
+	// Each tone advances its phase by its own frequency at every sample, so the right channel's
+	// instantaneous frequency is always the left's plus the beat, however fast the carrier moves.
+	// A negative frequency would run the tone backwards, so both are kept at or above 0 Hz.
+	// Adding a beat of exactly 0 leaves the carrier unchanged, so with no phase offset the
+	// channels stay sample-identical rather than drifting apart over a long session
+	freqFuncLeft := func(t float64) float64 {
+		return math.Max(0, carrierFunc(t))
+	}
+
+	freqFuncRight := func(t float64) float64 {
+		return math.Max(0, carrierFunc(t)+beatFreqFunc(t))
+	}
+
+	// Starting phase of each tone, in the order the tones are created
+	startPhase := func() float64 { return 0 }
+	if opts.RandomPhase {
+		// Seeded separately from the noise bed and the jitter
+		phaseRand := rand.New(rand.NewSource(opts.Seed + 2))
+		startPhase = func() float64 {
+			return phaseRand.Float64() * 2 * math.Pi
+		}
+	}
+
+	// Scale of the tones and noise, the coefficient of the one-pole smoothing applied to every
+	// frequency and volume, and the length of the tones' attack ramp
+	headroom := dbToLinear(-opts.Headroom)
+	smoothing := smoothingCoefficient(sr, opts.SmoothTime)
+	attack := sr.N(time.Duration(opts.AttackTime * float64(time.Second)))
+
+	// Thicken each tone with a detuned copy, if configured
+	detune := func(vt *VariableTone) beep.Streamer { return vt }
+	if hasDetune(cfg.FrequencyChanges) {
+		detuneFunc := createDetuneFunc(cfg.FrequencyChanges)
+		detune = func(vt *VariableTone) beep.Streamer { return withDetune(vt, detuneFunc) }
+	}
+
+	// Generate the tones for the synthesis mode
+	harmonics := normalizeHarmonics(cfg.Harmonics)
+	var toneStreamers []beep.Streamer
+	switch cfg.Mode {
+	case modeMonaural:
+		// Both tones play in both channels, so the beat forms acoustically before reaching the ears
+		halfVolumeFunc := func(t float64) float64 {
+			return volumeFunc(t) / 2
+		}
+		toneStreamers = append(toneStreamers,
+			detune(&VariableTone{
+				sr:         sr,
+				pos:        0,
+				phase:      startPhase(),
+				harmonics:  harmonics,
+				freqFunc:   freqFuncLeft,
+				volumeFunc: halfVolumeFunc,
+				headroom:   headroom,
+				attack:     attack,
+				smoothing:  smoothing,
+				channel:    2, // Both channels
+			}),
+			detune(&VariableTone{
+				sr:          sr,
+				pos:         0,
+				phase:       startPhase(),
+				phaseOffset: cfg.PhaseOffset * math.Pi / 180,
+				harmonics:   harmonics,
+				freqFunc:    freqFuncRight,
+				volumeFunc:  halfVolumeFunc,
+				headroom:    headroom,
+				attack:      attack,
+				smoothing:   smoothing,
+				channel:     2, // Both channels
+			}),
+		)
+	case modeIsochronic:
+		// A single carrier in both channels, pulsed on and off at the beat frequency
+		carrier := &VariableTone{
+			sr:         sr,
+			pos:        0,
+			phase:      startPhase(),
+			harmonics:  harmonics,
+			freqFunc:   freqFuncLeft,
+			volumeFunc: volumeFunc,
+			headroom:   headroom,
+			attack:     attack,
+			smoothing:  smoothing,
+			channel:    2, // Both channels
+		}
+		toneStreamers = append(toneStreamers, &IsochronicGate{
+			stream:    detune(carrier),
+			rateFunc:  beatFreqFunc,
+			shapeFunc: createBeatShapeFunc(cfg.FrequencyChanges),
+			rampFunc:  createBeatRampFunc(cfg.FrequencyChanges),
+			sr:        sr,
+			pos:       0,
+		})
+	default:
+		// Gate each tone by its left_on or right_on setting. The step to or from silence is
+		// declicked by the control smoothing and the attack ramp
+		leftVolumeFunc, rightVolumeFunc := volumeFunc, volumeFunc
+		if hasToneGates(cfg.FrequencyChanges) {
+			leftGate := createToneGateFunc(cfg.FrequencyChanges, func(c ConfigFrequencyChange) *bool { return c.LeftOn })
+			rightGate := createToneGateFunc(cfg.FrequencyChanges, func(c ConfigFrequencyChange) *bool { return c.RightOn })
+			leftVolumeFunc = func(t float64) float64 { return volumeFunc(t) * leftGate(t) }
+			rightVolumeFunc = func(t float64) float64 { return volumeFunc(t) * rightGate(t) }
+		}
+
+		// Generate variable tones for left and right channels
+		leftTone := &VariableTone{
+			sr:         sr,
+			pos:        0,
+			phase:      startPhase(),
+			harmonics:  harmonics,
+			freqFunc:   freqFuncLeft,
+			volumeFunc: leftVolumeFunc,
+			headroom:   headroom,
+			attack:     attack,
+			smoothing:  smoothing,
+			channel:    0, // Left channel
+		}
+
+		rightTone := &VariableTone{
+			sr:          sr,
+			pos:         0,
+			phase:       startPhase(),
+			phaseOffset: cfg.PhaseOffset * math.Pi / 180,
+			harmonics:   harmonics,
+			freqFunc:    freqFuncRight,
+			volumeFunc:  rightVolumeFunc,
+			headroom:    headroom,
+			attack:      attack,
+			smoothing:   smoothing,
+			channel:     1, // Right channel
+		}
+
+		left, right := detune(leftTone), detune(rightTone)
+		if cfg.Mode == modeDual {
+			// Pulse both tones together at the second beat frequency, on top of the binaural beat
+			beatFreq2Func := createBeatFreq2Func(cfg.FrequencyChanges)
+			shapeFunc := createBeatShapeFunc(cfg.FrequencyChanges)
+			rampFunc := createBeatRampFunc(cfg.FrequencyChanges)
+			left = &IsochronicGate{stream: left, rateFunc: beatFreq2Func, shapeFunc: shapeFunc, rampFunc: rampFunc, sr: sr, pos: 0}
+			right = &IsochronicGate{stream: right, rateFunc: beatFreq2Func, shapeFunc: shapeFunc, rampFunc: rampFunc, sr: sr, pos: 0}
+		}
+
+		toneStreamers = append(toneStreamers, left, right)
+	}
+
+	// Generate pink noise, or loop the noise file instead
+	var pinkNoise interface {
+		beep.Streamer
+		Reseed(seed int64)
+		SetAmplitude(amplitude float64)
+		Warmup(samples int)
+	} = NewPinkNoise(opts.Seed)
+	if cfg.StereoNoise {
+		pinkNoise = NewStereoPinkNoise(opts.Seed)
+	}
+	pinkNoise.Warmup(opts.NoiseWarmup)
+	if opts.NoiseAmplitude > 0 {
+		pinkNoise.SetAmplitude(opts.NoiseAmplitude)
+	}
+	var noiseSource beep.Streamer = pinkNoise
+	var closers []io.Closer
+	if cfg.NoiseFile != "" {
+		noiseFile, closer, err := loadNoiseFile(cfg.NoiseFile, sr, opts.ResampleQuality)
+		if err != nil {
+			return nil, fmt.Errorf("error loading noise file: %v", err)
+		}
+		noiseSource = noiseFile
+		closers = append(closers, closer)
+	}
+
+	// Tilt the pink noise spectrum based on time
+	noiseTilt := &NoiseTiltFilter{
+		stream:   noiseSource,
+		tiltFunc: noiseTiltFunc,
+		sr:       sr,
+		pos:      0,
+	}
+
+	// Control pink noise based on time
+	pinkNoiseControl := &PinkNoiseControl{
+		stream:     noiseTilt,
+		volumeFunc: pinkNoiseFunc,
+		headroom:   headroom,
+		smoothing:  smoothing,
+		panFunc:    noisePanFunc,
+		sr:         sr,
+		pos:        0,
+	}
+	noiseLayers, err := newNoiseLayers(cfg, sr, opts.Seed, opts.NoiseWarmup, pinkNoiseFunc, noisePanFunc, headroom, smoothing)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SeedPerSegment && cfg.NoiseFile == "" {
+		for _, change := range cfg.FrequencyChanges[1:] {
+			pinkNoiseControl.boundaries = append(pinkNoiseControl.boundaries, sr.N(time.Duration(change.Time*float64(time.Second))))
+		}
+		pinkNoiseControl.reseed = func(index int) {
+			pinkNoise.Reseed(segmentSeed(opts.Seed, index))
+		}
+	}
+
+	// Mix the sine waves
+	// Keep only the tone for a tone stem, and no tones for the noise stem
+	switch opts.Stem {
+	case stemLeftTone:
+		toneStreamers = toneStreamers[:1]
+	case stemRightTone:
+		toneStreamers = toneStreamers[1:]
+	case stemNoise:
+		toneStreamers = nil
+	}
 
-	// Create frequency functions based on configuration
-	baseFreqFunc := createFreqFunc(cfg.FrequencyChanges)
-	beatFreqFunc := createBeatFreqFunc(cfg.FrequencyChanges)
-	volumeFunc := createVolumeFunc(cfg.FrequencyChanges)
-	pinkNoiseFunc := createPinkNoiseFunc(cfg.FrequencyChanges)
+	toneMix := &beep.Mixer{}
+	toneMix.Add(toneStreamers...)
+
+	// Sweep the tones across the stereo field, if configured
+	var tones beep.Streamer = toneMix
+	if hasAutopan(cfg.FrequencyChanges) {
+		tones = &AutoPan{
+			stream:    tones,
+			rateFunc:  createAutopanRateFunc(cfg.FrequencyChanges),
+			depthFunc: createAutopanDepthFunc(cfg.FrequencyChanges),
+			sr:        sr,
+			pos:       0,
+		}
+	}
 
-	// Frequency functions for left and right channels
-	freqFuncLeft := func(t float64) float64 {
-		return baseFreqFunc(t)
+	// Offset the tones when both channels carry the same tone; binaural tones stay split
+	if opts.TonePan != 0 && !isBinaural(cfg) {
+		tones = &StaticPan{stream: tones, pan: opts.TonePan}
 	}
 
-	freqFuncRight := func(t float64) float64 {
-		return baseFreqFunc(t) + beatFreqFunc(t)
+	// Mix the tones and pink noise
+	mixed := &beep.Mixer{}
+	mixed.Add(tones)
+	if opts.Stem == "" || opts.Stem == stemNoise {
+		mixed.Add(pinkNoiseControl)
+		mixed.Add(noiseLayers...)
 	}
 
-	// Generate variable tones for left and right channels
-	leftTone := &VariableTone{
-		sr:         sr,
-		pos:        0,
-		phase:      0,
-		freqFunc:   freqFuncLeft,
-		volumeFunc: volumeFunc,
-		channel:    0, // Left channel
+	// Apply optional fades to the whole mix
+	var output beep.Streamer = mixed
+	if opts.AutoFadeLast {
+		output = &FadeControl{
+			stream:   output,
+			gainFunc: createLastSegmentFadeFunc(cfg.FrequencyChanges, opts.FadeCurve),
+			sr:       sr,
+			pos:      0,
+		}
 	}
 
-	rightTone := &VariableTone{
-		sr:         sr,
-		pos:        0,
-		phase:      0,
-		freqFunc:   freqFuncRight,
-		volumeFunc: volumeFunc,
-		channel:    1, // Right channel
+	// Apply the master envelope, if any
+	if len(cfg.MasterEnvelope) > 0 {
+		output = &FadeControl{
+			stream:   output,
+			gainFunc: createMasterEnvelopeFunc(cfg.MasterEnvelope),
+			sr:       sr,
+			pos:      0,
+		}
 	}
 
-	// Generate pink noise
-	pinkNoise := NewPinkNoise()
+	// Apply the normalization gain, if any
+	if opts.Gain != 0 && opts.Gain != 1 {
+		output = &FadeControl{
+			stream:   output,
+			gainFunc: func(t float64) float64 { return opts.Gain },
+			sr:       sr,
+			pos:      0,
+		}
+	}
 
-	// Control pink noise based on time
-	pinkNoiseControl := &PinkNoiseControl{
-		stream:     pinkNoise,
-		volumeFunc: pinkNoiseFunc,
-		sr:         sr,
-		pos:        0,
+	// Add the dither floor after the gains so it stays at its level. Stems are left without it
+	// so they still sum to the tones and noise.
+	if opts.NoiseFloor != 0 && opts.Stem == "" {
+		output = beep.Mix(output, NewDitherFloor(opts.Seed+4, dbToLinear(opts.NoiseFloor)))
 	}
 
-	// Mix the sine waves and pink noise
-	mixed := &beep.Mixer{}
-	mixed.Add(
-		leftTone,
-		rightTone,
-		pinkNoiseControl,
-	)
+	// Keep only the isolated channel, if any
+	switch opts.Isolate {
+	case isolateLeft:
+		output = &ChannelMask{stream: output, channel: 1}
+	case isolateRight:
+		output = &ChannelMask{stream: output, channel: 0}
+	}
 
-	// Limit playback to the total playback time
+	// Limit playback to the total playback time, including any noise tail
+	totalPlaybackTime := getTotalPlaybackTime(cfg.FrequencyChanges) + math.Max(opts.NoiseTail, 0)
 	totalSamples := sr.N(time.Duration(totalPlaybackTime * float64(time.Second)))
-	mixedStreamer := beep.Take(totalSamples, mixed)
 
-	// Handle output: either play or export to WAV
-	if *outputPath == "" {
-		// Initialize the speaker
-		speaker.Init(sr, sr.N(time.Second/10))
+	var streamer beep.Streamer = beep.Take(totalSamples, output)
+	if opts.OnSegmentChange != nil {
+		streamer = &SegmentMonitor{stream: streamer, sr: sr, changes: cfg.FrequencyChanges, onChange: opts.OnSegmentChange}
+	}
+
+	return &Session{
+		streamer:          streamer,
+		totalSamples:      totalSamples,
+		totalPlaybackTime: totalPlaybackTime,
+		baseFreqFunc:      baseFreqFunc,
+		beatFreqFunc:      beatFreqFunc,
+		leftFreqFunc:      freqFuncLeft,
+		rightFreqFunc:     freqFuncRight,
+		volumeFunc:        volumeFunc,
+		pinkNoiseFunc:     pinkNoiseFunc,
+		labelFunc:         createLabelFunc(cfg.FrequencyChanges),
+		closers:           closers,
+	}, nil
+}
+
+func main() {
+	// Command-line flags
+	configPath := flag.String("config", "config.yaml", "Path to the configuration file")
+	configB64 := flag.String("config-b64", "", "Base64-encoded YAML or JSON configuration to use instead of the -config file")
+	outputPath := flag.String("output", "", "Comma-separated paths of the output files (if empty, audio will be played)")
+	scriptPath := flag.String("script", "", "Generate the session from a session script file instead of -config, or - to read it from stdin")
+	sweep := flag.String("sweep", "", "Generate the session from bands and durations instead of -config, e.g. \"delta:5m,theta:5m,alpha:5m,beta:5m\"")
+	holdLast := flag.Float64("hold-last", 0, "Seconds to keep playing the last change's values after its time (0 disables)")
+	maxBeatSlope := flag.Float64("max-beat-slope", 0, "Lengthen transitions so the beat frequency changes by at most this many Hz per second, delaying later changes (0 disables)")
+	stretchFactor := flag.Float64("stretch", 1.0, "Stretch factor for playback time (default 1.0)")
+	targetDuration := flag.Float64("target-duration", 0, "Stretch the session to last this many seconds, overriding -stretch (0 disables)")
+	introPath := flag.String("intro", "", "Path to a WAV, MP3 or Ogg Vorbis file played before the session (optional)")
+	resampleQuality := flag.Int("resample-quality", 4, "Quality of resampling intro and noise files to the session sample rate, and outputs to their own (1 to 64)")
+	autoFadeLast := flag.Bool("autofade-last", false, "Fade out across the final segment of the configuration")
+	fadeCurve := flag.String("fade-curve", fadeCurveLinear, "Gain curve of the -autofade-last fade: linear or exponential")
+	normalizeTime := flag.Bool("normalize-time", false, "Shift all times so the first frequency change is at 0")
+	leadInSilence := flag.Bool("lead-in-silence", false, "Play silence until the first frequency change instead of holding its settings from 0")
+	trimSilentSegments := flag.Bool("trim-silence", false, "Skip leading and trailing segments where the tone and pink noise are both silent")
+	seed := flag.Int64("seed", 0, "Seed for the pink noise generator (0 uses the current time)")
+	seedPerSegment := flag.Bool("seed-per-segment", false, "Re-seed the pink noise at each segment boundary, derived from -seed")
+	jitter := flag.Float64("jitter", 0, "Maximum slow random carrier offset in Hz, applied to both channels (0 disables)")
+	noiseTail := flag.Float64("noise-tail", 0, "Seconds to keep playing pink noise after the tones end")
+	toneBelowNoise := flag.Float64("tone-below-noise", 0, "While the pink noise is on, set the tone volume this many dB below the pink noise volume (0 disables)")
+	tonePan := flag.Float64("tone-pan", 0, "Static pan of the tones from -1 (left) to 1 (right) in monaural and isochronic modes")
+	noiseAmplitude := flag.Float64("noise-amplitude", pinkNoiseAmplitude, fmt.Sprintf("Pink noise generator amplitude; %g lets pink_noise_volume 1.0 reach full scale", pinkNoiseFullScaleAmplitude))
+	normalizePeak := flag.Float64("normalize", 0, "Scale the session so its peak reaches this level in dBFS, e.g. -1 (0 disables)")
+	isolate := flag.String("isolate", isolateNone, "Output only one channel to check headphone wiring: left, right or none")
+	beatReport := flag.Bool("beat-report", false, "Print the range of the beat between the tones after interpolation, flag where it differs from the configured beat, and exit")
+	previewFrequencies := flag.Bool("preview-frequencies", false, "Draw the base and beat frequency over time in the terminal and exit")
+	dumpPath := flag.String("dump-samples", "", "Write the session as raw interleaved 32-bit float stereo samples to this path instead of playing it")
+	initConfig := flag.Bool("init", false, "Write a commented starter configuration to the -config path and exit")
+	force := flag.Bool("force", false, "Allow -init to overwrite an existing file, and exports larger than -max-file-size")
+	maxFileSizeFlag := flag.String("max-file-size", "", "Refuse to export files estimated to be larger than this, in bytes or with a KB, MB or GB unit (optional)")
+	formatConfigFlag := flag.Bool("fmt", false, "Rewrite the -config file in canonical form and exit")
+	randomPhase := flag.Bool("random-phase", false, "Start each tone at a random phase derived from -seed")
+	truePeak := flag.Bool("true-peak", false, "Measure the 4x oversampled true peak of the export and print it alongside the sample peak")
+	telemetryPath := flag.String("telemetry", "", "Write the session's frequencies and volumes once per second to this CSV file (optional)")
+	reportPath := flag.String("report", "", "Write a JSON report of the exported session's parameters and levels to this path (optional)")
+	loopable := flag.Bool("loopable", false, "Export a seamless loop whose end crossfades into its start")
+	floatWAV := flag.Bool("float", false, "Export 32-bit IEEE float WAV files instead of 16-bit PCM")
+	downmixBeat := flag.Bool("downmix-beat", false, "Export a mono WAV with the left and right tones summed, so the beat is audible on speakers")
+	stemsDir := flag.String("stems", "", "Directory to write a WAV file for each tone and the noise, rendered separately (optional)")
+	verify := flag.Bool("verify", false, "Re-read each exported WAV file and check it matches the synthesis")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the synthesis, playback or export to this path (optional)")
+	memProfile := flag.String("memprofile", "", "Write a heap profile to this path when the session ends (optional)")
+	apiAddr := flag.String("api", "", "Address to serve the render API on, e.g. :8080 (optional)")
+	selfTest := flag.Bool("selftest", false, "Check that the pink noise generator has a pink spectrum and exit")
+	calibrate := flag.Bool("calibrate", false, "Play reference tones at a known level to set the headphone volume, ignoring -config, and exit")
+	listDevices := flag.Bool("list-devices", false, "List the output devices that can be selected with -device and exit")
+	device := flag.String("device", defaultDevice, "Output device to play to; only the system default is supported")
+	bufferMS := flag.Int("buffer-ms", 100, "Speaker buffer size in milliseconds; smaller buffers respond faster but may underrun")
+	watch := flag.Bool("watch", false, "Restart playback whenever the -config file changes")
+	headroomDB := flag.Float64("headroom", defaultHeadroomDB, "dB the tones and noise are scaled below full scale at volume 1.0; lower values are louder but may clip")
+	attackMS := flag.Float64("attack-ms", defaultAttackMS, "Milliseconds of the ramp applied whenever a tone's volume rises from zero (0 disables)")
+	noiseWarmup := flag.Int("noise-warmup", defaultNoiseWarmup, "Samples to advance the pink noise generator before the session starts, so its spectrum is settled from the first sample (0 disables)")
+	smoothMS := flag.Float64("smooth-ms", defaultSmoothMS, "Time constant in milliseconds of the smoothing applied to every frequency and volume change (0 disables)")
+	noiseFloor := flag.Float64("noise-floor", 0, "Add a dither floor peaking at this level in dBFS, e.g. -90, so the output is never digitally silent (0 disables)")
+	strict := flag.Bool("strict", false, "Reject configuration files with unknown fields, such as misspelled field names")
+	abMatch := flag.Bool("ab", false, "Render the two configuration files given as arguments to the two -output paths at the same RMS loudness, for blind comparison")
+	abRMS := flag.Float64("ab-rms", 0, "RMS level in dBFS that -ab matches both renders to (0 matches the quieter one)")
+	compare := flag.Bool("compare", false, "Render the two configuration files given as arguments with the same seed and report how they differ")
+	logFormat := flag.String("log-format", logFormatText, "Format of the diagnostic output: text, or json for one JSON object with a level and message per line on stderr")
+	quiet := flag.Bool("quiet", false, "Print only errors, without progress, the playback status or warnings")
+	verbose := flag.Bool("verbose", false, "Print extra detail, such as the resolved configuration and export progress")
+	flag.Parse()
+
+	switch *logFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		fatalf("Invalid -log-format value %q: expected text or json", *logFormat)
+	}
+	setLogFormat(*logFormat, os.Stderr)
+	if *quiet && *verbose {
+		fatalf("-quiet and -verbose cannot be used together")
+	}
+	switch {
+	case *quiet:
+		logLevel = logLevelQuiet
+	case *verbose:
+		logLevel = logLevelVerbose
+	}
+
+	switch *fadeCurve {
+	case fadeCurveLinear, fadeCurveExponential:
+	default:
+		fatalf("Invalid -fade-curve value %q: expected linear or exponential", *fadeCurve)
+	}
+	switch *isolate {
+	case isolateNone, isolateLeft, isolateRight:
+	default:
+		fatalf("Invalid -isolate value %q: expected left, right or none", *isolate)
+	}
+	if *normalizePeak > 0 {
+		fatalf("Invalid -normalize value %.2f: the peak level must be below 0 dBFS", *normalizePeak)
+	}
+	if *bufferMS < minSpeakerBufferMS || *bufferMS > maxSpeakerBufferMS {
+		fatalf("Invalid -buffer-ms value %d: expected %d to %d", *bufferMS, minSpeakerBufferMS, maxSpeakerBufferMS)
+	}
+	if *headroomDB < 0 {
+		fatalf("Invalid -headroom value %.2f: it must not be negative", *headroomDB)
+	}
+	if *holdLast < 0 {
+		fatalf("Invalid -hold-last value %.2f: it must not be negative", *holdLast)
+	}
+	if *targetDuration < 0 {
+		fatalf("Invalid -target-duration value %.2f: it must not be negative", *targetDuration)
+	}
+	if *targetDuration > 0 && *stretchFactor != 1 {
+		warnf("Warning: -target-duration overrides -stretch %.2f.", *stretchFactor)
+	}
+	if *maxBeatSlope < 0 {
+		fatalf("Invalid -max-beat-slope value %.2f: it must not be negative", *maxBeatSlope)
+	}
+	if *attackMS < 0 {
+		fatalf("Invalid -attack-ms value %.2f: it must not be negative", *attackMS)
+	}
+	if *noiseWarmup < 0 {
+		fatalf("Invalid -noise-warmup value %d: it must not be negative", *noiseWarmup)
+	}
+	if *smoothMS < 0 {
+		fatalf("Invalid -smooth-ms value %.2f: it must not be negative", *smoothMS)
+	}
+	if *noiseFloor > 0 {
+		fatalf("Invalid -noise-floor value %.2f: the level must be below 0 dBFS", *noiseFloor)
+	}
+	if *noiseAmplitude <= 0 {
+		fatalf("Invalid -noise-amplitude value %g: it must be above 0", *noiseAmplitude)
+	}
+	if *tonePan < -1 || *tonePan > 1 {
+		fatalf("Invalid -tone-pan value %.2f: expected -1 to 1", *tonePan)
+	}
+	if *downmixBeat && *outputPath == "" {
+		fatalf("-downmix-beat requires -output, since playback stays in stereo")
+	}
+	var maxFileSize int64
+	if *maxFileSizeFlag != "" {
+		var err error
+		maxFileSize, err = parseFileSize(*maxFileSizeFlag)
+		if err != nil {
+			fatalf("Invalid -max-file-size value %q: %v", *maxFileSizeFlag, err)
+		}
+	}
+	if *loopable {
+		switch {
+		case *outputPath == "":
+			fatalf("-loopable requires -output, since it shapes the exported file")
+		case *introPath != "":
+			fatalf("-loopable can't be combined with -intro, which would play on every loop")
+		case *autoFadeLast || *noiseTail > 0:
+			fatalf("-loopable can't be combined with -autofade-last or -noise-tail, which end the session instead of looping it")
+		case *verify:
+			fatalf("-verify checks the session as synthesized and can't be combined with -loopable")
+		}
+	}
+	if *floatWAV && *outputPath == "" {
+		fatalf("-float requires -output, since it sets the format of the exported files")
+	}
+	if *floatWAV && *verify {
+		fatalf("-verify can't read float WAV files yet and can't be combined with -float")
+	}
+	if *calibrate && *outputPath != "" {
+		fatalf("-calibrate plays to the speaker and can't be combined with -output")
+	}
+	if *truePeak && *outputPath == "" {
+		fatalf("-true-peak requires -output, since it measures the export")
+	}
+	if *reportPath != "" && *outputPath == "" {
+		fatalf("-report requires -output, since the levels are measured while exporting")
+	}
+	if *normalizeTime && *leadInSilence {
+		fatalf("-normalize-time and -lead-in-silence cannot be used together")
+	}
+	if *abMatch && (*normalizePeak != 0 || *introPath != "") {
+		fatalf("-ab sets the gain of each render itself and can't be combined with -normalize or -intro")
+	}
+	if *abRMS > 0 {
+		fatalf("Invalid -ab-rms value %.2f: the level must be below 0 dBFS", *abRMS)
+	}
+	if *scriptPath != "" && *sweep != "" {
+		fatalf("-script and -sweep cannot be used together")
+	}
+	if *configB64 != "" {
+		configSet := false
+		flag.Visit(func(f *flag.Flag) {
+			configSet = configSet || f.Name == "config"
+		})
+		switch {
+		case configSet:
+			fatalf("-config and -config-b64 cannot be used together")
+		case *scriptPath != "" || *sweep != "":
+			fatalf("-config-b64 can't be combined with -script or -sweep, which generate the session instead")
+		case *watch || *initConfig || *formatConfigFlag:
+			fatalf("-watch, -init and -fmt work on a -config file and can't be combined with -config-b64")
+		case *compare || *abMatch:
+			fatalf("-compare and -ab read the config files given after the flags and can't be combined with -config-b64")
+		}
+	}
+	if *watch && (*sweep != "" || *scriptPath != "" || *outputPath != "" || *stemsDir != "" || *dumpPath != "") {
+		fatalf("-watch only applies to playing a -config file")
+	}
+
+	// Check the pink noise spectrum
+	if *selfTest {
+		slope, err := runSelfTest(44100)
+		if err != nil {
+			fatalf("Self-test failed: %v", err)
+		}
+		infof("Self-test passed: pink noise slope is %.2f dB/octave.\n", slope)
+		return
+	}
+
+	// List the output devices
+	if *listDevices {
+		devices, err := backend.Devices()
+		if err != nil {
+			fatalf("Error listing output devices: %v", err)
+		}
+		for _, d := range devices {
+			if d == defaultDevice {
+				infof("%s (the system's default output)\n", d)
+			} else {
+				infof("%s\n", d)
+			}
+		}
+		return
+	}
+
+	// Write a starter configuration
+	if *initConfig {
+		if err := writeStarterConfig(*configPath, *force); err != nil {
+			fatalf("Error writing starter configuration: %v", err)
+		}
+		infof("Wrote starter configuration to %s.\n", *configPath)
+		return
+	}
+
+	// Rewrite the configuration in canonical form
+	if *formatConfigFlag {
+		if err := formatConfigFile(*configPath); err != nil {
+			fatalf("Error formatting configuration file: %v", err)
+		}
+		infof("Formatted %s.\n", *configPath)
+		return
+	}
+
+	// Serve the render API instead of playing a configuration
+	if *apiAddr != "" {
+		fatalf("%v", runAPI(*apiAddr))
+	}
+
+	// loadConfig parses and prepares the configuration file at path, or generates the session
+	// from a script or a sweep through the bands. With -watch it is called again each time the
+	// file changes.
+	loadConfig := func(path string) (*Config, error) {
+		var cfg *Config
+		var err error
+		if *scriptPath != "" {
+			script, err := readScript(*scriptPath)
+			if err != nil {
+				return nil, fmt.Errorf("reading script: %w", err)
+			}
+			cfg, err = parseScript(script)
+			if err != nil {
+				return nil, fmt.Errorf("parsing script: %w", err)
+			}
+		} else if *sweep != "" {
+			cfg, err = parseSweep(*sweep)
+			if err != nil {
+				return nil, fmt.Errorf("generating sweep: %w", err)
+			}
+		} else if *configB64 != "" {
+			cfg, err = parseConfigB64(*configB64, *strict)
+			if err != nil {
+				return nil, fmt.Errorf("parsing -config-b64: %w", err)
+			}
+		} else {
+			cfg, err = parseConfig(path, *strict)
+			if err != nil {
+				return nil, fmt.Errorf("parsing configuration file: %w", err)
+			}
+		}
+
+		stretch := *stretchFactor
+		if *targetDuration > 0 {
+			original := getTotalPlaybackTime(cfg.FrequencyChanges)
+			if original <= 0 {
+				return nil, errors.New("-target-duration needs a session longer than 0 s")
+			}
+			stretch = *targetDuration / original
+			infof("Stretching the session from %.2f s to %.2f s (factor %.4f).\n", original, *targetDuration, stretch)
+		}
+		for i := range cfg.FrequencyChanges {
+			cfg.FrequencyChanges[i].Time *= stretch
+		}
+
+		if len(cfg.FrequencyChanges) > 0 && cfg.FrequencyChanges[0].Time > 0 {
+			switch {
+			case *normalizeTime:
+				var shift float64
+				cfg.FrequencyChanges, shift = normalizeTimes(cfg.FrequencyChanges)
+				infof("Shifted all times %.2f s earlier so the session starts at the first change.\n", shift)
+			case *leadInSilence:
+				cfg.FrequencyChanges = insertLeadInSilence(cfg.FrequencyChanges)
+			default:
+				warnf("Note: the first change is at %.2f s; its settings play from the start. Use -normalize-time or -lead-in-silence to change this.", cfg.FrequencyChanges[0].Time)
+			}
+		}
+
+		if *trimSilentSegments {
+			trimmed, leading, trailing, err := trimSilence(cfg.FrequencyChanges)
+			if err != nil {
+				return nil, fmt.Errorf("trimming silence: %w", err)
+			}
+			cfg.FrequencyChanges = trimmed
+			infof("Trimmed %.2f s of leading and %.2f s of trailing silence.\n", leading, trailing)
+		}
+
+		if *maxBeatSlope > 0 {
+			limited, stretched, added := limitBeatSlope(cfg.FrequencyChanges, *maxBeatSlope)
+			if stretched > 0 {
+				cfg.FrequencyChanges = limited
+				infof("Lengthened %d beat transitions by %.2f s in total to keep the beat within %.2f Hz/s.\n", stretched, added, *maxBeatSlope)
+			}
+		}
+		cfg.FrequencyChanges = holdLastChange(cfg.FrequencyChanges, *holdLast)
+
+		// Check the total playback time
+		if getTotalPlaybackTime(cfg.FrequencyChanges) == 0 {
+			return nil, errors.New("total playback time is zero; check your configuration")
+		}
+		return cfg, nil
+	}
+
+	// Sample rate
+	sr := beep.SampleRate(44100)
 
-		// Create a channel to signal when playback is done
+	// Play the calibration tones instead of a session
+	if *calibrate {
+		if err := initSpeaker(outputDevice(*device), sr, speakerBufferSize(sr, *bufferMS)); err != nil {
+			fatalf("No audio device available (%v)", err)
+		}
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+
+		infof("Set your volume so the reference tone is clearly audible but comfortable.\n")
 		done := make(chan struct{})
+		backend.Play(beep.Seq(
+			newCalibrationStreamer(sr, calibrationSequence, func(seg calibrationSegment) {
+				infof("Calibration: %s\n", seg.label)
+			}),
+			beep.Callback(func() { close(done) }),
+		))
+		select {
+		case <-done:
+		case <-interrupts:
+			backend.Clear()
+		}
+		return
+	}
+
+	// Build the synthesis pipeline
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	sessionOpts := SessionOptions{
+		Seed:            *seed,
+		SeedPerSegment:  *seedPerSegment,
+		Jitter:          *jitter,
+		NoiseTail:       *noiseTail,
+		ResampleQuality: *resampleQuality,
+		Isolate:         *isolate,
+		AutoFadeLast:    *autoFadeLast,
+		FadeCurve:       *fadeCurve,
+		RandomPhase:     *randomPhase,
+		ToneBelowNoise:  *toneBelowNoise,
+		TonePan:         *tonePan,
+		NoiseAmplitude:  *noiseAmplitude,
+		NoiseFloor:      *noiseFloor,
+		SmoothTime:      *smoothMS / 1000,
+		Headroom:        *headroomDB,
+		AttackTime:      *attackMS / 1000,
+		NoiseWarmup:     *noiseWarmup,
+	}
+
+	// buildStream builds the session for cfg and prepends the intro, which the session closes
+	// along with its own files. With -watch it is called again for each reloaded configuration.
+	buildStream := func(cfg *Config) (*Session, beep.Streamer, float64, error) {
+		if *normalizePeak != 0 {
+			infof("Measuring the session peak for normalization...\n")
+			peak, _, err := measureSessionLevels(cfg, sr, sessionOpts)
+			if err != nil {
+				return nil, nil, 0, fmt.Errorf("measuring session peak: %w", err)
+			}
+			sessionOpts.Gain = normalizationGain(peak, *normalizePeak)
+			infof("Peak %.2f dBFS, applying %.2f dB of gain.\n", linearToDB(peak), linearToDB(sessionOpts.Gain))
+		}
+		session, err := newSession(cfg, sr, sessionOpts)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("building session: %w", err)
+		}
 
-		// Record the start time
-		startTime := time.Now()
+		if *introPath == "" {
+			return session, session.streamer, 0, nil
+		}
+		stream, introDuration, intro, err := prependIntro(session.streamer, *introPath, sr, *resampleQuality)
+		if err != nil {
+			session.Close()
+			return nil, nil, 0, fmt.Errorf("loading intro file: %w", err)
+		}
+		session.closers = append(session.closers, intro)
+		return session, stream, introDuration, nil
+	}
 
-		// Play the audio
-		speaker.Play(beep.Seq(mixedStreamer, beep.Callback(func() {
-			close(done)
-		})))
+	// Render both configurations and report how they differ
+	if *compare {
+		if flag.NArg() != 2 {
+			fatalf("-compare needs two configuration files, e.g. -compare a.yaml b.yaml")
+		}
+		var streams [2]beep.Streamer
+		for i, path := range flag.Args() {
+			cfg, err := loadConfig(path)
+			if err != nil {
+				fatalf("Error loading %s: %v", path, err)
+			}
+			session, stream, _, err := buildStream(cfg)
+			if err != nil {
+				fatalf("Error %v", err)
+			}
+			defer session.Close()
+			streams[i] = stream
+		}
+		diff, err := compareStreams(streams[0], streams[1])
+		if err != nil {
+			fatalf("Error comparing sessions: %v", err)
+		}
+		writeComparison(os.Stdout, flag.Arg(0), flag.Arg(1), diff, sr)
+		return
+	}
 
-		// Create a ticker to output status every 3 seconds
-		ticker := time.NewTicker(3 * time.Second)
+	// Render both configurations at matched loudness for an A/B comparison
+	if *abMatch {
+		if flag.NArg() != 2 {
+			fatalf("-ab needs two configuration files, e.g. -ab -output a.wav,b.wav a.yaml b.yaml")
+		}
+		targets, err := parseOutputTargets(*outputPath)
+		if err != nil || len(targets) != 2 {
+			fatalf("-ab needs two -output paths, one for each configuration")
+		}
 
-		go func() {
-			for {
-				select {
-				case <-ticker.C:
-					t := time.Since(startTime).Seconds()
-					if t > totalPlaybackTime {
-						return
-					}
-					freq := baseFreqFunc(t)
-					beatFreq := beatFreqFunc(t)
-					toneVol := volumeFunc(t)
-					pinkVol := pinkNoiseFunc(t)
-					fmt.Printf("Time: %.2f s, Base Frequency: %.2f Hz, Beat Frequency: %.2f Hz, Tone Volume: %.2f, Pink Noise Volume: %.2f\n",
-						t, freq, beatFreq, toneVol, pinkVol)
-				case <-done:
-					ticker.Stop()
-					return
-				}
+		var cfgs [2]*Config
+		var peaks, levels [2]float64
+		for i, path := range flag.Args() {
+			cfgs[i], err = loadConfig(path)
+			if err != nil {
+				fatalf("Error loading %s: %v", path, err)
 			}
-		}()
+			infof("Measuring the level of %s...\n", path)
+			peaks[i], levels[i], err = measureSessionLevels(cfgs[i], sr, sessionOpts)
+			if err != nil {
+				fatalf("Error measuring %s: %v", path, err)
+			}
+		}
 
-		// Wait until playback is finished
-		<-done
-	} else {
-		// Export to WAV file
-		fmt.Printf("Exporting audio to %s...\n", *outputPath)
+		gains, target := matchedGains(levels, *abRMS)
+		format := beep.Format{
+			SampleRate:  sr,
+			NumChannels: 2,
+			Precision:   2, // 16-bit audio
+		}
+		if *floatWAV {
+			format.Precision = wavFloatPrecision
+		}
+		for i, path := range flag.Args() {
+			infof("%s: RMS %.2f dBFS, applying %.2f dB of gain to match %.2f dBFS.\n", path, linearToDB(levels[i]), linearToDB(gains[i]), linearToDB(target))
+			if peaks[i]*gains[i] > 1 {
+				warnf("Warning: %s peaks at %.2f dBFS after the gain and will clip; lower -ab-rms.", path, linearToDB(peaks[i]*gains[i]))
+			}
+			opts := sessionOpts
+			opts.Gain = gains[i]
+			session, err := newSession(cfgs[i], sr, opts)
+			if err != nil {
+				fatalf("Error building session: %v", err)
+			}
+			err = exportSession(session.streamer, format, cfgs[i].metadata(), targets[i:i+1], *resampleQuality)
+			session.Close()
+			if err != nil {
+				fatalf("Error exporting audio: %v", err)
+			}
+		}
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatalf("Error loading configuration: %v", err)
+	}
+	if logLevel >= logLevelVerbose {
+		// Show the configuration after stretching, tone sets and schedules, as -fmt writes it
+		if data, err := encodeConfig(cfg); err == nil {
+			debugf("Resolved configuration:\n%s", data)
+		}
+	}
+	if len(cfg.Channels) > 0 && *outputPath != "" {
+		switch {
+		case *loopable || *introPath != "" || *downmixBeat:
+			fatalf("channels can't be combined with -loopable, -intro or -downmix-beat, which work on the stereo mix")
+		case *verify || *reportPath != "" || *truePeak:
+			fatalf("-verify, -report and -true-peak check the stereo mix and can't be combined with channels")
+		}
+	}
+
+	if *previewFrequencies {
+		fmt.Print(renderFrequencyPreview(cfg.FrequencyChanges, terminalWidth()))
+		return
+	}
+
+	if err := checkNyquist(cfg, sr); err != nil {
+		fatalf("Configuration would alias: %v", err)
+	}
+
+	for _, fc := range cfg.FrequencyChanges {
+		if fc.MixFile != "" {
+			warnf("Warning: mix_file %q is not rendered yet and will be ignored.", fc.MixFile)
+			break
+		}
+	}
+
+	if *tonePan != 0 && isBinaural(cfg) {
+		warnf("Warning: -tone-pan is ignored in binaural mode, where each tone must stay in its own ear.")
+	}
+
+	if hasToneGates(cfg.FrequencyChanges) && !isBinaural(cfg) {
+		warnf("Warning: left_on and right_on are ignored in %s mode, where both tones play in both ears.", cfg.Mode)
+	}
+
+	if hasAutopan(cfg.FrequencyChanges) && isBinaural(cfg) {
+		warnf("Warning: autopan moves the tones between the ears, which weakens the binaural beat.")
+	}
+
+	if len(cfg.FrequencyChanges) > 0 && !hasBeat(cfg.FrequencyChanges) && isBinaural(cfg) {
+		warnf("Note: the beat frequency is 0 Hz throughout, so both ears hear the same pure tone and there is no binaural effect.")
+	}
+
+	// Profile everything from synthesis onwards
+	stopProfiling, err := startProfiling(*cpuProfile, *memProfile)
+	if err != nil {
+		fatalf("Error starting profiling: %v", err)
+	}
+	defer atExit(stopProfiling)()
+
+	session, mixedStreamer, introDuration, err := buildStream(cfg)
+	if err != nil {
+		fatalf("Error %v", err)
+	}
+	defer atExit(func() { session.Close() })()
+
+	// Report the beat between the tones instead of playing
+	if *beatReport {
+		if cfg.Mode == modeIsochronic {
+			fatalf("-beat-report compares the tones in each ear, but isochronic mode plays a single tone")
+		}
+		writeBeatReport(os.Stdout, session)
+		return
+	}
+
+	if *telemetryPath != "" {
+		if err := writeTelemetry(*telemetryPath, session); err != nil {
+			fatalf("Error writing telemetry: %v", err)
+		}
+		infof("Wrote telemetry to %s.\n", *telemetryPath)
+	}
+
+	// Dump the unquantized samples for analysis
+	if *dumpPath != "" {
+		frames, err := dumpSamples(*dumpPath, mixedStreamer)
+		if err != nil {
+			fatalf("Error dumping samples: %v", err)
+		}
+		infof("Wrote %d frames of 32-bit float samples to %s.\n", frames, *dumpPath)
+		return
+	}
+
+	// Render each component of the mix to its own file
+	if *stemsDir != "" {
+		infof("Exporting stems to %s...\n", *stemsDir)
+		if err := exportStems(cfg, sr, sessionOpts, *stemsDir); err != nil {
+			fatalf("Error exporting stems: %v", err)
+		}
+		if *outputPath == "" {
+			return
+		}
+	}
+
+	// Handle output: either play or export to WAV
+	if *outputPath == "" {
+		if len(cfg.Channels) > 0 {
+			warnf("Note: playback is stereo, so the %d channels only apply to -output; playing the stereo mix.", len(cfg.Channels))
+		}
 
-		// Create the output file
-		outFile, err := os.Create(*outputPath)
+		// Binaural beats rely on each ear hearing only its own channel
+		if isBinaural(cfg) {
+			infof("*** Use headphones: binaural beats are not perceived through speakers. ***\n")
+		}
+
+		// Initialize the speaker
+		if err := initSpeaker(outputDevice(*device), sr, speakerBufferSize(sr, *bufferMS)); err != nil {
+			fatalf("No audio device available (%v); use -output to export to a file instead", err)
+		}
+
+		// Fade out instead of cutting off when interrupted with Ctrl-C
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+
+		if !*watch {
+			done, stop, fadeOut := startPlayback(mixedStreamer, sr, session, introDuration)
+			select {
+			case <-done:
+			case <-interrupts:
+				infof("Interrupted; fading out.\n")
+				fadeOut()
+				<-done
+			}
+			stop()
+			return
+		}
+
+		// Restart playback with the new configuration each time the file changes
+		watcher, err := newConfigWatcher(*configPath)
+		if err != nil {
+			fatalf("Error watching configuration file: %v", err)
+		}
+		changes := watcher.watch(watchInterval)
+		infof("Watching %s for changes.\n", *configPath)
+		done, stop, fadeOut := startPlayback(mixedStreamer, sr, session, introDuration)
+		for {
+			select {
+			case <-done:
+				infof("Playback finished; waiting for %s to change.\n", *configPath)
+				done = nil
+			case <-interrupts:
+				if done != nil {
+					infof("Interrupted; fading out.\n")
+					fadeOut()
+					<-done
+				}
+				stop()
+				return
+			case <-changes:
+				// Keep playing the previous session if the new one can't be built
+				newCfg, err := loadConfig(*configPath)
+				if err == nil {
+					err = checkNyquist(newCfg, sr)
+				}
+				if err != nil {
+					warnf("Error reloading configuration, keeping the current session: %v", err)
+					continue
+				}
+				nextSession, nextStream, nextIntroDuration, err := buildStream(newCfg)
+				if err != nil {
+					warnf("Error reloading configuration, keeping the current session: %v", err)
+					continue
+				}
+
+				stop()
+				session.Close()
+				session, mixedStreamer, introDuration = nextSession, nextStream, nextIntroDuration
+				infof("Reloaded %s; restarting playback.\n", *configPath)
+				done, stop, fadeOut = startPlayback(mixedStreamer, sr, session, introDuration)
+			}
+		}
+	} else {
+		// Export to one or more files
+		targets, err := parseOutputTargets(*outputPath)
 		if err != nil {
-			log.Fatalf("Error creating output file: %v", err)
+			fatalf("Error parsing output paths: %v", err)
+		}
+		names := make([]string, len(targets))
+		for i, target := range targets {
+			names[i] = target.String()
+		}
+		infof("Exporting audio to %s...\n", strings.Join(names, ", "))
+
+		if *loopable {
+			mixedStreamer, err = newLoopStream(cfg, sr, sessionOpts, session)
+			if err != nil {
+				fatalf("Error making the session loopable: %v", err)
+			}
 		}
-		defer outFile.Close()
 
-		// Create WAV encoder format
+		// Create the encoder format
 		format := beep.Format{
 			SampleRate:  sr,
 			NumChannels: 2,
 			Precision:   2, // 16-bit audio
 		}
+		if *floatWAV {
+			format.Precision = wavFloatPrecision
+		}
+		if *downmixBeat {
+			// The encoder averages the channels, so the two tones beat against each other
+			format.NumChannels = 1
+		}
+		if len(cfg.Channels) > 0 {
+			format.NumChannels = len(cfg.Channels)
+		}
+
+		// Refuse outputs that would be too large before synthesizing anything
+		frames := session.totalSamples + sr.N(time.Duration(introDuration*float64(time.Second)))
+		if maxFileSize > 0 {
+			if err := checkExportSize(frames, format, targets, maxFileSize); err != nil {
+				if !*force {
+					fatalf("Refusing to export: %v; use -force to export it anyway", err)
+				}
+				warnf("Warning: %v; exporting anyway because of -force.", err)
+			}
+		}
+
+		// Render each output channel from the stems instead of the stereo mix
+		if len(cfg.Channels) > 0 {
+			if err := exportChannels(cfg, sessionOpts, targets, format); err != nil {
+				fatalf("Error exporting audio: %v", err)
+			}
+			infof("Export completed successfully.\n")
+			return
+		}
 
-		// Encode and write the audio
-		err = wav.Encode(outFile, mixedStreamer, format)
+		// Encode and write the audio, hashing it and measuring the levels for the report
+		hasher := newRenderHasher(mixedStreamer)
+		meter := &levelMeter{stream: hasher}
+		if *truePeak {
+			meter.truePeak = newTruePeakDetector()
+		}
+		err = exportSession(newExportProgress(meter, sr, frames), format, cfg.metadata(), targets, *resampleQuality)
 		if err != nil {
-			log.Fatalf("Error encoding WAV: %v", err)
+			fatalf("Error exporting audio: %v", err)
+		}
+
+		infof("Export completed successfully.\n")
+		infof("Render hash (SHA-256): %s\n", hasher.sum())
+
+		if *truePeak {
+			infof("Sample peak: %.2f dBFS, true peak: %.2f dBTP\n", linearToDB(meter.peak), linearToDB(meter.truePeak.peak))
+			if meter.truePeak.peak > 1 {
+				warnf("Warning: the true peak is above full scale, so the export may clip on playback even though no sample does; lower the level with -normalize.")
+			}
+		}
+
+		if *reportPath != "" {
+			reportConfigPath := *configPath
+			if *sweep != "" || *scriptPath != "" || *configB64 != "" {
+				reportConfigPath = ""
+			}
+			if err := writeReport(*reportPath, reportConfigPath, cfg, sr, introDuration, meter, hasher.sum()); err != nil {
+				fatalf("Error writing report: %v", err)
+			}
+			infof("Wrote report to %s.\n", *reportPath)
 		}
 
-		fmt.Println("Export completed successfully.")
+		// Re-synthesize the session and compare it against each written file
+		if *verify {
+			for _, target := range targets {
+				expectedSession, err := newSession(cfg, sr, sessionOpts)
+				if err != nil {
+					fatalf("Error building session: %v", err)
+				}
+				defer expectedSession.Close()
+
+				expected := expectedSession.streamer
+				if *introPath != "" {
+					var intro io.Closer
+					expected, _, intro, err = prependIntro(expected, *introPath, sr, *resampleQuality)
+					if err != nil {
+						fatalf("Error loading intro file: %v", err)
+					}
+					defer intro.Close()
+				}
+
+				// Resampling is deterministic, so a resampled file is checked against the same resampling
+				expected, targetFormat := targetStream(expected, format, target, *resampleQuality)
+				maxDeviation, err := verifyWAV(target.path, expected, targetFormat)
+				if err != nil {
+					fatalf("Verification of %s failed: %v", target.path, err)
+				}
+				infof("Verified %s: max deviation %.6f\n", target.path, maxDeviation)
+			}
+		}
 	}
 }
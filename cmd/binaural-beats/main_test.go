@@ -0,0 +1,2410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// testSampleRate is the sample rate sessions are rendered at in tests. It is lower than the
+// command line's so long sessions render quickly, and high enough for the test tones.
+const testSampleRate = beep.SampleRate(8000)
+
+// mustParseConfig parses and validates a YAML configuration, failing the test on an error.
+func mustParseConfig(t *testing.T, data string) *Config {
+	t.Helper()
+	cfg, err := parseConfigData([]byte(data), true)
+	if err != nil {
+		t.Fatalf("parsing configuration: %v", err)
+	}
+	return cfg
+}
+
+// testOptions returns session options without smoothing, attack ramps or noise warmup, so the
+// rendered samples follow the configuration exactly.
+func testOptions() SessionOptions {
+	return SessionOptions{Seed: 1, Headroom: defaultHeadroomDB}
+}
+
+// renderFrames renders the whole session of cfg at sr.
+func renderFrames(t *testing.T, cfg *Config, sr beep.SampleRate, opts SessionOptions) [][2]float64 {
+	t.Helper()
+	session, err := newSession(cfg, sr, opts)
+	if err != nil {
+		t.Fatalf("building session: %v", err)
+	}
+	defer session.Close()
+	return drainFrames(session.streamer)
+}
+
+// drainFrames streams s until it ends and returns all its frames.
+func drainFrames(s beep.Streamer) [][2]float64 {
+	var frames [][2]float64
+	buf := make([][2]float64, 512)
+	for {
+		n, ok := s.Stream(buf)
+		frames = append(frames, buf[:n]...)
+		if !ok {
+			return frames
+		}
+	}
+}
+
+// channel returns one channel of frames.
+func channel(frames [][2]float64, c int) []float64 {
+	samples := make([]float64, len(frames))
+	for i, frame := range frames {
+		samples[i] = frame[c]
+	}
+	return samples
+}
+
+// rmsOf returns the RMS level of samples.
+func rmsOf(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range samples {
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// toneAmplitude measures the amplitude of the frequency component of samples at freq Hz with
+// the Goertzel algorithm. A sine of amplitude a at freq measures about a.
+func toneAmplitude(samples []float64, sr beep.SampleRate, freq float64) float64 {
+	k := 2 * math.Cos(2*math.Pi*freq/float64(sr))
+	var s1, s2 float64
+	for _, v := range samples {
+		s1, s2 = v+k*s1-s2, s1
+	}
+	power := s1*s1 + s2*s2 - k*s1*s2
+	return 2 * math.Sqrt(math.Max(power, 0)) / float64(len(samples))
+}
+
+func TestLastSegmentFade(t *testing.T) {
+	changes := []ConfigFrequencyChange{{Time: 0}, {Time: 10}, {Time: 30}}
+	tests := []struct {
+		curve string
+		t     float64
+		want  float64
+	}{
+		{fadeCurveLinear, 0, 1},
+		{fadeCurveLinear, 10, 1},
+		{fadeCurveLinear, 15, 0.75},
+		{fadeCurveLinear, 20, 0.5},
+		{fadeCurveLinear, 30, 0},
+		{fadeCurveLinear, 40, 0},
+		{fadeCurveExponential, 10, 1},
+		{fadeCurveExponential, 20, 0.25},
+		{fadeCurveExponential, 30, 0},
+	}
+	for _, tt := range tests {
+		fade := createLastSegmentFadeFunc(changes, tt.curve)
+		if got := fade(tt.t); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s fade at %.0f s = %v, want %v", tt.curve, tt.t, got, tt.want)
+		}
+	}
+
+	if got := createLastSegmentFadeFunc(changes[:1], fadeCurveLinear)(5); got != 1 {
+		t.Errorf("fade of a single change = %v, want 1", got)
+	}
+}
+
+func TestAutoFadeLastSpansLastSegment(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 3, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	plain := renderFrames(t, cfg, testSampleRate, testOptions())
+	opts := testOptions()
+	opts.AutoFadeLast = true
+	faded := renderFrames(t, cfg, testSampleRate, opts)
+	if len(plain) != len(faded) {
+		t.Fatalf("faded session has %d frames, want %d", len(faded), len(plain))
+	}
+	if rmsOf(channel(plain, 0)) == 0 {
+		t.Fatal("the session is silent")
+	}
+
+	start := testSampleRate.N(time.Second) // The last segment starts at 1 s
+	for i := 0; i < start; i++ {
+		if faded[i] != plain[i] {
+			t.Fatalf("frame %d before the last segment is changed: %v, want %v", i, faded[i], plain[i])
+		}
+	}
+	for i := start; i < len(plain); i++ {
+		want := 1 - float64(i-start)/float64(len(plain)-start)
+		for c := 0; c < 2; c++ {
+			if math.Abs(faded[i][c]-plain[i][c]*want) > 1e-3 {
+				t.Fatalf("frame %d channel %d = %v, want %v faded by %.4f", i, c, faded[i][c], plain[i][c], want)
+			}
+		}
+	}
+}
+
+// writeTestWAV writes frames to a 16-bit WAV file at sr in dir and returns its path.
+func writeTestWAV(t *testing.T, dir string, sr beep.SampleRate, frames [][2]float64) string {
+	t.Helper()
+	path := filepath.Join(dir, "test.wav")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ww, err := NewWAVWriter(f, beep.Format{SampleRate: sr, NumChannels: 2, Precision: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Write(frames); err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIntroPlaysBeforeSession(t *testing.T) {
+	intro := make([][2]float64, 400)
+	for i := range intro {
+		intro[i] = [2]float64{0.5, -0.25}
+	}
+	path := writeTestWAV(t, t.TempDir(), testSampleRate, intro)
+
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	want := renderFrames(t, cfg, testSampleRate, testOptions())
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stream, duration, closer, err := prependIntro(session.streamer, path, testSampleRate, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	if wantDuration := testSampleRate.D(len(intro)).Seconds(); duration != wantDuration {
+		t.Errorf("intro duration = %v s, want %v s", duration, wantDuration)
+	}
+	got := drainFrames(stream)
+	if len(got) != len(intro)+len(want) {
+		t.Fatalf("got %d frames, want %d of intro and %d of session", len(got), len(intro), len(want))
+	}
+	for i := range intro {
+		if math.Abs(got[i][0]-0.5) > 1e-4 || math.Abs(got[i][1]+0.25) > 1e-4 {
+			t.Fatalf("frame %d = %v, want the intro's %v", i, got[i], intro[i])
+		}
+	}
+	for i := range want {
+		if got[len(intro)+i] != want[i] {
+			t.Fatalf("session frame %d after the intro = %v, want %v", i, got[len(intro)+i], want[i])
+		}
+	}
+}
+
+func TestIntroIsResampledToSessionRate(t *testing.T) {
+	introRate, sessionRate := beep.SampleRate(48000), beep.SampleRate(44100)
+	intro := make([][2]float64, introRate.N(500*time.Millisecond))
+	for i := range intro {
+		v := 0.5 * math.Sin(2*math.Pi*1000*float64(i)/float64(introRate))
+		intro[i] = [2]float64{v, v}
+	}
+	path := writeTestWAV(t, t.TempDir(), introRate, intro)
+
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 0.5, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	session, err := newSession(cfg, sessionRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stream, duration, closer, err := prependIntro(session.streamer, path, sessionRate, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	if duration != 0.5 {
+		t.Errorf("intro duration = %v s, want 0.5 s", duration)
+	}
+	got := drainFrames(stream)
+	// The intro lasts as long at the session rate, give or take the resampler's rounding
+	wantFrames := sessionRate.N(500*time.Millisecond) + session.totalSamples
+	if diff := len(got) - wantFrames; diff < -2 || diff > 2 {
+		t.Errorf("got %d frames, want %d", len(got), wantFrames)
+	}
+	// and keeps its pitch: the 1 kHz tone is still at 1 kHz, not 1088 Hz
+	introLeft := channel(got[1000:sessionRate.N(400*time.Millisecond)], 0)
+	if a := toneAmplitude(introLeft, sessionRate, 1000); math.Abs(a-0.5) > 0.01 {
+		t.Errorf("1000 Hz amplitude of the resampled intro = %.3f, want 0.5", a)
+	}
+}
+
+func TestSeedPerSegmentReseedsAtBoundaries(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.5}
+  - {time: 0.5, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.5}
+  - {time: 1, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.5}
+`)
+	opts := testOptions()
+	plain := renderFrames(t, cfg, testSampleRate, opts)
+	opts.SeedPerSegment = true
+	reseeded := renderFrames(t, cfg, testSampleRate, opts)
+	again := renderFrames(t, cfg, testSampleRate, opts)
+
+	if len(reseeded) != len(plain) {
+		t.Fatalf("got %d frames, want %d", len(reseeded), len(plain))
+	}
+	for i := range reseeded {
+		if reseeded[i] != again[i] {
+			t.Fatalf("frame %d differs between two runs with the same seed: %v and %v", i, reseeded[i], again[i])
+		}
+	}
+	boundary := testSampleRate.N(500 * time.Millisecond)
+	for i := 0; i < boundary; i++ {
+		if reseeded[i] != plain[i] {
+			t.Fatalf("frame %d before the first boundary = %v, want the unseeded %v", i, reseeded[i], plain[i])
+		}
+	}
+	same := 0
+	for i := boundary; i < len(plain); i++ {
+		if reseeded[i] == plain[i] {
+			same++
+		}
+	}
+	if same > 10 {
+		t.Errorf("%d frames after the boundary match the noise without re-seeding, want a new sequence", same)
+	}
+}
+
+func TestLabelFunc(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, label: Settle}
+  - {time: 60, frequency: 200, beat_frequency: 6, tone_volume: 0.5, label: Theta induction}
+  - {time: 120, frequency: 200, beat_frequency: 6, tone_volume: 0.5}
+  - {time: 180, frequency: 200, beat_frequency: 4, tone_volume: 0.5, label: Deepening}
+`)
+	labelAt := createLabelFunc(cfg.FrequencyChanges)
+	tests := []struct {
+		t    float64
+		want string
+	}{
+		{0, "Settle"},
+		{59.9, "Settle"},
+		{60, "Theta induction"},
+		{90, "Theta induction"},
+		{120, ""},
+		{179, ""},
+		{180, "Deepening"},
+		{999, "Deepening"},
+	}
+	for _, tt := range tests {
+		if got := labelAt(tt.t); got != tt.want {
+			t.Errorf("label at %v s = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestNoiseFadeCurveMidpoint(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.8}
+`)
+	tests := []struct {
+		curve    string
+		midpoint float64
+	}{
+		{"", 0.4},
+		{noiseFadeLinear, 0.4},
+		{noiseFadeEqualPower, 0.8 * math.Sqrt(0.5)},
+		{noiseFadeStep, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.curve, func(t *testing.T) {
+			volume := createPinkNoiseFunc(cfg.FrequencyChanges, tt.curve)
+			if got := volume(1); math.Abs(got-tt.midpoint) > 1e-9 {
+				t.Errorf("midpoint gain = %.4f, want %.4f", got, tt.midpoint)
+			}
+			if got := volume(2); math.Abs(got-0.8) > 1e-9 {
+				t.Errorf("gain at the end of the fade = %.4f, want 0.8", got)
+			}
+		})
+	}
+
+	if _, err := parseConfigData([]byte("noise_fade_curve: cubic\n"+`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`), true); err == nil || !strings.Contains(err.Error(), "noise_fade_curve") {
+		t.Errorf("error for an unknown noise_fade_curve = %v, want it named", err)
+	}
+}
+
+func TestJitterStaysWithinBound(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 600, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	opts := testOptions()
+	opts.Jitter = 0.5
+	session, err := newSession(cfg, testSampleRate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	low, high := math.Inf(1), math.Inf(-1)
+	for ts := 0.0; ts < 600; ts += 0.05 {
+		left, right := session.leftFreqFunc(ts), session.rightFreqFunc(ts)
+		offset := left - 200
+		if math.Abs(offset) > opts.Jitter {
+			t.Fatalf("carrier at %.2f s is %.3f Hz off, more than the %.1f Hz jitter", ts, offset, opts.Jitter)
+		}
+		if beat := right - left; math.Abs(beat-10) > 1e-9 {
+			t.Fatalf("beat at %.2f s = %.6f Hz, want the jitter to leave it at 10 Hz", ts, beat)
+		}
+		low, high = math.Min(low, offset), math.Max(high, offset)
+	}
+	if high-low < opts.Jitter/2 {
+		t.Errorf("carrier only varied between %+.3f and %+.3f Hz, want it to wander within ±%.1f Hz", low, high, opts.Jitter)
+	}
+}
+
+func TestAutoPanOscillatesAtRate(t *testing.T) {
+	tests := []struct {
+		rate, depth float64
+	}{
+		{0.5, 1},
+		{2, 0.5},
+		{0.25, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v Hz depth %v", tt.rate, tt.depth), func(t *testing.T) {
+			seconds := 8
+			pan := &AutoPan{
+				stream:    beep.Take(testSampleRate.N(time.Duration(seconds)*time.Second), ones()),
+				rateFunc:  func(float64) float64 { return tt.rate },
+				depthFunc: func(float64) float64 { return tt.depth },
+				sr:        testSampleRate,
+			}
+
+			// Recover the pan position from the equal-power gains of each frame
+			crossings, widest := 0, 0.0
+			previous := 0.0
+			for _, frame := range drainFrames(pan) {
+				balance := math.Max(-1, math.Min(1, (frame[1]*frame[1]-frame[0]*frame[0])/2))
+				position := math.Asin(balance) * 2 / math.Pi
+				if previous != 0 && (position < 0) != (previous < 0) {
+					crossings++
+				}
+				previous = position
+				widest = math.Max(widest, math.Abs(position))
+			}
+			if want := int(2 * tt.rate * float64(seconds)); crossings < want-1 || crossings > want {
+				t.Errorf("pan crossed the centre %d times in %d s, want %d", crossings, seconds, want)
+			}
+			if math.Abs(widest-tt.depth) > 0.01 {
+				t.Errorf("pan reached %.3f, want the depth %.3f", widest, tt.depth)
+			}
+		})
+	}
+}
+
+func TestHarmonicsSpectrum(t *testing.T) {
+	session := func(frequency float64, harmonics string) []float64 {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+harmonics: %s
+frequency_changes:
+  - {time: 0, frequency: %v, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: %v, beat_frequency: 10, tone_volume: 0.5}
+`, harmonics, frequency, frequency))
+		return channel(renderFrames(t, cfg, testSampleRate, testOptions()), 0)
+	}
+
+	tests := []struct {
+		name      string
+		frequency float64
+		harmonics string
+		want      map[float64]float64 // Amplitude at each frequency relative to a pure sine
+	}{
+		{"pure sine", 200, "[]", map[float64]float64{200: 1, 400: 0, 600: 0}},
+		{"overtones", 200, "[1.0, 0.3, 0.15]", map[float64]float64{200: 1 / 1.45, 400: 0.3 / 1.45, 600: 0.15 / 1.45, 800: 0}},
+		{"skipped fundamental", 200, "[0, 1, 1]", map[float64]float64{200: 0, 400: 0.5, 600: 0.5}},
+		{"above Nyquist left out", 1500, "[1, 0.5, 0.5]", map[float64]float64{1500: 0.5, 3000: 0.25, 3500: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pure := toneAmplitude(session(tt.frequency, "[]"), testSampleRate, tt.frequency)
+			samples := session(tt.frequency, tt.harmonics)
+			for freq, want := range tt.want {
+				got := toneAmplitude(samples, testSampleRate, freq) / pure
+				if math.Abs(got-want) > 0.005 {
+					t.Errorf("amplitude at %v Hz = %.4f of the pure sine, want %.4f", freq, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNoiseTailHasNoiseWithoutTone(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+`)
+	opts := testOptions()
+	opts.NoiseTail = 0.5
+	frames := renderFrames(t, cfg, testSampleRate, opts)
+	if want := testSampleRate.N(1500 * time.Millisecond); len(frames) != want {
+		t.Fatalf("got %d frames, want %d for the session and its tail", len(frames), want)
+	}
+	toneEnd := testSampleRate.N(time.Second)
+	opts.Stem = stemNoise
+	noise := renderFrames(t, cfg, testSampleRate, opts)
+
+	session, tail := channel(frames[:toneEnd], 0), channel(frames[toneEnd:], 0)
+	if a := toneAmplitude(session, testSampleRate, 200); a < 0.1 {
+		t.Errorf("tone amplitude before the tail = %.4f, want the tone", a)
+	}
+	if a := toneAmplitude(tail, testSampleRate, 200); a > 0.01 {
+		t.Errorf("tone amplitude in the tail = %.4f, want none", a)
+	}
+	if rms := rmsOf(tail); rms < 0.01 {
+		t.Errorf("RMS of the tail = %.4f, want the noise to continue", rms)
+	}
+	for i := toneEnd; i < len(frames); i++ {
+		if frames[i] != noise[i] {
+			t.Fatalf("tail frame %d = %v, want only the noise %v", i, frames[i], noise[i])
+		}
+	}
+}
+
+func TestIsolateSilencesOtherChannel(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+`)
+	both := renderFrames(t, cfg, testSampleRate, testOptions())
+	tests := []struct {
+		isolate      string
+		kept, zeroed int
+	}{
+		{isolateLeft, 0, 1},
+		{isolateRight, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isolate, func(t *testing.T) {
+			opts := testOptions()
+			opts.Isolate = tt.isolate
+			frames := renderFrames(t, cfg, testSampleRate, opts)
+			if len(frames) != len(both) {
+				t.Fatalf("got %d frames, want %d", len(frames), len(both))
+			}
+			for i := range frames {
+				if frames[i][tt.zeroed] != 0 {
+					t.Fatalf("frame %d of the isolated-away channel = %v, want silence", i, frames[i][tt.zeroed])
+				}
+				if frames[i][tt.kept] != both[i][tt.kept] {
+					t.Fatalf("frame %d of the isolated channel = %v, want %v", i, frames[i][tt.kept], both[i][tt.kept])
+				}
+			}
+		})
+	}
+
+	opts := testOptions()
+	opts.Isolate = isolateNone
+	frames := renderFrames(t, cfg, testSampleRate, opts)
+	for i := range frames {
+		if frames[i] != both[i] {
+			t.Fatalf("frame %d with -isolate none = %v, want %v", i, frames[i], both[i])
+		}
+	}
+}
+
+func TestNoiseFileLoopsUnderVolume(t *testing.T) {
+	loop := make([][2]float64, 100)
+	for i := range loop {
+		loop[i] = [2]float64{0.05 + 0.008*float64(i), -0.5 + 0.004*float64(i)}
+	}
+	path := writeTestWAV(t, t.TempDir(), testSampleRate, loop)
+
+	render := func(volume float64) [][2]float64 {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+noise_file: %q
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: %v}
+  - {time: 0.5, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: %v}
+`, path, volume, volume))
+		return renderFrames(t, cfg, testSampleRate, testOptions())
+	}
+	full, half := render(0.8), render(0.4)
+
+	// Each frame is the file's frame at the same place in the loop, scaled by the volume
+	gain := full[0][0] / loop[0][0]
+	if gain <= 0 {
+		t.Fatalf("gain of the noise file = %v, want it audible", gain)
+	}
+	for i := range full {
+		for c := 0; c < 2; c++ {
+			if want := loop[i%len(loop)][c] * gain; math.Abs(full[i][c]-want) > 1e-3 {
+				t.Fatalf("frame %d channel %d = %.5f, want the looped file's %.5f", i, c, full[i][c], want)
+			}
+			if math.Abs(half[i][c]-full[i][c]/2) > 1e-9 {
+				t.Fatalf("frame %d channel %d at half the volume = %.5f, want %.5f", i, c, half[i][c], full[i][c]/2)
+			}
+		}
+	}
+}
+
+// runMain runs the command with args, as if given on the command line, with fresh flags.
+func runMain(t *testing.T, args ...string) {
+	t.Helper()
+	savedArgs, savedFlags, savedLevel, savedLogger, savedHooks := os.Args, flag.CommandLine, logLevel, jsonLogger, exitHooks
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine, logLevel, jsonLogger, exitHooks = savedArgs, savedFlags, savedLevel, savedLogger, savedHooks
+	})
+	os.Args = append([]string{"binaural-beats"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	main()
+}
+
+// captureStdout runs f and returns what it printed to the standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	out := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- data
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func TestPinkNoiseVolumeDB(t *testing.T) {
+	inDB := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume_db: -20}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume_db: 0}
+`)
+	linear := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0.1}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 1}
+`)
+	if v := inDB.FrequencyChanges[0].PinkNoiseVolume; math.Abs(v-0.1) > 1e-12 {
+		t.Errorf("-20 dB = %v linear, want 0.1", v)
+	}
+	// The volume is interpolated in linear space, not in dB
+	if v := createPinkNoiseFunc(inDB.FrequencyChanges, "")(0.5); math.Abs(v-0.55) > 1e-12 {
+		t.Errorf("volume halfway from -20 dB to 0 dB = %v, want 0.55", v)
+	}
+	got := renderFrames(t, inDB, testSampleRate, testOptions())
+	want := renderFrames(t, linear, testSampleRate, testOptions())
+	for i := range want {
+		if math.Abs(got[i][0]-want[i][0]) > 1e-12 || math.Abs(got[i][1]-want[i][1]) > 1e-12 {
+			t.Fatalf("frame %d = %v, want the linear volume's %v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := parseConfigData([]byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, pink_noise_volume: 0.5, pink_noise_volume_db: -6}
+`), true); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("error for both volume forms = %v, want them rejected", err)
+	}
+}
+
+// startPhase returns the phase in radians, from -π to π, that a tone of freq Hz in samples
+// starts from. The tone advances its phase before each sample, so the first sample is one step
+// past it.
+func startPhase(samples []float64, sr beep.SampleRate, freq float64) float64 {
+	step := 2 * math.Pi * freq / float64(sr)
+	var a, b float64
+	for n, v := range samples {
+		a += v * math.Sin(step*float64(n+1))
+		b += v * math.Cos(step*float64(n+1))
+	}
+	return math.Atan2(b, a)
+}
+
+func TestRandomPhase(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	phases := func(seed int64, random bool) [2]float64 {
+		opts := testOptions()
+		opts.Seed = seed
+		opts.RandomPhase = random
+		frames := renderFrames(t, cfg, testSampleRate, opts)
+		return [2]float64{
+			startPhase(channel(frames, 0), testSampleRate, 200),
+			startPhase(channel(frames, 1), testSampleRate, 210),
+		}
+	}
+
+	if fixed := phases(1, false); math.Abs(fixed[0]) > 1e-6 || math.Abs(fixed[1]) > 1e-6 {
+		t.Errorf("start phases without -random-phase = %v, want 0", fixed)
+	}
+	first, again, other := phases(1, true), phases(1, true), phases(2, true)
+	if first != again {
+		t.Errorf("start phases with seed 1 = %v, then %v, want them reproducible", first, again)
+	}
+	for c, phase := range first {
+		if math.Abs(phase) < 1e-3 {
+			t.Errorf("start phase of channel %d = %v, want a random nonzero phase", c, phase)
+		}
+	}
+	if math.Abs(first[0]-first[1]) < 1e-3 {
+		t.Errorf("both channels start at phase %v, want each tone randomized", first[0])
+	}
+	if first == other {
+		t.Errorf("seeds 1 and 2 give the same start phases %v", first)
+	}
+}
+
+func TestCheckNyquist(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		sr      beep.SampleRate
+		wantErr bool
+	}{
+		{"well below", `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 400, beat_frequency: 10, tone_volume: 0.5}
+`, 8000, false},
+		{"carrier plus beat above", `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 3995, beat_frequency: 10, tone_volume: 0.5}
+`, 8000, true},
+		{"fine at a higher rate", `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 3995, beat_frequency: 10, tone_volume: 0.5}
+`, 44100, false},
+		{"negative beat stays below", `
+frequency_changes:
+  - {time: 0, frequency: 3995, beat_frequency: -10, tone_volume: 0.5}
+  - {time: 1, frequency: 3995, beat_frequency: -10, tone_volume: 0.5}
+`, 8000, false},
+		{"overtone above", `
+harmonics: [1, 0.5, 0.25]
+frequency_changes:
+  - {time: 0, frequency: 1400, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 1400, beat_frequency: 10, tone_volume: 0.5}
+`, 8000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNyquist(mustParseConfig(t, tt.config), tt.sr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkNyquist at %d Hz = %v, want error %v", tt.sr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStereoNoiseDecorrelatesChannels(t *testing.T) {
+	render := func(stereo bool, seed int64) [][2]float64 {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+stereo_noise: %v
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.5}
+  - {time: 1, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.5}
+`, stereo))
+		opts := testOptions()
+		opts.Seed = seed
+		return renderFrames(t, cfg, testSampleRate, opts)
+	}
+	// correlation returns the correlation coefficient of the two channels
+	correlation := func(frames [][2]float64) float64 {
+		var lr, ll, rr float64
+		for _, f := range frames {
+			lr += f[0] * f[1]
+			ll += f[0] * f[0]
+			rr += f[1] * f[1]
+		}
+		return lr / math.Sqrt(ll*rr)
+	}
+
+	mono := render(false, 1)
+	for i, f := range mono {
+		if f[0] != f[1] {
+			t.Fatalf("mono noise frame %d = %v, want both channels the same", i, f)
+		}
+	}
+	stereo := render(true, 1)
+	if c := correlation(stereo); math.Abs(c) > 0.3 {
+		t.Errorf("correlation of the stereo noise channels = %.3f, want them independent", c)
+	}
+	again := render(true, 1)
+	for i := range stereo {
+		if stereo[i] != again[i] {
+			t.Fatalf("stereo noise frame %d = %v, then %v with the same seed", i, stereo[i], again[i])
+		}
+	}
+	if other := render(true, 2); other[100] == stereo[100] {
+		t.Errorf("seeds 1 and 2 give the same stereo noise")
+	}
+}
+
+func TestNormalizeTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		times     []float64
+		wantTimes []float64
+		wantShift float64
+	}{
+		{"late start", []float64{30, 60, 90.5}, []float64{0, 30, 60.5}, 30},
+		{"already at zero", []float64{0, 10}, []float64{0, 10}, 0},
+		{"single change", []float64{5}, []float64{0}, 5},
+		{"empty", nil, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []ConfigFrequencyChange
+			for _, ts := range tt.times {
+				changes = append(changes, ConfigFrequencyChange{Time: ts, Frequency: 200, ToneVolume: 0.5})
+			}
+			shifted, shift := normalizeTimes(changes)
+			if shift != tt.wantShift {
+				t.Errorf("shift = %v, want %v", shift, tt.wantShift)
+			}
+			if len(shifted) != len(tt.wantTimes) {
+				t.Fatalf("got %d changes, want %d", len(shifted), len(tt.wantTimes))
+			}
+			for i, fc := range shifted {
+				if fc.Time != tt.wantTimes[i] || fc.Frequency != 200 || fc.ToneVolume != 0.5 {
+					t.Errorf("change %d = %+v, want time %v and the settings kept", i, fc, tt.wantTimes[i])
+				}
+			}
+			if len(tt.times) > 0 && changes[0].Time != tt.times[0] {
+				t.Errorf("the original changes were modified")
+			}
+		})
+	}
+}
+
+func TestLeadInSilence(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0.5, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+`)
+	held := renderFrames(t, cfg, testSampleRate, testOptions())
+	cfg.FrequencyChanges = insertLeadInSilence(cfg.FrequencyChanges)
+	silent := renderFrames(t, cfg, testSampleRate, testOptions())
+	if len(silent) != len(held) {
+		t.Fatalf("got %d frames, want %d", len(silent), len(held))
+	}
+	start := testSampleRate.N(500 * time.Millisecond)
+	if rms := rmsOf(channel(held[:start], 0)); rms < 0.05 {
+		t.Errorf("RMS before the first change = %.4f, want its settings held from 0", rms)
+	}
+	for i := 0; i < start; i++ {
+		if silent[i] != [2]float64{} {
+			t.Fatalf("frame %d before the first change = %v, want silence", i, silent[i])
+		}
+	}
+	if rms := rmsOf(channel(silent[start:], 0)); rms < 0.05 {
+		t.Errorf("RMS after the first change = %.4f, want the session", rms)
+	}
+}
+
+func TestParseEmptyConfig(t *testing.T) {
+	tests := []struct {
+		name, data, wantErr string
+	}{
+		{"empty file", "", "the configuration is empty"},
+		{"whitespace only", "  \n\t\n   \n", "the configuration is empty"},
+		{"comments only", "# TODO: write the session\n", "the configuration is empty"},
+		{"empty frequency_changes", "frequency_changes: []\n", "frequency_changes is empty"},
+		{"no frequency_changes", "mode: monaural\n", "has no frequency_changes list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, strict := range []bool{false, true} {
+				_, err := parseConfig(path, strict)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseConfig (strict %v) error = %v, want %q", strict, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestToneBelowNoise(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.6}
+  - {time: 1.5, frequency: 200, beat_frequency: 10, tone_volume: 0.3, pink_noise_volume: 0}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.3, pink_noise_volume: 0}
+`)
+	opts := testOptions()
+	opts.ToneBelowNoise = 6
+	session, err := newSession(cfg, testSampleRate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ratio := math.Pow(10, -6.0/20)
+	for ts := 0.0; ts < 1.5; ts += 0.1 {
+		tone, noise := session.volumeFunc(ts), session.pinkNoiseFunc(ts)
+		if math.Abs(tone-noise*ratio) > 1e-12 {
+			t.Errorf("tone volume at %.1f s = %.4f with noise at %.4f, want 6 dB below it (%.4f)", ts, tone, noise, noise*ratio)
+		}
+	}
+	// Once the noise is off the configured tone volume applies
+	for _, ts := range []float64{1.5, 1.75, 2} {
+		if tone := session.volumeFunc(ts); tone != 0.3 {
+			t.Errorf("tone volume at %.2f s with the noise off = %.4f, want the configured 0.3", ts, tone)
+		}
+	}
+
+	// The rendered tone is as loud as one configured at that volume
+	steady := mustParseConfig(t, fmt.Sprintf(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: %v, pink_noise_volume: 0.4}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: %v, pink_noise_volume: 0.4}
+`, 0.4*ratio, 0.4*ratio))
+	referenceOpts := testOptions()
+	referenceOpts.Stem = stemLeftTone
+	want := renderFrames(t, steady, testSampleRate, referenceOpts)
+	steady.FrequencyChanges[0].ToneVolume, steady.FrequencyChanges[1].ToneVolume = 0.1, 0.1
+	opts.Stem = stemLeftTone
+	got := renderFrames(t, steady, testSampleRate, opts)
+	for i := range want {
+		if math.Abs(got[i][0]-want[i][0]) > 1e-12 {
+			t.Fatalf("left tone frame %d = %v, want %v", i, got[i][0], want[i][0])
+		}
+	}
+}
+
+func TestTonePanLaw(t *testing.T) {
+	monaural := mustParseConfig(t, `
+mode: monaural
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	centred := renderFrames(t, monaural, testSampleRate, testOptions())
+	energy := func(frames [][2]float64) (left, right float64) {
+		for _, f := range frames {
+			left += f[0] * f[0]
+			right += f[1] * f[1]
+		}
+		return left, right
+	}
+	centreLeft, centreRight := energy(centred)
+
+	tests := []struct {
+		pan                   float64
+		leftShare, rightShare float64 // Fraction of the total energy in each channel
+	}{
+		{0.5, 0.5 - math.Sqrt2/4, 0.5 + math.Sqrt2/4},
+		{-0.5, 0.5 + math.Sqrt2/4, 0.5 - math.Sqrt2/4},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.pan), func(t *testing.T) {
+			opts := testOptions()
+			opts.TonePan = tt.pan
+			left, right := energy(renderFrames(t, monaural, testSampleRate, opts))
+			// Equal-power panning moves energy between the channels without changing the total
+			total := left + right
+			if math.Abs(total-(centreLeft+centreRight)) > 1e-6*total {
+				t.Errorf("total energy = %.4f, want the centred %.4f", total, centreLeft+centreRight)
+			}
+			if math.Abs(left/total-tt.leftShare) > 1e-6 || math.Abs(right/total-tt.rightShare) > 1e-6 {
+				t.Errorf("energy shares = %.4f left, %.4f right, want %.4f and %.4f", left/total, right/total, tt.leftShare, tt.rightShare)
+			}
+		})
+	}
+
+	t.Run("ignored in binaural mode", func(t *testing.T) {
+		binaural := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+		want := renderFrames(t, binaural, testSampleRate, testOptions())
+		opts := testOptions()
+		opts.TonePan = 0.5
+		got := renderFrames(t, binaural, testSampleRate, opts)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("frame %d = %v, want the unpanned %v", i, got[i], want[i])
+			}
+		}
+	})
+}
+
+func TestNoiseFadeStepVersusLinear(t *testing.T) {
+	render := func(curve string) [][2]float64 {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+noise_fade_curve: %s
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.6}
+  - {time: 2, frequency: 0, beat_frequency: 0, pink_noise_volume: 0.6}
+`, curve))
+		return renderFrames(t, cfg, testSampleRate, testOptions())
+	}
+	step, linear := render(noiseFadeStep), render(noiseFadeLinear)
+
+	// The noise is the same sequence under both curves, so the ratio of the samples is the
+	// ratio of the volumes: step holds 0.2 until the change, linear is at 0.4 halfway
+	midpoint := testSampleRate.N(500 * time.Millisecond)
+	if ratio := linear[midpoint][0] / step[midpoint][0]; math.Abs(ratio-2) > 0.01 {
+		t.Errorf("linear/step noise at the midpoint = %.3f, want 2 (0.4 against 0.2)", ratio)
+	}
+	before, change := testSampleRate.N(990*time.Millisecond), testSampleRate.N(time.Second)
+	if ratio := linear[before][0] / step[before][0]; math.Abs(ratio-0.596/0.2) > 0.01 {
+		t.Errorf("linear/step noise just before the change = %.3f, want step still at 0.2", ratio)
+	}
+	if ratio := linear[change+10][0] / step[change+10][0]; math.Abs(ratio-1) > 1e-9 {
+		t.Errorf("linear/step noise after the change = %.3f, want both at 0.6", ratio)
+	}
+}
+
+// ones is an endless stream of full-scale frames, to measure the gain a streamer applies.
+func ones() beep.Streamer {
+	return beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		for i := range samples {
+			samples[i] = [2]float64{1, 1}
+		}
+		return len(samples), true
+	})
+}
+
+func TestBeatShapeEnvelope(t *testing.T) {
+	// At 4 Hz a beat period is 2000 frames, the first half on and the second off. A trapezoid
+	// ramp is the fraction of the on half spent on the attack, and again on the release
+	const rate = 4.0
+	tests := []struct {
+		shape string
+		ramp  float64
+		phase float64
+		want  float64
+	}{
+		{beatShapeSquare, 0, 0.01, 1},
+		{beatShapeSquare, 0, 0.25, 1},
+		{beatShapeSquare, 0, 0.51, 0},
+		{beatShapeSquare, 0, 0.9, 0},
+		{beatShapeSine, 0, 0, 0},
+		{beatShapeSine, 0, 0.25, 0.5},
+		{beatShapeSine, 0, 0.5, 1},
+		{beatShapeSine, 0, 0.75, 0.5},
+		{beatShapeTrapezoid, 0.5, 0, 0},
+		{beatShapeTrapezoid, 0.5, 0.125, 0.5},
+		{beatShapeTrapezoid, 0.5, 0.25, 1},
+		{beatShapeTrapezoid, 0.5, 0.375, 0.5},
+		{beatShapeTrapezoid, 0.5, 0.75, 0},
+		{beatShapeTrapezoid, 0.2, 0.05, 0.5},
+		{beatShapeTrapezoid, 0.2, 0.1, 1},
+		{beatShapeTrapezoid, 0, 0.01, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s ramp %v at %v", tt.shape, tt.ramp, tt.phase), func(t *testing.T) {
+			gate := &IsochronicGate{
+				stream:    beep.Take(testSampleRate.N(time.Second), ones()),
+				rateFunc:  func(float64) float64 { return rate },
+				shapeFunc: func(float64) string { return tt.shape },
+				rampFunc:  func(float64) float64 { return tt.ramp },
+				sr:        testSampleRate,
+			}
+			frames := drainFrames(gate)
+			period := int(float64(testSampleRate) / rate)
+			// Every beat period has the same envelope
+			for beat := 0; beat < len(frames)/period; beat++ {
+				i := beat*period + int(tt.phase*float64(period))
+				if math.Abs(frames[i][0]-tt.want) > 1e-6 || frames[i][1] != frames[i][0] {
+					t.Fatalf("gain in beat %d = %v, want %v in both channels", beat, frames[i], tt.want)
+				}
+			}
+		})
+	}
+}
+
+// streamSamples returns a function that reads channel c of s one sample at a time.
+func streamSamples(s beep.Streamer, c int) func() float64 {
+	buf := make([][2]float64, 1)
+	return func() float64 {
+		s.Stream(buf)
+		return buf[0][c]
+	}
+}
+
+func TestNoiseTiltShiftsSlope(t *testing.T) {
+	const sr = 44100
+	slope := func(tilt float64) float64 {
+		filter := &NoiseTiltFilter{
+			stream:   NewPinkNoise(1),
+			tiltFunc: func(float64) float64 { return tilt },
+			sr:       sr,
+		}
+		power := averagePowerSpectrum(streamSamples(filter, 0), 4096, 64)
+		return spectralSlope(power, sr, 250, 4000)
+	}
+
+	brown, pink, white := slope(-1), slope(0), slope(1)
+	if !(brown < pink-1 && pink < white-1) {
+		t.Errorf("slopes at tilt -1, 0 and 1 are %.2f, %.2f and %.2f dB/octave, want each more than 1 dB/octave above the last", brown, pink, white)
+	}
+
+	// Tilt 0 leaves the noise unchanged
+	filter := &NoiseTiltFilter{stream: NewPinkNoise(1), tiltFunc: func(float64) float64 { return 0 }, sr: sr}
+	want := drainN(NewPinkNoise(1), 1000)
+	got := drainN(filter, 1000)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("frame %d with tilt 0 = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// drainN streams n frames from s.
+func drainN(s beep.Streamer, n int) [][2]float64 {
+	frames := make([][2]float64, n)
+	fillSamples(s, frames)
+	return frames
+}
+
+func TestPhaseOffsetShiftsRightChannel(t *testing.T) {
+	const freq, amplitude = 200.0, 0.25 // tone_volume 0.5 at the default headroom of 0.5
+	for _, offset := range []float64{0, 90, 180, -45} {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+phase_offset: %v
+frequency_changes:
+  - {time: 0, frequency: %v, beat_frequency: 0, tone_volume: 0.5}
+  - {time: 0.1, frequency: %v, beat_frequency: 0, tone_volume: 0.5}
+`, offset, freq, freq))
+		frames := renderFrames(t, cfg, testSampleRate, testOptions())
+		for i, frame := range frames[:100] {
+			// The phase advances before each sample is taken
+			phase := 2 * math.Pi * freq * float64(i+1) / float64(testSampleRate)
+			wantLeft := amplitude * math.Sin(phase)
+			wantRight := amplitude * math.Sin(phase+offset*math.Pi/180)
+			if math.Abs(frame[0]-wantLeft) > 1e-9 || math.Abs(frame[1]-wantRight) > 1e-9 {
+				t.Fatalf("phase_offset %v: frame %d = %v, want [%v %v]", offset, i, frame, wantLeft, wantRight)
+			}
+		}
+	}
+}
+
+func TestTrimSilence(t *testing.T) {
+	silent := func(time float64) ConfigFrequencyChange {
+		return ConfigFrequencyChange{Time: time, Frequency: 200}
+	}
+	audible := func(time float64) ConfigFrequencyChange {
+		return ConfigFrequencyChange{Time: time, Frequency: 200, ToneVolume: 0.5}
+	}
+	tests := []struct {
+		name                      string
+		changes                   []ConfigFrequencyChange
+		wantDuration              float64
+		wantLeading, wantTrailing float64
+		wantTimes                 []float64
+		wantErr                   bool
+	}{
+		{
+			name:         "silent head and tail",
+			changes:      []ConfigFrequencyChange{silent(0), silent(10), audible(20), audible(30), silent(40), silent(50)},
+			wantDuration: 30, wantLeading: 10, wantTrailing: 10,
+			wantTimes: []float64{0, 10, 20, 30},
+		},
+		{
+			name:         "nothing silent",
+			changes:      []ConfigFrequencyChange{audible(0), audible(60)},
+			wantDuration: 60,
+			wantTimes:    []float64{0, 60},
+		},
+		{
+			name:         "fade in from silence only",
+			changes:      []ConfigFrequencyChange{silent(0), audible(5), audible(25)},
+			wantDuration: 25,
+			wantTimes:    []float64{0, 5, 25},
+		},
+		{
+			name:         "silent head",
+			changes:      []ConfigFrequencyChange{silent(0), silent(100), audible(110), audible(200)},
+			wantDuration: 100, wantLeading: 100,
+			wantTimes: []float64{0, 10, 100},
+		},
+		{
+			name:    "all silent",
+			changes: []ConfigFrequencyChange{silent(0), silent(10)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmed, leading, trailing, err := trimSilence(tt.changes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("trimming succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getTotalPlaybackTime(trimmed); got != tt.wantDuration {
+				t.Errorf("trimmed duration = %v s, want %v s", got, tt.wantDuration)
+			}
+			if leading != tt.wantLeading || trailing != tt.wantTrailing {
+				t.Errorf("removed %v s leading and %v s trailing, want %v s and %v s", leading, trailing, tt.wantLeading, tt.wantTrailing)
+			}
+			var times []float64
+			for _, fc := range trimmed {
+				times = append(times, fc.Time)
+			}
+			if fmt.Sprint(times) != fmt.Sprint(tt.wantTimes) {
+				t.Errorf("trimmed times = %v, want %v", times, tt.wantTimes)
+			}
+		})
+	}
+}
+
+func TestNoiseAmplitudeScalesRMS(t *testing.T) {
+	render := func(amplitude, volume float64) [][2]float64 {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: %g}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: %g}
+`, volume, volume))
+		opts := testOptions()
+		opts.NoiseAmplitude = amplitude
+		return renderFrames(t, cfg, testSampleRate, opts)
+	}
+	reference := rmsOf(channel(render(pinkNoiseAmplitude, 1), 0))
+
+	tests := []struct {
+		name              string
+		amplitude, volume float64
+		want              float64 // RMS relative to the default amplitude at volume 1.0
+	}{
+		{"default amplitude at half volume", pinkNoiseAmplitude, 0.5, 0.5},
+		{"full-scale amplitude", pinkNoiseFullScaleAmplitude, 1, pinkNoiseFullScaleAmplitude / pinkNoiseAmplitude},
+		{"full-scale amplitude at quarter volume", pinkNoiseFullScaleAmplitude, 0.25, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := render(tt.amplitude, tt.volume)
+			if got := rmsOf(channel(frames, 0)) / reference; math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("RMS is %.4f of the default, want %.4f", got, tt.want)
+			}
+			for _, frame := range frames {
+				if math.Abs(frame[0]) > 1 {
+					t.Fatalf("noise peaks at %.3f, above full scale", frame[0])
+				}
+			}
+		})
+	}
+}
+
+func TestEase(t *testing.T) {
+	curves := []string{"", easingLinear, easingEaseIn, easingEaseOut, easingEaseInOut}
+	for _, curve := range curves {
+		if start, end := ease(curve, 0), ease(curve, 1); start != 0 || math.Abs(end-1) > 1e-12 {
+			t.Errorf("%q runs from %v to %v, want 0 to 1", curve, start, end)
+		}
+	}
+
+	// ease_in_out is symmetric around the midpoint: the second half mirrors the first
+	if mid := ease(easingEaseInOut, 0.5); mid != 0.5 {
+		t.Errorf("ease_in_out at the midpoint = %v, want 0.5", mid)
+	}
+	for _, frac := range []float64{0.05, 0.2, 0.35, 0.49} {
+		if sum := ease(easingEaseInOut, frac) + ease(easingEaseInOut, 1-frac); math.Abs(sum-1) > 1e-12 {
+			t.Errorf("ease_in_out(%v) + ease_in_out(%v) = %v, want 1", frac, 1-frac, sum)
+		}
+	}
+
+	tests := []struct {
+		curve string
+		want  float64 // Value at a quarter of the segment
+	}{
+		{easingLinear, 0.25},
+		{easingEaseIn, 0.25 * 0.25 * 0.25},
+		{easingEaseOut, 1 - 0.75*0.75*0.75},
+		{easingEaseInOut, 4 * 0.25 * 0.25 * 0.25},
+	}
+	for _, tt := range tests {
+		cfg := mustParseConfig(t, fmt.Sprintf(`
+frequency_changes:
+  - {time: 0, frequency: 100, beat_frequency: 10, easing: %s}
+  - {time: 4, frequency: 200, beat_frequency: 10}
+`, tt.curve))
+		if got, want := createFreqFunc(cfg.FrequencyChanges)(1), 100+100*tt.want; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s carrier a quarter through the segment = %.4f Hz, want %.4f", tt.curve, got, want)
+		}
+	}
+}
+
+func TestInterpolationClamps(t *testing.T) {
+	// Out-of-range endpoints stand in for an overshooting curve: the interpolated values pass
+	// through and beyond the limits, and must be held at them
+	changes := []ConfigFrequencyChange{
+		{Time: 0, Frequency: 100, ToneVolume: 1.5, PinkNoiseVolume: 2, Easing: easingEaseInOut},
+		{Time: 4, Frequency: -300, ToneVolume: -0.5, PinkNoiseVolume: -1},
+	}
+	tests := []struct {
+		name       string
+		f          func(t float64) float64
+		start, end float64
+		low, high  float64
+	}{
+		{"tone volume", createVolumeFunc(changes), 1, 0, 0, 1},
+		{"noise volume, linear", createPinkNoiseFunc(changes, noiseFadeLinear), 1, 0, 0, 1},
+		{"noise volume, equal_power", createPinkNoiseFunc(changes, noiseFadeEqualPower), 1, 0, 0, 1},
+		{"noise volume, step", createPinkNoiseFunc(changes, noiseFadeStep), 1, 0, 0, 1},
+		{"frequency", createFreqFunc(changes), 100, 0, 0, math.Inf(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f(0); got != tt.start {
+				t.Errorf("value at the start = %v, want %v", got, tt.start)
+			}
+			if got := tt.f(4); got != tt.end {
+				t.Errorf("value at the end = %v, want %v", got, tt.end)
+			}
+			for i := 0; i <= 40; i++ {
+				at := float64(i) / 10
+				if got := tt.f(at); got < tt.low || got > tt.high {
+					t.Errorf("value at %.1f s = %v, outside %v to %v", at, got, tt.low, tt.high)
+				}
+			}
+		})
+	}
+}
+func TestNonFiniteValuesKeepOutputFinite(t *testing.T) {
+	// YAML accepts .nan and .inf, and range checks don't catch NaN, so these reach synthesis
+	tests := []struct {
+		name   string
+		change string
+	}{
+		{"NaN tone volume", "tone_volume: .nan, pink_noise_volume: 0.2"},
+		{"NaN noise volume", "tone_volume: 0.5, pink_noise_volume: .nan"},
+		{"NaN carrier", "frequency: .nan, tone_volume: 0.5"},
+		{"infinite beat", "frequency: 200, beat_frequency: .inf, tone_volume: 0.5"},
+		{"overflowing carrier", "frequency: 1e308, beat_frequency: 1e308, tone_volume: 0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mustParseConfig(t, fmt.Sprintf(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, %s}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`, tt.change))
+			frames := renderFrames(t, cfg, testSampleRate, testOptions())
+			if len(frames) == 0 {
+				t.Fatal("session rendered no frames")
+			}
+			for i, frame := range frames {
+				for _, v := range frame {
+					if math.IsNaN(v) || math.IsInf(v, 0) {
+						t.Fatalf("frame %d is %v", i, frame)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToneSets(t *testing.T) {
+	cfg := mustParseConfig(t, `
+tone_sets:
+  alpha: {frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  theta: {frequency: 150, beat_frequency: 6, tone_volume: 0.4}
+frequency_changes:
+  - {time: 0, use: alpha}
+  - {time: 60, use: alpha, pink_noise_volume: 0.4}
+  - {time: 120, use: theta, frequency: 180}
+`)
+	want := []struct {
+		frequency, beat, tone, noise float64
+	}{
+		{200, 10, 0.5, 0.2},
+		{200, 10, 0.5, 0.4}, // The inline noise volume overrides the set's
+		{180, 6, 0.4, 0},
+	}
+	if len(cfg.FrequencyChanges) != len(want) {
+		t.Fatalf("%d frequency changes, want %d", len(cfg.FrequencyChanges), len(want))
+	}
+	for i, w := range want {
+		c := cfg.FrequencyChanges[i]
+		if c.Frequency != w.frequency || c.BeatFrequency != w.beat || c.ToneVolume != w.tone || c.PinkNoiseVolume != w.noise {
+			t.Errorf("change %d = frequency %v, beat %v, tone %v, noise %v, want %+v", i, c.Frequency, c.BeatFrequency, c.ToneVolume, c.PinkNoiseVolume, w)
+		}
+	}
+	if cfg.FrequencyChanges[1].Time != 60 {
+		t.Errorf("change 1 is at %v s, want its own time of 60", cfg.FrequencyChanges[1].Time)
+	}
+}
+
+func TestToneSetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"undefined", `
+frequency_changes:
+  - {time: 0, use: missing}
+`, `line 3: undefined tone set "missing"`},
+		{"nested", `
+tone_sets:
+  a: {frequency: 200}
+  b: {use: a}
+frequency_changes:
+  - {time: 0, use: b}
+`, `tone set "b" uses another tone set`},
+		{"with a time", `
+tone_sets:
+  a: {time: 5, frequency: 200}
+frequency_changes:
+  - {time: 0, use: a}
+`, `tone set "a" has a time`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfigData([]byte(tt.yaml), true)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeatCentsFollowsCarrier(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 100, beat_cents: 50, tone_volume: 0.5}
+  - {time: 10, frequency: 400, beat_cents: 50, tone_volume: 0.5}
+`)
+	ratio := math.Pow(2, 50.0/1200) - 1
+	freq, beat := createFreqFunc(cfg.FrequencyChanges), createBeatFreqFunc(cfg.FrequencyChanges)
+	for _, at := range []float64{0, 2.5, 5, 7.5, 10} {
+		if got, want := beat(at), freq(at)*ratio; math.Abs(got-want) > 1e-9 {
+			t.Errorf("beat at %vs = %.5f Hz, want %.5f for a %.1f Hz carrier", at, got, want, freq(at))
+		}
+	}
+	if got := beat(10) / beat(0); math.Abs(got-4) > 1e-9 {
+		t.Errorf("beat grows %.3f times as the carrier quadruples, want 4", got)
+	}
+
+	// Rendered, the right tone sits the interval above the left
+	rendered := renderFrames(t, mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_cents: 100, tone_volume: 0.5}
+  - {time: 1, frequency: 200, beat_cents: 100, tone_volume: 0.5}
+`), testSampleRate, testOptions())
+	right := 200 * math.Pow(2, 100.0/1200)
+	if amp := toneAmplitude(channel(rendered, 1), testSampleRate, right); amp < 0.1 {
+		t.Errorf("right channel has %.3f at %.2f Hz, want the tone a semitone above the carrier", amp, right)
+	}
+}
+
+func TestBeatCentsExclusiveWithBeatFrequency(t *testing.T) {
+	_, err := parseConfigData([]byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, beat_cents: 50}
+`), true)
+	if err == nil || !strings.Contains(err.Error(), "both beat_frequency and beat_cents are set at time 0.00") {
+		t.Errorf("error = %v, want both fields rejected", err)
+	}
+}
+
+func TestMasterEnvelopeScalesWholeMix(t *testing.T) {
+	const changes = `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+`
+	const envelope = `
+master_envelope:
+  - {time: 0, gain: 1}
+  - {time: 1, gain: 1}
+  - {time: 2, gain: 0}
+`
+	gain := func(t float64) float64 { return math.Min(1, 2-t) }
+	for _, stem := range []string{"", stemLeftTone, stemNoise} {
+		opts := testOptions()
+		opts.Stem = stem
+		plain := renderFrames(t, mustParseConfig(t, changes), testSampleRate, opts)
+		enveloped := renderFrames(t, mustParseConfig(t, changes+envelope), testSampleRate, opts)
+		if len(enveloped) != len(plain) {
+			t.Fatalf("stem %q: envelope changed the length from %d to %d frames", stem, len(plain), len(enveloped))
+		}
+		// Every stem is scaled by the same gain, so the tone/noise balance is kept
+		for i := range plain {
+			g := gain(float64(i) / float64(testSampleRate))
+			for c := 0; c < 2; c++ {
+				if want := plain[i][c] * g; math.Abs(enveloped[i][c]-want) > 1e-9 {
+					t.Fatalf("stem %q frame %d channel %d = %.6f, want %.6f (gain %.3f)", stem, i, c, enveloped[i][c], want, g)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeAudioFile(t *testing.T) {
+	wavPath := writeTestWAV(t, t.TempDir(), testSampleRate, make([][2]float64, 800))
+	tests := []struct {
+		name     string
+		path     string
+		rate     beep.SampleRate
+		channels int
+		frames   int
+	}{
+		{"MP3", "testdata/short.mp3", 44100, 2, 25344},
+		{"Ogg Vorbis", "testdata/short.ogg", 44100, 1, 22050},
+		{"WAV", wavPath, testSampleRate, 2, 800},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, format, err := decodeAudioFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoded.Close()
+			if format.SampleRate != tt.rate || format.NumChannels != tt.channels {
+				t.Errorf("format = %d Hz, %d channels, want %d Hz, %d channels", format.SampleRate, format.NumChannels, tt.rate, tt.channels)
+			}
+			if frames := drainFrames(decoded); len(frames) != tt.frames {
+				t.Errorf("decoded %d frames, want %d", len(frames), tt.frames)
+			}
+		})
+	}
+
+	// A compressed noise file is resampled to the session rate and plays under the noise volume
+	cfg := mustParseConfig(t, `
+noise_file: testdata/short.mp3
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 1}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 1}
+`)
+	opts := testOptions()
+	opts.ResampleQuality = 4
+	if rms := rmsOf(channel(renderFrames(t, cfg, testSampleRate, opts), 0)); rms < 0.01 {
+		t.Errorf("session with an MP3 noise file has RMS %.4f, want the file audible", rms)
+	}
+}
+
+func TestInterruptFadeRampsToSilence(t *testing.T) {
+	length := testSampleRate.N(interruptFadeTime)
+	fade := &InterruptFade{stream: ones(), length: length}
+
+	// Until the fade starts the samples pass through unchanged
+	before := make([][2]float64, 100)
+	if n, ok := fade.Stream(before); n != len(before) || !ok || before[99] != [2]float64{1, 1} {
+		t.Fatalf("before the fade streamed %d frames, ok %v, last %v, want them unchanged", n, ok, before[99])
+	}
+
+	fade.start()
+	faded := drainFrames(fade)
+	if len(faded) != length {
+		t.Fatalf("fade lasted %d frames, want %d (%v)", len(faded), length, interruptFadeTime)
+	}
+	for i, frame := range faded {
+		want := float64(length-1-i) / float64(length)
+		if math.Abs(frame[0]-want) > 1e-12 || frame[0] != frame[1] {
+			t.Fatalf("frame %d of the fade = %v, want gain %.4f in both channels", i, frame, want)
+		}
+	}
+	if faded[len(faded)-1][0] != 0 {
+		t.Errorf("fade ends at gain %v, want 0", faded[len(faded)-1][0])
+	}
+
+	// Starting again after the fade doesn't restart it
+	fade.start()
+	if n, ok := fade.Stream(before); n != 0 || ok {
+		t.Errorf("after the fade streamed %d frames, ok %v, want it ended", n, ok)
+	}
+}
+
+func TestOnSegmentChangeFiresAtWaypoints(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, label: start}
+  - {time: 0.5, frequency: 300, beat_frequency: 8, tone_volume: 0.5, label: middle}
+  - {time: 1.5, frequency: 250, beat_frequency: 6, tone_volume: 0.5, label: late}
+  - {time: 2, frequency: 250, beat_frequency: 6, tone_volume: 0.5, label: end}
+`)
+	type event struct {
+		t        float64
+		label    string
+		streamed int // Frames streamed before the chunk the callback came in
+	}
+	var events []event
+	streamed := 0
+	opts := testOptions()
+	opts.OnSegmentChange = func(t float64, fc ConfigFrequencyChange) {
+		events = append(events, event{t, fc.Label, streamed})
+	}
+	session, err := newSession(cfg, testSampleRate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	chunk := make([][2]float64, 100)
+	for {
+		n, ok := session.streamer.Stream(chunk)
+		streamed += n
+		if !ok {
+			break
+		}
+	}
+
+	want := []struct {
+		t     float64
+		label string
+	}{{0, "start"}, {0.5, "middle"}, {1.5, "late"}} // The session ends before a sample at the last change
+	if len(events) != len(want) {
+		t.Fatalf("callback fired %d times, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.t != w.t || e.label != w.label {
+			t.Errorf("callback %d = %v s %q, want %v s %q", i, e.t, e.label, w.t, w.label)
+		}
+		// It fires with the chunk holding the waypoint's first sample
+		if at := testSampleRate.N(time.Duration(w.t * float64(time.Second))); at < e.streamed || at >= e.streamed+len(chunk) {
+			t.Errorf("callback for %q came with the chunk from frame %d, want the one holding frame %d", w.label, e.streamed, at)
+		}
+	}
+}
+
+func TestFrequencyWanderBoundedAndReproducible(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, frequency_wander: 2}
+  - {time: 600, frequency: 200, beat_frequency: 10, tone_volume: 0.5, frequency_wander: 1}
+`)
+	carrier := func(seed int64) []float64 {
+		opts := testOptions()
+		opts.Seed = seed
+		session, err := newSession(cfg, testSampleRate, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		var freqs []float64
+		for ts := 0.0; ts < 600; ts += 0.05 {
+			left, right := session.leftFreqFunc(ts), session.rightFreqFunc(ts)
+			if beat := right - left; math.Abs(beat-10) > 1e-9 {
+				t.Fatalf("beat at %.2f s = %.6f Hz, want the wander to leave it at 10 Hz", ts, beat)
+			}
+			freqs = append(freqs, left)
+		}
+		return freqs
+	}
+
+	first := carrier(1)
+	low, high := math.Inf(1), math.Inf(-1)
+	for i, f := range first {
+		ts := float64(i) * 0.05
+		limit := 2 - ts/600 // The interpolated wander amplitude
+		offset := f - 200
+		if math.Abs(offset) > limit+1e-9 {
+			t.Fatalf("carrier at %.2f s is %.3f Hz off, more than the %.3f Hz wander", ts, offset, limit)
+		}
+		low, high = math.Min(low, offset), math.Max(high, offset)
+	}
+	if high-low < 1 {
+		t.Errorf("carrier only wandered between %+.3f and %+.3f Hz, want it to use the range", low, high)
+	}
+
+	same, other := carrier(1), carrier(2)
+	differs := false
+	for i := range first {
+		if same[i] != first[i] {
+			t.Fatalf("carrier at %.2f s = %v with the same seed, first render had %v", float64(i)*0.05, same[i], first[i])
+		}
+		differs = differs || other[i] != first[i]
+	}
+	if !differs {
+		t.Error("a different seed produced the same wander")
+	}
+}
+
+func TestStrictParsingRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string // Error under strict parsing
+	}{
+		{"misspelled change field", `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volme: 0.5}
+`, "field tone_volme not found"},
+		{"unknown top-level field", `
+sesion_title: Rest
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`, "field sesion_title not found"},
+		{"misspelled field in a tone set", `
+tone_sets:
+  alpha: {frequency: 200, beat_frequncy: 10}
+frequency_changes:
+  - {time: 0, use: alpha}
+`, "field beat_frequncy not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseConfigData([]byte(tt.yaml), true); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("strict error = %v, want %q", err, tt.want)
+			}
+			// Lenient parsing ignores the field for forward compatibility
+			if _, err := parseConfigData([]byte(tt.yaml), false); err != nil {
+				t.Errorf("lenient parsing failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestNoiseFloorFillsSilence(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0}
+`)
+	tests := []struct {
+		name  string
+		floor float64 // dBFS, 0 for off
+		stem  string
+	}{
+		{"off", 0, ""},
+		{"-60 dBFS", -60, ""},
+		{"-90 dBFS", -90, ""},
+		{"left out of stems", -60, stemNoise},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.NoiseFloor = tt.floor
+			opts.Stem = tt.stem
+			frames := renderFrames(t, cfg, testSampleRate, opts)
+			for c := 0; c < 2; c++ {
+				samples := channel(frames, c)
+				rms := rmsOf(samples)
+				if tt.floor == 0 || tt.stem != "" {
+					if rms != 0 {
+						t.Errorf("channel %d RMS = %g, want digital silence", c, rms)
+					}
+					continue
+				}
+				level := dbToLinear(tt.floor)
+				if rms <= 0 || rms >= level {
+					t.Errorf("channel %d RMS = %g, want above zero and below the %g floor", c, rms, level)
+				}
+				for i, v := range samples {
+					if math.Abs(v) > level {
+						t.Fatalf("channel %d frame %d = %g, above the %g floor", c, i, v, level)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDualModeHasBothBeats(t *testing.T) {
+	render := func(mode string) [][2]float64 {
+		return renderFrames(t, mustParseConfig(t, fmt.Sprintf(`
+mode: %s
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, beat_frequency2: 4, tone_volume: 0.5}
+  - {time: 2, frequency: 200, beat_frequency: 10, beat_frequency2: 4, tone_volume: 0.5}
+`, mode)), testSampleRate, testOptions())
+	}
+	// The rectified signal follows the envelope, so a 4 Hz pulse shows up at 4 Hz in it
+	pulse := func(samples []float64) float64 {
+		rectified := make([]float64, len(samples))
+		for i, v := range samples {
+			rectified[i] = math.Abs(v)
+		}
+		return toneAmplitude(rectified, testSampleRate, 4)
+	}
+
+	dual, binaural := render(modeDual), render(modeBinaural)
+	left, right := channel(dual, 0), channel(dual, 1)
+	// The difference beat: each ear carries its own tone, 10 Hz apart
+	if l, r := toneAmplitude(left, testSampleRate, 200), toneAmplitude(right, testSampleRate, 210); l < 0.05 || r < 0.05 {
+		t.Errorf("tones are %.3f at 200 Hz left and %.3f at 210 Hz right, want both present", l, r)
+	}
+	if leak := toneAmplitude(left, testSampleRate, 210); leak > 0.01 {
+		t.Errorf("left channel has %.3f at 210 Hz, want the beat formed between the ears", leak)
+	}
+	// The modulation beat: both tones pulse at beat_frequency2
+	for c, samples := range [][]float64{left, right} {
+		if got, steady := pulse(samples), pulse(channel(binaural, c)); got < 0.05 || steady > got/10 {
+			t.Errorf("channel %d envelope at 4 Hz = %.4f in dual mode and %.4f in binaural mode, want the pulse only in dual", c, got, steady)
+		}
+	}
+}
+
+func TestExponentialFadeMidpoint(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5}
+`)
+	render := func(curve string) [][2]float64 {
+		opts := testOptions()
+		opts.AutoFadeLast = true
+		opts.FadeCurve = curve
+		return renderFrames(t, cfg, testSampleRate, opts)
+	}
+	plain := renderFrames(t, cfg, testSampleRate, testOptions())
+	linear, exponential := render(fadeCurveLinear), render(fadeCurveExponential)
+
+	// The gain over the 20 ms around the midpoint, from the tone amplitude against the unfaded one
+	mid, half := testSampleRate.N(time.Second), testSampleRate.N(10*time.Millisecond)
+	gain := func(frames [][2]float64) float64 {
+		return rmsOf(channel(frames[mid-half:mid+half], 0)) / rmsOf(channel(plain[mid-half:mid+half], 0))
+	}
+	lin, exp := gain(linear), gain(exponential)
+	if math.Abs(lin-0.5) > 0.01 {
+		t.Errorf("linear fade at the midpoint = %.3f, want 0.5", lin)
+	}
+	if math.Abs(exp-0.25) > 0.01 {
+		t.Errorf("exponential fade at the midpoint = %.3f, want 0.25, the square of the linear gain", exp)
+	}
+}
+
+func TestControlSmoothingTimeConstant(t *testing.T) {
+	for _, ms := range []float64{1, 5, 20} {
+		coef := smoothingCoefficient(testSampleRate, ms/1000)
+		var cs controlSmoother
+		cs.next(0, coef)
+		tau := testSampleRate.N(time.Duration(ms * float64(time.Millisecond)))
+		previous := 0.0
+		for n := 1; n <= 3*tau; n++ {
+			v := cs.next(1, coef)
+			// A unit step moves by at most the coefficient per sample, and never overshoots
+			if step := v - previous; step > coef+1e-12 || v > 1 {
+				t.Fatalf("%v ms: sample %d moved by %.5f to %.5f, want at most %.5f", ms, n, step, v, coef)
+			}
+			previous = v
+			if n == tau {
+				if want := 1 - math.Exp(-1); math.Abs(v-want) > 0.01 {
+					t.Errorf("%v ms: step reached %.3f after one time constant, want %.3f", ms, v, want)
+				}
+			}
+		}
+	}
+	if coef := smoothingCoefficient(testSampleRate, 0); coef != 0 {
+		t.Errorf("coefficient for 0 ms = %v, want 0 to disable smoothing", coef)
+	}
+
+	// Rendered, a step in the noise volume follows the same curve. The noise is the same
+	// sequence with and without smoothing, so the ratio of the samples is the ratio of volumes
+	cfg := mustParseConfig(t, `
+noise_fade_curve: step
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0.6}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0.6}
+`)
+	opts := testOptions()
+	opts.SmoothTime = 0.005
+	raw, smoothed := renderFrames(t, cfg, testSampleRate, testOptions()), renderFrames(t, cfg, testSampleRate, opts)
+	coef := smoothingCoefficient(testSampleRate, opts.SmoothTime)
+	jump := testSampleRate.N(time.Second)
+	for n := 0; n < 200; n++ {
+		want := 0.6 - 0.4*math.Pow(1-coef, float64(n+1))
+		if got := smoothed[jump+n][0] / raw[jump+n][0] * 0.6; math.Abs(got-want) > 1e-6 {
+			t.Fatalf("noise volume %d samples after the step = %.5f, want %.5f", n, got, want)
+		}
+	}
+	if got := defaultSessionOptions(1).SmoothTime; got != defaultSmoothMS/1000 {
+		t.Errorf("default smoothing time constant = %v s, want %v ms", got, defaultSmoothMS)
+	}
+}
+
+func TestBeatScheduleExpands(t *testing.T) {
+	cfg := mustParseConfig(t, `
+beat_schedule:
+  frequency: 200
+  tone_volume: 0.5
+  pink_noise_volume: 0.2
+  entries:
+    - {beat: 10, hold: 300, ramp: 60}
+    - {beat: 4, hold: 120, ramp: 30}
+`)
+	want := []struct{ time, beat float64 }{
+		{0, 10},
+		{300, 10}, // End of the hold, start of the ramp
+		{360, 4},
+		{480, 4}, // The last entry's ramp is ignored
+	}
+	if len(cfg.FrequencyChanges) != len(want) {
+		t.Fatalf("schedule expanded to %d changes, want %d", len(cfg.FrequencyChanges), len(want))
+	}
+	for i, w := range want {
+		c := cfg.FrequencyChanges[i]
+		if c.Time != w.time || c.BeatFrequency != w.beat || c.Frequency != 200 || c.ToneVolume != 0.5 || c.PinkNoiseVolume != 0.2 {
+			t.Errorf("change %d = time %v, beat %v, carrier %v, tone %v, noise %v, want time %v, beat %v at the schedule's carrier and volumes",
+				i, c.Time, c.BeatFrequency, c.Frequency, c.ToneVolume, c.PinkNoiseVolume, w.time, w.beat)
+		}
+	}
+	if total := getTotalPlaybackTime(cfg.FrequencyChanges); total != 480 {
+		t.Errorf("schedule lasts %v s, want 480", total)
+	}
+	if mid := createBeatFreqFunc(cfg.FrequencyChanges)(330); mid != 7 {
+		t.Errorf("beat halfway through the ramp = %v Hz, want 7", mid)
+	}
+}
+
+func TestBeatScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"no entries", "beat_schedule: {frequency: 200}\n", "beat_schedule has no entries"},
+		{"negative hold", "beat_schedule: {frequency: 200, entries: [{beat: 10, hold: -1}]}\n", "beat_schedule entry 1 has a negative beat, hold or ramp"},
+		{"with frequency_changes", `
+beat_schedule: {frequency: 200, entries: [{beat: 10, hold: 10}]}
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10}
+`, "both frequency_changes and beat_schedule are set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseConfigData([]byte(tt.yaml), true); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadroomSetsPeakLevel(t *testing.T) {
+	tone := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 1, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 1, pink_noise_volume: 0}
+`)
+	noise := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 1}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 1}
+`)
+	peak := func(frames [][2]float64) float64 {
+		var p float64
+		for _, f := range frames {
+			p = math.Max(p, math.Max(math.Abs(f[0]), math.Abs(f[1])))
+		}
+		return p
+	}
+	render := func(cfg *Config, db float64) [][2]float64 {
+		opts := testOptions()
+		opts.Headroom = db
+		return renderFrames(t, cfg, testSampleRate, opts)
+	}
+	noiseReference := peak(render(noise, 0))
+
+	tests := []struct {
+		name string
+		db   float64
+	}{
+		{"default", defaultHeadroomDB},
+		{"none", 0},
+		{"12 dB", 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A full-volume tone peaks at the headroom below full scale
+			if got, want := peak(render(tone, tt.db)), dbToLinear(-tt.db); math.Abs(got-want) > 1e-3 {
+				t.Errorf("tone peaks at %.4f, want %.4f (%.1f dB below full scale)", got, want, tt.db)
+			}
+			// The noise is scaled by the same factor
+			if got, want := peak(render(noise, tt.db)), noiseReference*dbToLinear(-tt.db); math.Abs(got-want) > 1e-9 {
+				t.Errorf("noise peaks at %.4f, want %.4f", got, want)
+			}
+		})
+	}
+	if math.Abs(dbToLinear(-defaultHeadroomDB)-0.5) > 1e-12 {
+		t.Errorf("default headroom scales by %v, want the original 0.5", dbToLinear(-defaultHeadroomDB))
+	}
+}
+
+func TestLimitBeatSlope(t *testing.T) {
+	changes := []ConfigFrequencyChange{
+		{Time: 0, Frequency: 200, BeatFrequency: 10},
+		{Time: 2, Frequency: 200, BeatFrequency: 4},                           // 3 Hz/s, lengthened to 12 s
+		{Time: 10, Frequency: 200, BeatFrequency: 4, Easing: easingEaseInOut}, // Hold, unchanged
+		{Time: 12, Frequency: 200, BeatFrequency: 6},                          // Eased 2 Hz in 2 s, lengthened to 12 s
+		{Time: 30, Frequency: 200, BeatFrequency: 7},                          // 1 Hz in 18 s, already gentle
+	}
+	limited, stretched, added := limitBeatSlope(changes, 0.5)
+
+	var times []float64
+	for _, c := range limited {
+		times = append(times, c.Time)
+	}
+	if want := []float64{0, 12, 20, 32, 50}; !reflect.DeepEqual(times, want) {
+		t.Errorf("limited times = %v, want %v", times, want)
+	}
+	if stretched != 2 || added != 20 {
+		t.Errorf("limitBeatSlope lengthened %d transitions by %v s, want 2 by 20 s", stretched, added)
+	}
+	if changes[1].Time != 2 {
+		t.Errorf("limitBeatSlope changed its input: second change at %v s", changes[1].Time)
+	}
+
+	// The steepest slope of the limited beat, eased transition included, is at the limit
+	beat := createBeatFreqFunc(limited)
+	steepest := 0.0
+	const step = 0.01
+	for at := 0.0; at < 50; at += step {
+		steepest = math.Max(steepest, math.Abs(beat(at+step)-beat(at))/step)
+	}
+	if math.Abs(steepest-0.5) > 0.01 {
+		t.Errorf("steepest beat slope = %.3f Hz/s, want 0.5", steepest)
+	}
+}
+
+func TestHoldLastExtendsSession(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.2, pink_noise_volume: 0}
+  - {time: 1, frequency: 300, beat_frequency: 20, tone_volume: 0.4, pink_noise_volume: 0}
+`)
+	plain := renderFrames(t, cfg, testSampleRate, testOptions())
+	cfg.FrequencyChanges = holdLastChange(cfg.FrequencyChanges, 2)
+	held := renderFrames(t, cfg, testSampleRate, testOptions())
+
+	if got, want := len(held)-len(plain), testSampleRate.N(2*time.Second); got != want {
+		t.Fatalf("-hold-last 2 added %d frames, want %d", got, want)
+	}
+	// Each second of the hold plays the last change's tones at the level they have in a session
+	// that stays at its values
+	steady := renderFrames(t, mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 300, beat_frequency: 20, tone_volume: 0.4, pink_noise_volume: 0}
+  - {time: 1, frequency: 300, beat_frequency: 20, tone_volume: 0.4, pink_noise_volume: 0}
+`), testSampleRate, testOptions())
+	want := toneAmplitude(channel(steady, 0), testSampleRate, 300)
+	second := testSampleRate.N(time.Second)
+	for start := len(plain); start < len(held); start += second {
+		frames := held[start : start+second]
+		left, right := toneAmplitude(channel(frames, 0), testSampleRate, 300), toneAmplitude(channel(frames, 1), testSampleRate, 320)
+		if math.Abs(left-want) > 1e-3 || math.Abs(right-want) > 1e-3 {
+			t.Errorf("hold at %.0f s has tones of %.4f and %.4f, want the last change's %.4f", float64(start)/float64(testSampleRate), left, right, want)
+		}
+	}
+	if got := createVolumeFunc(cfg.FrequencyChanges)(2.5); got != 0.4 {
+		t.Errorf("tone volume during the hold = %v, want 0.4", got)
+	}
+}
+
+func TestBeatExactDuringCarrierSweep(t *testing.T) {
+	// The carrier sweeps at 400 Hz/s under a 7 Hz beat
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 7, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 2, frequency: 1000, beat_frequency: 7, tone_volume: 0.5, pink_noise_volume: 0}
+`)
+	frames := renderFrames(t, cfg, testSampleRate, testOptions())
+
+	// The product of the channels is the cosine of their phase difference plus a term at the sum
+	// of their frequencies, which a 5 ms average mostly removes. The difference passes through
+	// each of ±30% of its swing once per beat cycle, so with a 7 Hz beat the crossings, counted
+	// on alternate sides so the leftover ripple can't add more, are 1/14 s apart
+	window := testSampleRate.N(5 * time.Millisecond)
+	averaged := make([]float64, len(frames)-window)
+	var sum, swing float64
+	for i, f := range frames {
+		sum += f[0] * f[1]
+		if i >= window {
+			sum -= frames[i-window][0] * frames[i-window][1]
+			averaged[i-window] = sum
+			swing = math.Max(swing, math.Abs(sum))
+		}
+	}
+	var crossings []float64
+	above := averaged[0] > 0
+	for i, v := range averaged {
+		if above && v < -0.3*swing || !above && v > 0.3*swing {
+			above = !above
+			crossings = append(crossings, float64(i)/float64(testSampleRate))
+		}
+	}
+	if len(crossings) < 2 {
+		t.Fatalf("found %d zero crossings of the beat, want about 28", len(crossings))
+	}
+	for i := 1; i < len(crossings); i++ {
+		if gap := crossings[i] - crossings[i-1]; math.Abs(gap-1.0/14) > 0.002 {
+			t.Errorf("beat zero crossings at %.4f s and %.4f s are %.4f s apart, want %.4f", crossings[i-1], crossings[i], gap, 1.0/14)
+		}
+	}
+	if got := float64(len(crossings)-1) / (crossings[len(crossings)-1] - crossings[0]) / 2; math.Abs(got-7) > 0.01 {
+		t.Errorf("measured beat during the sweep = %.4f Hz, want 7", got)
+	}
+}
+
+func TestAttackRampsToneOnset(t *testing.T) {
+	// The tone is silent for a second and then switches straight on
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0}
+`)
+	onset := testSampleRate.N(time.Second)
+	window := testSampleRate.N(5 * time.Millisecond) // One period of the 200 Hz tone
+	peaks := func(attack float64) []float64 {
+		opts := testOptions()
+		opts.AttackTime = attack
+		frames := renderFrames(t, cfg, testSampleRate, opts)
+		var peaks []float64
+		for start := onset; start < onset+4*window; start += window {
+			peak := 0.0
+			for _, f := range frames[start : start+window] {
+				peak = math.Max(peak, math.Abs(f[0]))
+			}
+			peaks = append(peaks, peak)
+		}
+		return peaks
+	}
+
+	jump, ramp := peaks(0), peaks(0.01)
+	full := jump[3]
+	if jump[0] < 0.95*full {
+		t.Errorf("without an attack the first period peaks at %.4f, want the full %.4f", jump[0], full)
+	}
+	// Over the 10 ms attack the peak of each period rises, reaching the full level after it
+	if ramp[0] > 0.5*full || ramp[1] <= ramp[0] || ramp[1] > full {
+		t.Errorf("periods of the attack peak at %.4f and %.4f, want a rise from below %.4f", ramp[0], ramp[1], 0.5*full)
+	}
+	if math.Abs(ramp[2]-full) > 0.01*full || math.Abs(ramp[3]-full) > 0.01*full {
+		t.Errorf("periods after the attack peak at %.4f and %.4f, want the full %.4f", ramp[2], ramp[3], full)
+	}
+}
+
+func TestZeroBeatKeepsChannelsIdentical(t *testing.T) {
+	// Five minutes of a sweeping carrier with no beat
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 0, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 150, frequency: 437.3, beat_frequency: 0, tone_volume: 0.3, pink_noise_volume: 0}
+  - {time: 300, frequency: 111.1, beat_frequency: 0, tone_volume: 0.5, pink_noise_volume: 0}
+`)
+	if hasBeat(cfg.FrequencyChanges) {
+		t.Error("hasBeat = true for a session with a 0 Hz beat throughout")
+	}
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Streamed rather than rendered, so the long session isn't held in memory
+	buf := make([][2]float64, 4096)
+	var frames int
+	for {
+		n, ok := session.streamer.Stream(buf)
+		for i, f := range buf[:n] {
+			if f[0] != f[1] {
+				t.Fatalf("channels differ at %.3f s: %v and %v", float64(frames+i)/float64(testSampleRate), f[0], f[1])
+			}
+		}
+		frames += n
+		if !ok {
+			break
+		}
+	}
+	if want := testSampleRate.N(300 * time.Second); frames != want {
+		t.Errorf("rendered %d frames, want %d", frames, want)
+	}
+
+	if !hasBeat([]ConfigFrequencyChange{{BeatFrequency: 0}, {BeatFrequency: 0.01}}) {
+		t.Error("hasBeat = false for a session with a 0.01 Hz beat")
+	}
+}
+
+func TestTargetDurationSetsLength(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.3, pink_noise_volume: 0.1}
+  - {time: 1, frequency: 250, beat_frequency: 8, tone_volume: 0.3, pink_noise_volume: 0.1}
+  - {time: 3, frequency: 250, beat_frequency: 8, tone_volume: 0.3, pink_noise_volume: 0.1}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args   []string
+		length float64 // Seconds
+	}{
+		{[]string{"-target-duration", "7.3"}, 7.3},
+		{[]string{"-target-duration", "1.25"}, 1.25},
+		{[]string{"-target-duration", "4.5", "-stretch", "3"}, 4.5}, // Overrides -stretch
+		{[]string{"-stretch", "2"}, 6},
+	}
+	for _, tt := range tests {
+		output := filepath.Join(dir, "session.wav")
+		runMain(t, append([]string{"-config", config, "-seed", "1", "-output", output}, tt.args...)...)
+		format, frames := readTestWAV(t, output)
+		if want := tt.length * float64(format.SampleRate); math.Abs(float64(len(frames))-want) > 1 {
+			t.Errorf("%v rendered %d frames, want %.0f (%.2f s)", tt.args, len(frames), want, tt.length)
+		}
+	}
+}
+
+func TestLeftRightOnGateEachTone(t *testing.T) {
+	// Both tones, then only the left, then only the right, then both: settings hold until a later
+	// change sets them
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 20, tone_volume: 0.5, pink_noise_volume: 0}
+  - {time: 1, frequency: 200, beat_frequency: 20, tone_volume: 0.5, pink_noise_volume: 0, right_on: false}
+  - {time: 2, frequency: 200, beat_frequency: 20, tone_volume: 0.5, pink_noise_volume: 0, left_on: false, right_on: true}
+  - {time: 3, frequency: 200, beat_frequency: 20, tone_volume: 0.5, pink_noise_volume: 0, left_on: true}
+  - {time: 4, frequency: 200, beat_frequency: 20, tone_volume: 0.5, pink_noise_volume: 0}
+`)
+	frames := renderFrames(t, cfg, testSampleRate, testOptions())
+	full := toneAmplitude(channel(frames[:testSampleRate.N(time.Second)], 0), testSampleRate, 200)
+	if full == 0 {
+		t.Fatal("left tone is silent in the first segment")
+	}
+	tests := []struct {
+		left, right bool
+	}{{true, true}, {true, false}, {false, true}, {true, true}}
+	second := testSampleRate.N(time.Second)
+	for i, tt := range tests {
+		// The middle of each segment, clear of the attack ramp at its start
+		segment := frames[i*second+second/10 : (i+1)*second]
+		for c, on := range []bool{tt.left, tt.right} {
+			freq := []float64{200, 220}[c]
+			got := toneAmplitude(channel(segment, c), testSampleRate, freq)
+			if on && math.Abs(got-full) > 0.01*full || !on && rmsOf(channel(segment, c)) > 1e-9 {
+				t.Errorf("segment %d: %s channel has a %.0f Hz tone of %.4f (RMS %.4f), want on = %v", i+1, []string{"left", "right"}[c], freq, got, rmsOf(channel(segment, c)), on)
+			}
+		}
+	}
+}
+
+func TestNoisePanTracksWaypoints(t *testing.T) {
+	const session = `
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: 0.5%s}
+  - {time: 2, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: 0.5%s}
+  - {time: 3, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: 0.5%s}
+`
+	centered := renderFrames(t, mustParseConfig(t, fmt.Sprintf(session, "", "", "")), testSampleRate, testOptions())
+	panned := renderFrames(t, mustParseConfig(t, fmt.Sprintf(session, ", pink_noise_pan: -1", ", pink_noise_pan: 0.5", ", pink_noise_pan: 0.5")), testSampleRate, testOptions())
+
+	// The noise is the same in both renders, so each channel's ratio to the centered noise is
+	// its pan gain
+	tests := []struct {
+		at, pan float64
+	}{
+		{0, -1},
+		{0.5, -0.625},
+		{1, -0.25},
+		{1.6, 0.2},
+		{2.5, 0.5}, // Held after the last change to the pan
+	}
+	for _, tt := range tests {
+		i := testSampleRate.N(time.Duration(tt.at * float64(time.Second)))
+		for i < len(centered) && (math.Abs(centered[i][0]) < 1e-3 || math.Abs(centered[i][1]) < 1e-3) {
+			i++
+		}
+		wantLeft, wantRight := equalPowerGains(tt.pan)
+		left, right := panned[i][0]/centered[i][0], panned[i][1]/centered[i][1]
+		if math.Abs(left-wantLeft) > 1e-3 || math.Abs(right-wantRight) > 1e-3 {
+			t.Errorf("noise gains at %.2f s = %.4f, %.4f, want pan %v's %.4f, %.4f", tt.at, left, right, tt.pan, wantLeft, wantRight)
+		}
+	}
+	if createNoisePanFunc(mustParseConfig(t, fmt.Sprintf(session, "", "", "")).FrequencyChanges) != nil {
+		t.Error("a session without pink_noise_pan has a pan function, want the noise left unpanned")
+	}
+}
+
+func TestDetuneAddsSecondPeak(t *testing.T) {
+	render := func(detune float64) [][2]float64 {
+		return renderFrames(t, mustParseConfig(t, fmt.Sprintf(`
+frequency_changes:
+  - {time: 0, frequency: 400, beat_frequency: 10, tone_volume: 0.6, pink_noise_volume: 0, detune_cents: %[1]v}
+  - {time: 2, frequency: 400, beat_frequency: 10, tone_volume: 0.6, pink_noise_volume: 0, detune_cents: %[1]v}
+`, detune)), testSampleRate, testOptions())
+	}
+	plain, detuned := channel(render(0), 0), channel(render(50), 0)
+	copyFreq := 400 * math.Pow(2, 50.0/1200) // About 411.8 Hz
+
+	tone := toneAmplitude(plain, testSampleRate, 400)
+	if got := toneAmplitude(plain, testSampleRate, copyFreq); got > 0.05*tone { // Allowing for leakage
+		t.Errorf("without detune there is a %.4f peak at %.1f Hz, want none", got, copyFreq)
+	}
+	// The copy plays at half the tone's level, and both are scaled so they peak at the tone alone
+	if got, want := toneAmplitude(detuned, testSampleRate, 400), tone/1.5; math.Abs(got-want) > 0.02*want {
+		t.Errorf("detuned carrier peak = %.4f, want %.4f", got, want)
+	}
+	if got, want := toneAmplitude(detuned, testSampleRate, copyFreq), tone*0.5/1.5; math.Abs(got-want) > 0.05*want {
+		t.Errorf("detuned copy peak at %.1f Hz = %.4f, want %.4f", copyFreq, got, want)
+	}
+	var peak float64
+	for _, v := range detuned {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak > tone*1.001 {
+		t.Errorf("detuned tone peaks at %.4f, above the plain tone's %.4f", peak, tone)
+	}
+}
+
+func TestNoiseWarmupSettlesEarlySamples(t *testing.T) {
+	if got := defaultSessionOptions(1).NoiseWarmup; got != defaultNoiseWarmup || got == 0 {
+		t.Errorf("default noise warmup = %d samples, want %d", got, defaultNoiseWarmup)
+	}
+
+	// meanSquares returns the mean square of the first 16 samples and of a later stretch of
+	// settled noise, averaged over many seeds
+	meanSquares := func(warmup int) (early, settled float64) {
+		const seeds = 500
+		buf := make([][2]float64, 1024)
+		for seed := int64(1); seed <= seeds; seed++ {
+			pn := NewPinkNoise(seed)
+			pn.Warmup(warmup)
+			pn.Stream(buf)
+			for i, f := range buf {
+				if i < 16 {
+					early += f[0] * f[0] / 16
+				} else if i >= 512 {
+					settled += f[0] * f[0] / 512
+				}
+			}
+		}
+		return early / seeds, settled / seeds
+	}
+
+	// From zeroed rows the first samples are quieter than settled noise; after a warmup they
+	// aren't
+	early, settled := meanSquares(0)
+	if ratio := early / settled; ratio > 0.8 {
+		t.Errorf("without warmup the first samples have %.2f of the settled power, want them quieter", ratio)
+	}
+	early, settled = meanSquares(defaultNoiseWarmup)
+	if ratio := early / settled; math.Abs(ratio-1) > 0.1 {
+		t.Errorf("after the default %d-sample warmup the first samples have %.2f of the settled power, want 1", defaultNoiseWarmup, ratio)
+	}
+}
+
+func TestConfigB64MatchesFile(t *testing.T) {
+	tests := []struct {
+		name, config string
+	}{
+		{"yaml", `
+title: Inline
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2, label: Alpha}
+  - {time: 1, frequency: 300, beat_frequency: 6, tone_volume: 0.4, pink_noise_volume: 0.1}
+`},
+		{"json", `{"mode": "monaural", "frequency_changes": [
+  {"time": 0, "frequency": 150, "beat_frequency": 4, "tone_volume": 0.3},
+  {"time": 2, "frequency": 150, "beat_frequency": 4, "tone_volume": 0.3}
+]}`},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want, err := parseConfig(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded := base64.StdEncoding.EncodeToString([]byte(tt.config))
+			// base64 wraps its output at 76 columns, which is ignored
+			var wrapped strings.Builder
+			for len(encoded) > 76 {
+				wrapped.WriteString(encoded[:76] + "\n")
+				encoded = encoded[76:]
+			}
+			wrapped.WriteString(encoded + "\n")
+			got, err := parseConfigB64(wrapped.String(), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("-config-b64 parsed to %+v, want the file's %+v", got, want)
+			}
+		})
+	}
+
+	if _, err := parseConfigB64("not base64!", false); err == nil || !strings.Contains(err.Error(), "decoding base64") {
+		t.Errorf("invalid base64 gave %v, want a decoding error", err)
+	}
+	if _, err := parseConfigB64(base64.StdEncoding.EncodeToString([]byte("frequency_changes: [{time: 0, frequncy: 200}]")), true); err == nil {
+		t.Error("strict parsing accepted an unknown field in -config-b64")
+	}
+
+	// The command line renders the same audio from either
+	config := filepath.Join(dir, "yaml")
+	fromFile, fromB64 := filepath.Join(dir, "file.wav"), filepath.Join(dir, "b64.wav")
+	runMain(t, "-config", config, "-seed", "1", "-output", fromFile)
+	runMain(t, "-config-b64", base64.StdEncoding.EncodeToString([]byte(tests[0].config)), "-seed", "1", "-output", fromB64)
+	a, err := os.ReadFile(fromFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fromB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("-config-b64 rendered a different file from -config")
+	}
+}
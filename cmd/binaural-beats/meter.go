@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// peakHoldTime is how long the playback meter holds a peak before it starts to decay. It
+// matches the status interval, so every peak is shown at least once.
+const peakHoldTime = 3 * time.Second
+
+// peakDecayRate is how fast the playback meter falls after the hold time, in dB per second.
+const peakDecayRate = 20.0
+
+// peakMeter passes samples through unchanged while keeping a peak-hold level for each channel.
+// Levels can be read from another goroutine while the meter is streamed.
+type peakMeter struct {
+	stream beep.Streamer
+	sr     beep.SampleRate
+
+	mu   sync.Mutex
+	peak [2]float64 // Held peak of each channel
+	age  [2]int     // Frames since each peak was set
+}
+
+// Stream measures the samples streamed from the wrapped streamer.
+func (pm *peakMeter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = pm.stream.Stream(samples)
+
+	var chunk [2]float64
+	for _, sample := range samples[:n] {
+		chunk[0] = math.Max(chunk[0], math.Abs(sample[0]))
+		chunk[1] = math.Max(chunk[1], math.Abs(sample[1]))
+	}
+
+	pm.mu.Lock()
+	for c := range pm.peak {
+		pm.peak[c], pm.age[c] = pm.update(pm.peak[c], pm.age[c], chunk[c], n)
+	}
+	pm.mu.Unlock()
+	return n, ok
+}
+
+// update returns the held peak and its age after n more frames whose peak is chunk. A louder
+// chunk replaces the held peak; otherwise it decays once it is older than the hold time.
+func (pm *peakMeter) update(peak float64, age int, chunk float64, n int) (float64, int) {
+	age += n
+	if hold := pm.sr.N(peakHoldTime); age > hold {
+		decayed := age - hold
+		if decayed > n {
+			decayed = n
+		}
+		peak *= dbToLinear(-peakDecayRate * float64(decayed) / float64(pm.sr))
+	}
+	if chunk >= peak {
+		return chunk, 0
+	}
+	return peak, age
+}
+
+// Err returns the error state of the wrapped stream.
+func (pm *peakMeter) Err() error {
+	return pm.stream.Err()
+}
+
+// levels returns the held peak of the left and right channels in dBFS.
+func (pm *peakMeter) levels() (left, right float64) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return linearToDB(pm.peak[0]), linearToDB(pm.peak[1])
+}
+
+// formatPeakLevel formats a meter level in dBFS, marking levels at full scale as clipping.
+func formatPeakLevel(db float64) string {
+	switch {
+	case db <= -999:
+		return "-inf dB"
+	case db >= 0:
+		return fmt.Sprintf("%.1f dB (clipping)", db)
+	}
+	return fmt.Sprintf("%.1f dB", db)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+func TestPeakMeterHoldAndDecay(t *testing.T) {
+	// A 10 ms burst at half scale in the left channel, then silence
+	burst := testSampleRate.N(10 * time.Millisecond)
+	pos := 0
+	source := beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		for i := range samples {
+			samples[i] = [2]float64{}
+			if pos < burst {
+				samples[i][0] = 0.5
+			}
+			pos++
+		}
+		return len(samples), true
+	})
+	pm := &peakMeter{stream: source, sr: testSampleRate}
+	samples := make([][2]float64, burst)
+	advance := func(d time.Duration) {
+		for n := testSampleRate.N(d); n > 0; n -= len(samples) {
+			pm.Stream(samples)
+		}
+	}
+
+	pm.Stream(samples)
+	if samples[0][0] != 0.5 || samples[0][1] != 0 {
+		t.Fatalf("meter changed the samples to %v", samples[0])
+	}
+	peak := linearToDB(0.5)
+	steps := []struct {
+		name  string
+		after time.Duration // Further time streamed before the check
+		want  float64       // Expected left level in dBFS
+	}{
+		{"at the burst", 0, peak},
+		{"within the hold time", 2 * time.Second, peak},
+		{"at the end of the hold time", time.Second, peak},
+		{"a second into the decay", time.Second, peak - peakDecayRate},
+		{"two seconds into the decay", time.Second, peak - 2*peakDecayRate},
+	}
+	for _, step := range steps {
+		advance(step.after)
+		left, right := pm.levels()
+		// The age counts from the end of the burst chunk, so allow one chunk of decay
+		if tolerance := peakDecayRate * float64(burst) / float64(testSampleRate); math.Abs(left-step.want) > tolerance+1e-9 {
+			t.Errorf("%s: left level = %.2f dB, want %.2f dB", step.name, left, step.want)
+		}
+		if right != -999 {
+			t.Errorf("%s: right level = %.2f dB, want silence", step.name, right)
+		}
+	}
+
+	// A louder chunk replaces the decayed peak at once
+	pm.stream = beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		for i := range samples {
+			samples[i] = [2]float64{0.1, 1}
+		}
+		return len(samples), true
+	})
+	pm.Stream(samples)
+	if left, right := pm.levels(); math.Abs(left-linearToDB(0.1)) > 1e-9 || right != 0 {
+		t.Errorf("levels after a louder chunk = %.2f, %.2f dB, want %.2f, 0 dB", left, right, linearToDB(0.1))
+	}
+}
+
+func TestFormatPeakLevel(t *testing.T) {
+	tests := []struct {
+		db   float64
+		want string
+	}{
+		{-999, "-inf dB"},
+		{-12.34, "-12.3 dB"},
+		{0, "0.0 dB (clipping)"},
+		{1.5, "1.5 dB (clipping)"},
+	}
+	for _, tt := range tests {
+		if got := formatPeakLevel(tt.db); got != tt.want {
+			t.Errorf("formatPeakLevel(%v) = %q, want %q", tt.db, got, tt.want)
+		}
+	}
+}
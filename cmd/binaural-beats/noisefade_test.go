@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+func TestSteppedNoiseTogglesAtItsChange(t *testing.T) {
+	const sr = beep.SampleRate(8000)
+	tests := []struct {
+		name          string
+		before, after float64 // Noise volumes either side of the change at 1.5 s
+	}{
+		{"off", 0.5, 0},
+		{"on", 0, 0.5},
+	}
+	change := sr.N(1500 * time.Millisecond)
+	window := sr.N(50 * time.Millisecond)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfigData([]byte(fmt.Sprintf(`
+noise_fade_curve: step
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: %[1]v}
+  - {time: 1.5, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: %[2]v}
+  - {time: 3, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: %[2]v}
+`, tt.before, tt.after)), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := newSession(cfg, sr, SessionOptions{Seed: 1, Headroom: defaultHeadroomDB})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			frames := make([][2]float64, session.totalSamples)
+			if n, _ := session.streamer.Stream(frames); n != len(frames) {
+				t.Fatalf("streamed %d samples, want %d", n, len(frames))
+			}
+
+			// The noise keeps its earlier setting right up to the change and switches on its sample
+			for _, side := range []struct {
+				name   string
+				frames [][2]float64
+				volume float64
+			}{
+				{"before", frames[change-window : change], tt.before},
+				{"after", frames[change : change+window], tt.after},
+				{"well before", frames[change/2 : change/2+window], tt.before},
+			} {
+				var sum float64
+				for _, f := range side.frames {
+					sum += f[0] * f[0]
+				}
+				rms := math.Sqrt(sum / float64(len(side.frames)))
+				if side.volume == 0 && rms != 0 || side.volume > 0 && rms < 0.01 {
+					t.Errorf("noise RMS %s the change = %.4f, want volume %v", side.name, rms, side.volume)
+				}
+			}
+			if got := frames[change-1][0]; tt.before == 0 && got != 0 {
+				t.Errorf("sample before the change = %v, want silence", got)
+			}
+			if got := frames[change][0]; tt.after == 0 && got != 0 {
+				t.Errorf("sample at the change = %v, want silence", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/gopxl/beep"
+)
+
+// NoiseLayer is a band of filtered noise added to the noise bed, such as a low rumble or a
+// high hiss.
+type NoiseLayer struct {
+	Type   string  `yaml:"type"`   // Noise filtered into the band: "pink" (default) or "white"
+	Center float64 `yaml:"center"` // Center frequency of the band-pass filter in Hz
+	Width  float64 `yaml:"width"`  // Bandwidth of the band-pass filter in Hz
+	Volume float64 `yaml:"volume"` // Volume of the layer (0.0 to 1.0) where a change doesn't set one
+}
+
+// Noise layer types.
+const (
+	noiseLayerPink  = "pink"
+	noiseLayerWhite = "white"
+)
+
+// whiteNoiseAmplitude scales the white noise of a layer so it peaks at ±0.5, like pink noise.
+const whiteNoiseAmplitude = 0.5
+
+// noiseLayerSeed is added to the session seed, along with the layer's index, to seed each
+// layer's noise apart from the noise bed and the other layers.
+const noiseLayerSeed = 5
+
+// validateNoiseLayers checks the noise layers of cfg and the layer volumes of its changes.
+func validateNoiseLayers(cfg *Config) error {
+	for i, layer := range cfg.NoiseLayers {
+		switch {
+		case layer.Type != "" && layer.Type != noiseLayerPink && layer.Type != noiseLayerWhite:
+			return fmt.Errorf("unknown type %q of noise layer %d (expected %q or %q)", layer.Type, i+1, noiseLayerPink, noiseLayerWhite)
+		case layer.Center <= 0:
+			return fmt.Errorf("noise layer %d needs a center frequency above 0 Hz", i+1)
+		case layer.Width <= 0:
+			return fmt.Errorf("noise layer %d needs a width above 0 Hz", i+1)
+		case layer.Volume < 0 || layer.Volume > 1:
+			return fmt.Errorf("volume %.2f of noise layer %d is outside 0 to 1", layer.Volume, i+1)
+		}
+	}
+	for _, change := range cfg.FrequencyChanges {
+		if len(change.NoiseLayerVolumes) > len(cfg.NoiseLayers) {
+			return fmt.Errorf("noise_layer_volumes at time %.2f has %d volumes but there are %d noise layers", change.Time, len(change.NoiseLayerVolumes), len(cfg.NoiseLayers))
+		}
+		for _, v := range change.NoiseLayerVolumes {
+			if v < 0 || v > 1 {
+				return fmt.Errorf("noise layer volume %.2f at time %.2f is outside 0 to 1", v, change.Time)
+			}
+		}
+	}
+	return nil
+}
+
+// createNoiseLayerVolumeFunc creates a function that returns the volume of the layer at index
+// at time t. A change without a volume for the layer uses the layer's own. Like the pink noise
+// volume, it is blended with curve and clamped to the 0 to 1 range.
+func createNoiseLayerVolumeFunc(changes []ConfigFrequencyChange, index int, layer NoiseLayer, curve string) func(t float64) float64 {
+	blend := linearBlend
+	switch curve {
+	case noiseFadeEqualPower:
+		blend = equalPowerBlend
+	case noiseFadeStep:
+		blend = stepBlend
+	}
+	field := func(c ConfigFrequencyChange) float64 {
+		if index < len(c.NoiseLayerVolumes) {
+			return c.NoiseLayerVolumes[index]
+		}
+		return layer.Volume
+	}
+	return func(t float64) float64 {
+		if len(changes) == 0 {
+			return 0.0
+		}
+		return clampVolume(interpolateChangesWith(changes, t, field, blend))
+	}
+}
+
+// newNoiseLayers returns a streamer for each noise layer of cfg. Each layer is gated by the
+// pink noise volume, so it fades in and out with the noise bed, and is mixed with the same
+// pan, headroom and smoothing. Pink noise layers are warmed up by warmup samples, like the bed.
+func newNoiseLayers(cfg *Config, sr beep.SampleRate, seed int64, warmup int, pinkNoiseFunc, panFunc func(t float64) float64, headroom, smoothing float64) ([]beep.Streamer, error) {
+	var layers []beep.Streamer
+	for i, layer := range cfg.NoiseLayers {
+		if layer.Center >= float64(sr)/2 {
+			return nil, fmt.Errorf("noise layer %d is centered at %.0f Hz, at or above the Nyquist frequency of %.0f Hz", i+1, layer.Center, float64(sr)/2)
+		}
+
+		layerSeed := seed + noiseLayerSeed + int64(i)
+		var source beep.Streamer
+		switch {
+		case layer.Type == noiseLayerWhite:
+			source = NewWhiteNoise(layerSeed, cfg.StereoNoise)
+		case cfg.StereoNoise:
+			noise := NewStereoPinkNoise(layerSeed)
+			noise.Warmup(warmup)
+			source = noise
+		default:
+			noise := NewPinkNoise(layerSeed)
+			noise.Warmup(warmup)
+			source = noise
+		}
+
+		volumeFunc := createNoiseLayerVolumeFunc(cfg.FrequencyChanges, i, layer, cfg.NoiseFadeCurve)
+		layers = append(layers, &PinkNoiseControl{
+			stream: NewBandPassFilter(source, sr, layer.Center, layer.Width),
+			volumeFunc: func(t float64) float64 {
+				return volumeFunc(t) * pinkNoiseFunc(t)
+			},
+			headroom:  headroom,
+			smoothing: smoothing,
+			panFunc:   panFunc,
+			sr:        sr,
+			pos:       0,
+		})
+	}
+	return layers, nil
+}
+
+// WhiteNoise generates uniform white noise, the same in both channels or independent in each.
+type WhiteNoise struct {
+	left  *rand.Rand
+	right *rand.Rand // nil when both channels carry the left channel's noise
+}
+
+// NewWhiteNoise creates a WhiteNoise generator seeded with seed. With stereo, the right channel
+// is seeded with a seed derived from it.
+func NewWhiteNoise(seed int64, stereo bool) *WhiteNoise {
+	wn := &WhiteNoise{left: rand.New(rand.NewSource(seed))}
+	if stereo {
+		wn.right = rand.New(rand.NewSource(rightChannelSeed(seed)))
+	}
+	return wn
+}
+
+// Stream generates white noise samples.
+func (wn *WhiteNoise) Stream(samples [][2]float64) (n int, ok bool) {
+	for i := range samples {
+		left := (wn.left.Float64()*2 - 1) * whiteNoiseAmplitude
+		right := left
+		if wn.right != nil {
+			right = (wn.right.Float64()*2 - 1) * whiteNoiseAmplitude
+		}
+		samples[i] = [2]float64{left, right}
+	}
+	return len(samples), true
+}
+
+// Err returns nil, as WhiteNoise doesn't produce any errors.
+func (wn *WhiteNoise) Err() error {
+	return nil
+}
+
+// BandPassFilter passes the band of a stream around a center frequency with a second-order
+// band-pass filter, with a gain of 1 at the center.
+type BandPassFilter struct {
+	stream beep.Streamer
+	b0, b2 float64    // Feed-forward coefficients; b1 is 0
+	a1, a2 float64    // Feedback coefficients, normalized by a0
+	x1, x2 [2]float64 // Previous two inputs per channel
+	y1, y2 [2]float64 // Previous two outputs per channel
+}
+
+// NewBandPassFilter returns a filter passing width Hz of stream around center Hz, using the
+// band-pass biquad of the Audio EQ Cookbook.
+func NewBandPassFilter(stream beep.Streamer, sr beep.SampleRate, center, width float64) *BandPassFilter {
+	w0 := 2 * math.Pi * center / float64(sr)
+	q := center / width
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha
+	return &BandPassFilter{
+		stream: stream,
+		b0:     alpha / a0,
+		b2:     -alpha / a0,
+		a1:     -2 * math.Cos(w0) / a0,
+		a2:     (1 - alpha) / a0,
+	}
+}
+
+// Stream filters the samples of the stream.
+func (bpf *BandPassFilter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = bpf.stream.Stream(samples)
+	for i := range samples[:n] {
+		for c := 0; c < 2; c++ {
+			x := samples[i][c]
+			y := bpf.b0*x + bpf.b2*bpf.x2[c] - bpf.a1*bpf.y1[c] - bpf.a2*bpf.y2[c]
+			bpf.x2[c], bpf.x1[c] = bpf.x1[c], x
+			bpf.y2[c], bpf.y1[c] = bpf.y1[c], y
+			samples[i][c] = y
+		}
+	}
+	return n, ok
+}
+
+// Err returns the error state of the stream.
+func (bpf *BandPassFilter) Err() error {
+	return bpf.stream.Err()
+}
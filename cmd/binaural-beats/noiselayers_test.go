@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNoiseLayersFillTheirBands(t *testing.T) {
+	const layers = `
+noise_layers:
+  - {type: pink, center: 150, width: 50, volume: 1}
+  - {type: white, center: 2000, width: 200, volume: 0.5}
+`
+	session := func(volumes string) string {
+		return `
+frequency_changes:
+  - {time: 0, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: 0.5` + volumes + `}
+  - {time: 3, frequency: 0, beat_frequency: 0, tone_volume: 0, pink_noise_volume: 0.5` + volumes + `}
+`
+	}
+	bed := renderFrames(t, mustParseConfig(t, session("")), testSampleRate, testOptions())
+
+	// The bed is the same noise in every render, so the difference is the layers alone. bandDB
+	// returns the power of the layers of a render within 25 Hz of each center, in dB
+	const size = 1024
+	bandDB := func(layered [][2]float64, centers ...float64) []float64 {
+		i := 0
+		power := averagePowerSpectrum(func() float64 {
+			v := layered[i][0] - bed[i][0]
+			i++
+			return v
+		}, size, len(bed)/size)
+		binHz := float64(testSampleRate) / size
+		var db []float64
+		for _, center := range centers {
+			var sum float64
+			for k := int((center - 25) / binHz); k <= int((center+25)/binHz); k++ {
+				sum += power[k]
+			}
+			db = append(db, 10*math.Log10(sum))
+		}
+		return db
+	}
+
+	// Both layers stand out of the gap between their bands
+	both := bandDB(renderFrames(t, mustParseConfig(t, layers+session("")), testSampleRate, testOptions()), 150, 2000, 800)
+	low, high, between := both[0], both[1], both[2]
+	if low-between < 20 || high-between < 20 {
+		t.Errorf("layer bands at 150 and 2000 Hz are %.1f and %.1f dB above the 800 Hz gap, want 20 dB", low-between, high-between)
+	}
+
+	// Each band comes from its own layer: silencing a layer through noise_layer_volumes empties
+	// its band and leaves the other
+	for _, tt := range []struct {
+		volumes       string
+		silent, heard int // Indices of the bands
+	}{
+		{", noise_layer_volumes: [0, 0.5]", 0, 1},
+		{", noise_layer_volumes: [1, 0]", 1, 0},
+	} {
+		one := bandDB(renderFrames(t, mustParseConfig(t, layers+session(tt.volumes)), testSampleRate, testOptions()), 150, 2000)
+		if drop := both[tt.silent] - one[tt.silent]; drop < 20 {
+			t.Errorf("with %s the silenced band drops by %.1f dB, want 20", tt.volumes, drop)
+		}
+		if diff := math.Abs(both[tt.heard] - one[tt.heard]); diff > 0.5 {
+			t.Errorf("with %s the other band changes by %.1f dB, want it unchanged", tt.volumes, diff)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gopxl/beep"
+)
+
+// measureSessionLevels synthesizes the session once, discarding the audio, and returns its
+// largest absolute sample value and its RMS level. Only splitChunkSize samples are held at a
+// time, so memory use does not grow with the session length. Synthesis is deterministic for a
+// given seed, so a second session built with the same options reproduces the measured audio
+// exactly.
+func measureSessionLevels(cfg *Config, sr beep.SampleRate, opts SessionOptions) (peak, rms float64, err error) {
+	opts.Gain = 1
+	session, err := newSession(cfg, sr, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer session.Close()
+
+	meter := &levelMeter{stream: session.streamer}
+	drain(meter)
+	return meter.peak, meter.rms(), meter.Err()
+}
+
+// normalizationGain returns the linear gain that brings peak to targetDB dBFS. A silent session
+// is left unchanged.
+func normalizationGain(peak, targetDB float64) float64 {
+	if peak == 0 {
+		return 1
+	}
+	return dbToLinear(targetDB) / math.Abs(peak)
+}
+
+// matchedGains returns the linear gains that bring sessions with the given RMS levels to the
+// same RMS, along with that level. A targetDB of 0 matches the quieter session, so neither is
+// made louder. A silent session is left unchanged.
+func matchedGains(rms [2]float64, targetDB float64) (gains [2]float64, target float64) {
+	target = math.Min(rms[0], rms[1])
+	if rms[0] == 0 {
+		target = rms[1]
+	} else if rms[1] == 0 {
+		target = rms[0]
+	}
+	if targetDB != 0 {
+		target = dbToLinear(targetDB)
+	}
+	for i, level := range rms {
+		gains[i] = 1
+		if level > 0 {
+			gains[i] = target / level
+		}
+	}
+	return gains, target
+}
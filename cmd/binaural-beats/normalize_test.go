@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizationGainMatchesMeasuredLevel(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.3, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 300, beat_frequency: 6, tone_volume: 0.6, pink_noise_volume: 0.4}
+  - {time: 2, frequency: 300, beat_frequency: 6, tone_volume: 0.2, pink_noise_volume: 0.1}
+`)
+	opts := testOptions()
+
+	// The streamed measurement matches the levels of the whole render held in memory
+	peak, rms, err := measureSessionLevels(cfg, testSampleRate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := renderFrames(t, cfg, testSampleRate, opts)
+	var wantPeak, sumSquares float64
+	for _, f := range frames {
+		for _, v := range f {
+			wantPeak = math.Max(wantPeak, math.Abs(v))
+			sumSquares += v * v
+		}
+	}
+	if peak != wantPeak {
+		t.Errorf("measured peak = %v, want the buffered render's %v", peak, wantPeak)
+	}
+	if wantRMS := math.Sqrt(sumSquares / float64(2*len(frames))); math.Abs(rms-wantRMS) > 1e-12 {
+		t.Errorf("measured RMS = %v, want the buffered render's %v", rms, wantRMS)
+	}
+
+	// Re-synthesizing with the gain brings the peak to the target
+	for _, target := range []float64{-1, -6, -20} {
+		opts.Gain = normalizationGain(peak, target)
+		var got float64
+		for _, f := range renderFrames(t, cfg, testSampleRate, opts) {
+			got = math.Max(got, math.Max(math.Abs(f[0]), math.Abs(f[1])))
+		}
+		if math.Abs(linearToDB(got)-target) > 1e-9 {
+			t.Errorf("normalized peak = %.6f dBFS, want %v dBFS", linearToDB(got), target)
+		}
+	}
+}
+
+func TestNormalizationGain(t *testing.T) {
+	tests := []struct {
+		peak, targetDB, want float64
+	}{
+		{0.5, 0, 2},
+		{1, -20, 0.1},
+		{-0.25, -6.0206, 2},
+		{0, -1, 1},
+	}
+	for _, tt := range tests {
+		if got := normalizationGain(tt.peak, tt.targetDB); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("normalizationGain(%v, %v) = %v, want %v", tt.peak, tt.targetDB, got, tt.want)
+		}
+	}
+}
+
+func TestMatchedGains(t *testing.T) {
+	tests := []struct {
+		rms        [2]float64
+		targetDB   float64
+		wantGains  [2]float64
+		wantTarget float64
+	}{
+		{[2]float64{0.2, 0.1}, 0, [2]float64{0.5, 1}, 0.1},
+		{[2]float64{0.05, 0.2}, 0, [2]float64{1, 0.25}, 0.05},
+		{[2]float64{0.2, 0.1}, -20, [2]float64{0.5, 1}, 0.1},
+		{[2]float64{0, 0.1}, 0, [2]float64{1, 1}, 0.1},
+		{[2]float64{0.4, 0.1}, -6.0206, [2]float64{1.25, 5}, 0.5},
+	}
+	for _, tt := range tests {
+		gains, target := matchedGains(tt.rms, tt.targetDB)
+		if math.Abs(target-tt.wantTarget) > 1e-4 || math.Abs(gains[0]-tt.wantGains[0]) > 1e-3 || math.Abs(gains[1]-tt.wantGains[1]) > 1e-3 {
+			t.Errorf("matchedGains(%v, %v) = %v, %v, want %v, %v", tt.rms, tt.targetDB, gains, target, tt.wantGains, tt.wantTarget)
+		}
+	}
+}
+
+func TestABRendersMatchRMS(t *testing.T) {
+	dir := t.TempDir()
+	configs := map[string]string{
+		"with_noise.yaml": `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.4, pink_noise_volume: 0.5}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.4, pink_noise_volume: 0.5}
+`,
+		"without_noise.yaml": `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.1, pink_noise_volume: 0}
+  - {time: 2, frequency: 200, beat_frequency: 10, tone_volume: 0.1, pink_noise_volume: 0}
+`,
+	}
+	for name, data := range configs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav")
+	levels := func() [2]float64 {
+		var levels [2]float64
+		for i, path := range []string{a, b} {
+			_, frames := readTestWAV(t, path)
+			levels[i] = math.Hypot(rmsOf(channel(frames, 0)), rmsOf(channel(frames, 1))) / math.Sqrt2
+		}
+		return levels
+	}
+	args := []string{"-ab", "-seed", "1", "-output", a + "," + b, filepath.Join(dir, "with_noise.yaml"), filepath.Join(dir, "without_noise.yaml")}
+
+	// Matched to the quieter render, which is left as it is
+	runMain(t, args...)
+	got := levels()
+	if diff := math.Abs(linearToDB(got[0]) - linearToDB(got[1])); diff > 0.1 {
+		t.Errorf("A/B renders are at %.2f and %.2f dBFS RMS, %.2f dB apart", linearToDB(got[0]), linearToDB(got[1]), diff)
+	}
+	plain := filepath.Join(dir, "plain.wav")
+	runMain(t, "-seed", "1", "-config", filepath.Join(dir, "without_noise.yaml"), "-output", plain)
+	_, frames := readTestWAV(t, plain)
+	if want := math.Hypot(rmsOf(channel(frames, 0)), rmsOf(channel(frames, 1))) / math.Sqrt2; math.Abs(linearToDB(got[1])-linearToDB(want)) > 0.01 {
+		t.Errorf("quieter render is at %.2f dBFS RMS, want it unchanged at %.2f", linearToDB(got[1]), linearToDB(want))
+	}
+
+	// Matched to -ab-rms
+	runMain(t, append([]string{"-ab-rms", "-26"}, args...)...)
+	for i, level := range levels() {
+		if math.Abs(linearToDB(level)+26) > 0.1 {
+			t.Errorf("render %d is at %.2f dBFS RMS, want -26", i+1, linearToDB(level))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// previewChartHeight is the number of plot rows in each chart drawn by -preview-frequencies.
+const previewChartHeight = 10
+
+// previewLabelWidth is the width of the value labels to the left of each chart.
+const previewLabelWidth = 9
+
+// terminalWidth returns the width of the terminal from $COLUMNS, or 80 if it isn't set.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// renderFrequencyPreview draws line charts of the base and beat frequency over the session,
+// each fitting in width columns.
+func renderFrequencyPreview(changes []ConfigFrequencyChange, width int) string {
+	total := getTotalPlaybackTime(changes)
+	return renderChart("Base frequency (Hz)", createFreqFunc(changes), total, width) + "\n" +
+		renderChart("Beat frequency (Hz)", createBeatFreqFunc(changes), total, width)
+}
+
+// renderChart draws f from 0 to total seconds as a chart of previewChartHeight rows. The value
+// axis is scaled to the range of f and the time axis is labelled at the start, middle and end.
+func renderChart(title string, f func(t float64) float64, total float64, width int) string {
+	columns := width - previewLabelWidth - 1
+	if columns < 10 {
+		columns = 10
+	}
+
+	// Sample f at the middle of each column
+	values := make([]float64, columns)
+	low, high := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		values[i] = f((float64(i) + 0.5) / float64(columns) * total)
+		low = math.Min(low, values[i])
+		high = math.Max(high, values[i])
+	}
+	if high == low {
+		// Give a constant a visible range so it is drawn in the middle
+		low, high = low-1, high+1
+	}
+
+	grid := make([][]rune, previewChartHeight)
+	for r := range grid {
+		grid[r] = []rune(strings.Repeat(" ", columns))
+	}
+	for i, v := range values {
+		row := int(math.Round((high - v) / (high - low) * float64(previewChartHeight-1)))
+		grid[row][i] = '*'
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, title)
+	for r, line := range grid {
+		label := ""
+		if r == 0 || r == previewChartHeight/2 || r == previewChartHeight-1 {
+			label = fmt.Sprintf("%.1f", high-float64(r)/float64(previewChartHeight-1)*(high-low))
+		}
+		fmt.Fprintf(&b, "%*s |%s\n", previewLabelWidth-1, label, strings.TrimRight(string(line), " "))
+	}
+	fmt.Fprintf(&b, "%*s +%s\n", previewLabelWidth-1, "", strings.Repeat("-", columns))
+
+	// Time labels at the start, middle and end of the axis
+	axis := []rune(strings.Repeat(" ", columns))
+	start, middle, end := formatPreviewTime(0, total), formatPreviewTime(total/2, total), formatPreviewTime(total, total)
+	copy(axis, []rune(start))
+	// Leave the middle label out of an axis too short to keep it clear of the others
+	if at := (columns - len(middle)) / 2; at > len(start) && at+len(middle) < columns-len(end) {
+		copy(axis[at:], []rune(middle))
+	}
+	copy(axis[columns-len(end):], []rune(end))
+	fmt.Fprintf(&b, "%*s  %s\n", previewLabelWidth-1, "", strings.TrimRight(string(axis), " "))
+	return b.String()
+}
+
+// formatPreviewTime formats seconds as m:ss, or in tenths of a second for sessions shorter
+// than a minute.
+func formatPreviewTime(seconds, total float64) string {
+	if total < 60 {
+		return fmt.Sprintf("%.1fs", seconds)
+	}
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
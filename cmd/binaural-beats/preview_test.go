@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderChartSize(t *testing.T) {
+	ramp := func(t float64) float64 { return 100 + t }
+	tests := []struct {
+		name    string
+		width   int
+		columns int  // Plot columns, one sample each
+		middle  bool // Whether the middle of the time axis is labelled
+	}{
+		{"narrow", 40, 30, true},
+		{"default", 80, 70, true},
+		{"wide", 132, 122, true},
+		{"too narrow", 12, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(renderChart("Ramp", ramp, 120, tt.width), "\n"), "\n")
+			// The title, the plot rows, the axis and the time labels
+			if want := previewChartHeight + 3; len(lines) != want {
+				t.Fatalf("chart has %d rows, want %d", len(lines), want)
+			}
+			axis := lines[previewChartHeight+1]
+			if got, want := utf8.RuneCountInString(axis), previewLabelWidth+1+tt.columns; got != want {
+				t.Errorf("axis is %d columns, want %d", got, want)
+			}
+			stars := 0
+			for _, line := range lines[1 : previewChartHeight+1] {
+				if n := utf8.RuneCountInString(line); n > len(axis) {
+					t.Errorf("plot row %q is wider than the axis", line)
+				}
+				stars += strings.Count(line, "*")
+			}
+			if stars != tt.columns {
+				t.Errorf("chart plots %d points, want one for each of the %d columns", stars, tt.columns)
+			}
+			if labels := lines[len(lines)-1]; !strings.Contains(labels, "0:00") || !strings.HasSuffix(labels, "2:00") {
+				t.Errorf("time axis is labelled %q, want 0:00 to 2:00", labels)
+			} else if strings.Contains(labels, "1:00") != tt.middle {
+				t.Errorf("time axis is labelled %q, want the middle labelled: %v", labels, tt.middle)
+			}
+		})
+	}
+}
+
+func TestRenderFrequencyPreview(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10}
+  - {time: 30, frequency: 100, beat_frequency: 4}
+`)
+	preview := renderFrequencyPreview(cfg.FrequencyChanges, 60)
+	for _, want := range []string{"Base frequency (Hz)", "Beat frequency (Hz)", "30.0s"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q:\n%s", want, preview)
+		}
+	}
+	if got, want := strings.Count(preview, "\n"), 2*(previewChartHeight+3)+1; got != want {
+		t.Errorf("preview has %d lines, want %d", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts a CPU profile written to cpuPath, if set, and returns a function that
+// stops it and writes a heap profile to memPath, if set. Either path may be empty.
+func startProfiling(cpuPath, memPath string) (stop func(), err error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		cpuFile, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				warnf("Error writing CPU profile: %v", err)
+			}
+		}
+
+		if memPath != "" {
+			memFile, err := os.Create(memPath)
+			if err != nil {
+				warnf("Error creating memory profile: %v", err)
+				return
+			}
+			defer memFile.Close()
+
+			// Collect garbage first so the profile shows live allocations
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(memFile); err != nil {
+				warnf("Error writing memory profile: %v", err)
+			}
+		}
+	}, nil
+}
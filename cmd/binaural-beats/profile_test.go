@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfileFlags(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+  - {time: 1, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.2}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cpu, mem bool
+	}{
+		{"cpu", true, false},
+		{"memory", false, true},
+		{"both", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			cpuPath, memPath := filepath.Join(out, "cpu.prof"), filepath.Join(out, "mem.prof")
+			args := []string{"-config", config, "-seed", "1", "-output", filepath.Join(out, "out.wav")}
+			if tt.cpu {
+				args = append(args, "-cpuprofile", cpuPath)
+			}
+			if tt.mem {
+				args = append(args, "-memprofile", memPath)
+			}
+			runMain(t, args...)
+
+			for _, profile := range []struct {
+				path string
+				want bool
+			}{{cpuPath, tt.cpu}, {memPath, tt.mem}} {
+				info, err := os.Stat(profile.path)
+				switch {
+				case !profile.want && err == nil:
+					t.Errorf("%s was written without its flag", filepath.Base(profile.path))
+				case profile.want && err != nil:
+					t.Errorf("%s was not written: %v", filepath.Base(profile.path), err)
+				case profile.want && info.Size() == 0:
+					t.Errorf("%s is empty", filepath.Base(profile.path))
+				}
+			}
+		})
+	}
+}
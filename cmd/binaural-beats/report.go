@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"math"
+	"os"
+
+	"github.com/gopxl/beep"
+	"gopkg.in/yaml.v3"
+)
+
+// sessionReport is the machine-readable summary written by -report.
+type sessionReport struct {
+	ConfigSHA256  string          `json:"config_sha256"`
+	RenderSHA256  string          `json:"render_sha256"`
+	SampleRate    int             `json:"sample_rate"`
+	Duration      float64         `json:"duration_seconds"`
+	IntroDuration float64         `json:"intro_duration_seconds"`
+	Peak          float64         `json:"peak"`
+	PeakDBFS      float64         `json:"peak_dbfs"`
+	RMS           float64         `json:"rms"`
+	RMSDBFS       float64         `json:"rms_dbfs"`
+	TruePeak      *float64        `json:"true_peak,omitempty"`
+	TruePeakDBTP  *float64        `json:"true_peak_dbtp,omitempty"`
+	Segments      []reportSegment `json:"segments"`
+}
+
+// reportSegment describes the interval between two frequency changes. Times are relative to
+// the start of the session, after any intro.
+type reportSegment struct {
+	Start              float64 `json:"start_seconds"`
+	End                float64 `json:"end_seconds"`
+	Frequency          float64 `json:"frequency"`
+	EndFrequency       float64 `json:"end_frequency"`
+	BeatFrequency      float64 `json:"beat_frequency"`
+	EndBeatFrequency   float64 `json:"end_beat_frequency"`
+	PinkNoiseVolume    float64 `json:"pink_noise_volume"`
+	EndPinkNoiseVolume float64 `json:"end_pink_noise_volume"`
+	ToneVolume         float64 `json:"tone_volume"`
+	EndToneVolume      float64 `json:"end_tone_volume"`
+	Label              string  `json:"label,omitempty"`
+}
+
+// levelMeter passes samples through unchanged while measuring their peak and RMS level.
+type levelMeter struct {
+	stream     beep.Streamer
+	peak       float64
+	sumSquares float64
+	count      int               // Number of frames measured
+	truePeak   *truePeakDetector // Oversampled peak measurement, or nil to skip it
+}
+
+// Stream measures the samples streamed from the wrapped streamer.
+func (lm *levelMeter) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = lm.stream.Stream(samples)
+	for _, sample := range samples[:n] {
+		for _, v := range sample {
+			lm.peak = math.Max(lm.peak, math.Abs(v))
+			lm.sumSquares += v * v
+		}
+	}
+	if lm.truePeak != nil {
+		for _, sample := range samples[:n] {
+			lm.truePeak.add(sample)
+		}
+	}
+	lm.count += n
+	return n, ok
+}
+
+// Err returns the error state of the wrapped stream.
+func (lm *levelMeter) Err() error {
+	return lm.stream.Err()
+}
+
+// rms returns the RMS level across both channels of everything streamed so far.
+func (lm *levelMeter) rms() float64 {
+	if lm.count == 0 {
+		return 0
+	}
+	return math.Sqrt(lm.sumSquares / float64(2*lm.count))
+}
+
+// renderHasher passes samples through unchanged while hashing them, so two renders can be
+// checked for identical audio without comparing the files.
+type renderHasher struct {
+	stream beep.Streamer
+	hash   hash.Hash
+	buf    []byte
+}
+
+// newRenderHasher returns a renderHasher computing the SHA-256 of the samples of s.
+func newRenderHasher(s beep.Streamer) *renderHasher {
+	return &renderHasher{stream: s, hash: sha256.New()}
+}
+
+// Stream hashes the samples streamed from the wrapped streamer. Each sample is hashed as its
+// little-endian float64 bits, before any encoding, so the hash doesn't depend on the output
+// format.
+func (rh *renderHasher) Stream(samples [][2]float64) (n int, ok bool) {
+	n, ok = rh.stream.Stream(samples)
+	rh.buf = rh.buf[:0]
+	for _, sample := range samples[:n] {
+		for _, v := range sample {
+			rh.buf = binary.LittleEndian.AppendUint64(rh.buf, math.Float64bits(v))
+		}
+	}
+	rh.hash.Write(rh.buf)
+	return n, ok
+}
+
+// Err returns the error state of the wrapped stream.
+func (rh *renderHasher) Err() error {
+	return rh.stream.Err()
+}
+
+// sum returns the hex SHA-256 of everything streamed so far.
+func (rh *renderHasher) sum() string {
+	return hex.EncodeToString(rh.hash.Sum(nil))
+}
+
+// linearToDB converts a linear level to dBFS. Silence is reported as -999 dB, since JSON
+// cannot hold an infinity.
+func linearToDB(v float64) float64 {
+	if v <= 0 {
+		return -999
+	}
+	return 20 * math.Log10(v)
+}
+
+// writeReport writes the JSON report for a rendered session to filename. configPath is empty
+// when the configuration was generated rather than read from a file. renderHash is the hash of
+// the rendered samples.
+func writeReport(filename, configPath string, cfg *Config, sr beep.SampleRate, introDuration float64, meter *levelMeter, renderHash string) error {
+	// Hash the config file, or the generated config when there is no file
+	var data []byte
+	var err error
+	if configPath != "" {
+		data, err = os.ReadFile(configPath)
+	} else {
+		data, err = yaml.Marshal(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+
+	report := sessionReport{
+		ConfigSHA256:  hex.EncodeToString(hash[:]),
+		RenderSHA256:  renderHash,
+		SampleRate:    int(sr),
+		Duration:      float64(meter.count) / float64(sr),
+		IntroDuration: introDuration,
+		Peak:          meter.peak,
+		PeakDBFS:      linearToDB(meter.peak),
+		RMS:           meter.rms(),
+		RMSDBFS:       linearToDB(meter.rms()),
+		Segments:      []reportSegment{},
+	}
+	if meter.truePeak != nil {
+		peak, db := meter.truePeak.peak, linearToDB(meter.truePeak.peak)
+		report.TruePeak, report.TruePeakDBTP = &peak, &db
+	}
+	changes := cfg.FrequencyChanges
+	for i := 0; i+1 < len(changes); i++ {
+		from, to := changes[i], changes[i+1]
+		report.Segments = append(report.Segments, reportSegment{
+			Start:              from.Time,
+			End:                to.Time,
+			Frequency:          from.Frequency,
+			EndFrequency:       to.Frequency,
+			BeatFrequency:      changeBeatFrequency(from),
+			EndBeatFrequency:   changeBeatFrequency(to),
+			PinkNoiseVolume:    from.PinkNoiseVolume,
+			EndPinkNoiseVolume: to.PinkNoiseVolume,
+			ToneVolume:         from.ToneVolume,
+			EndToneVolume:      to.ToneVolume,
+			Label:              from.Label,
+		})
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(out, '\n'), 0644)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "session.yaml")
+	config := `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, label: Alpha}
+  - {time: 1, frequency: 300, beat_frequency: 6, tone_volume: 0.5}
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := mustParseConfig(t, config)
+	frames := renderFrames(t, cfg, testSampleRate, testOptions())
+	var peak float64
+	for _, f := range frames {
+		peak = math.Max(peak, math.Max(math.Abs(f[0]), math.Abs(f[1])))
+	}
+
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	meter := &levelMeter{stream: session.streamer}
+	hasher := newRenderHasher(meter)
+	drainFrames(hasher)
+	reportPath := filepath.Join(dir, "report.json")
+	if err := writeReport(reportPath, configPath, cfg, testSampleRate, 0, meter, hasher.sum()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]any
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report isn't JSON: %v", err)
+	}
+	for _, key := range []string{"config_sha256", "render_sha256", "sample_rate", "duration_seconds", "intro_duration_seconds", "peak", "peak_dbfs", "rms", "rms_dbfs", "segments"} {
+		if _, ok := report[key]; !ok {
+			t.Errorf("report has no %q", key)
+		}
+	}
+	if _, ok := report["true_peak"]; ok {
+		t.Errorf("report has a true_peak without -true-peak")
+	}
+
+	configHash := sha256.Sum256([]byte(config))
+	if got := report["config_sha256"]; got != hex.EncodeToString(configHash[:]) {
+		t.Errorf("config_sha256 = %v, want the hash of the file", got)
+	}
+	if got := report["render_sha256"]; got != hasher.sum() || len(hasher.sum()) != 64 {
+		t.Errorf("render_sha256 = %v, want %v", got, hasher.sum())
+	}
+	if got := report["sample_rate"]; got != float64(testSampleRate) {
+		t.Errorf("sample_rate = %v, want %d", got, testSampleRate)
+	}
+	if got := report["duration_seconds"]; got != 1.0 {
+		t.Errorf("duration_seconds = %v, want 1", got)
+	}
+	if got := report["peak"].(float64); math.Abs(got-peak) > 1e-12 {
+		t.Errorf("peak = %v, want the rendered %v", got, peak)
+	}
+	// A steady sine has an RMS of its peak over √2
+	if got := report["rms"].(float64); math.Abs(got-peak/math.Sqrt2) > 0.01 {
+		t.Errorf("rms = %v, want about %v", got, peak/math.Sqrt2)
+	}
+	if got := report["peak_dbfs"].(float64); math.Abs(got-20*math.Log10(peak)) > 1e-9 {
+		t.Errorf("peak_dbfs = %v, want %v", got, 20*math.Log10(peak))
+	}
+
+	segments := report["segments"].([]any)
+	if len(segments) != 1 {
+		t.Fatalf("report has %d segments, want 1", len(segments))
+	}
+	segment := segments[0].(map[string]any)
+	want := map[string]any{
+		"start_seconds": 0.0, "end_seconds": 1.0,
+		"frequency": 200.0, "end_frequency": 300.0,
+		"beat_frequency": 10.0, "end_beat_frequency": 6.0,
+		"tone_volume": 0.5, "end_tone_volume": 0.5,
+		"label": "Alpha",
+	}
+	for key, value := range want {
+		if segment[key] != value {
+			t.Errorf("segment %s = %v, want %v", key, segment[key], value)
+		}
+	}
+}
+
+func TestLinearToDB(t *testing.T) {
+	tests := []struct {
+		linear, db float64
+	}{
+		{1, 0},
+		{0.1, -20},
+		{0.5, -6.0206},
+		{0, -999},
+	}
+	for _, tt := range tests {
+		if got := linearToDB(tt.linear); math.Abs(got-tt.db) > 1e-4 {
+			t.Errorf("linearToDB(%v) = %v, want %v", tt.linear, got, tt.db)
+		}
+	}
+}
+
+func TestRenderHashReproducible(t *testing.T) {
+	const session = `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.4, pink_noise_volume: 0.3}
+  - {time: 1, frequency: 300, beat_frequency: 6, tone_volume: %v, pink_noise_volume: 0.3}
+`
+	renderHash := func(toneVolume float64, seed int64) string {
+		opts := testOptions()
+		opts.Seed = seed
+		s, err := newSession(mustParseConfig(t, fmt.Sprintf(session, toneVolume)), testSampleRate, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		hasher := newRenderHasher(s.streamer)
+		drainFrames(hasher)
+		return hasher.sum()
+	}
+
+	want := renderHash(0.4, 1)
+	if len(want) != 64 {
+		t.Fatalf("render hash %q isn't a hex SHA-256", want)
+	}
+	if got := renderHash(0.4, 1); got != want {
+		t.Errorf("second render of the same seeded config hashes to %s, want %s", got, want)
+	}
+	for _, tt := range []struct {
+		name       string
+		toneVolume float64
+		seed       int64
+	}{
+		{"changed config", 0.41, 1},
+		{"changed seed", 0.4, 2},
+	} {
+		if got := renderHash(tt.toneVolume, tt.seed); got == want {
+			t.Errorf("%s hashes to the same %s", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// starterChanges are the example frequency changes written by -init.
+var starterChanges = []ConfigFrequencyChange{
+	{Time: 0, Frequency: 200, BeatFrequency: 10, PinkNoiseVolume: 0.3, ToneVolume: 0.2, Label: "Relaxed alpha"},
+	{Time: 300, Frequency: 200, BeatFrequency: 10, PinkNoiseVolume: 0.3, ToneVolume: 0.2, Label: "Relaxed alpha"},
+	{Time: 600, Frequency: 150, BeatFrequency: 6, PinkNoiseVolume: 0.2, ToneVolume: 0.2, Label: "Theta"},
+	{Time: 900, Frequency: 0, BeatFrequency: 0, PinkNoiseVolume: 0, ToneVolume: 0, Label: "Off"},
+}
+
+// starterFieldComments explains each field of the first example frequency change.
+var starterFieldComments = map[string]string{
+	"time":              "Seconds from the start of playback",
+	"frequency":         "Base (carrier) frequency in Hz",
+	"beat_frequency":    "Difference in Hz between the left and right channels",
+	"pink_noise_volume": "Pink noise volume, 0.0 to 1.0",
+	"tone_volume":       "Tone volume, 0.0 to 1.0",
+	"label":             "Optional description shown during playback",
+}
+
+// writeStarterConfig writes a commented example configuration to filename. An existing file
+// is only replaced when force is set.
+func writeStarterConfig(filename string, force bool) error {
+	if !force {
+		if _, err := os.Stat(filename); err == nil {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", filename)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(starterConfigNode()); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// starterConfigNode builds the YAML document for the starter configuration with comments.
+func starterConfigNode() *yaml.Node {
+	changes := &yaml.Node{Kind: yaml.SequenceNode}
+	for i, change := range starterChanges {
+		var item yaml.Node
+		if err := item.Encode(change); err != nil {
+			panic(err)
+		}
+		// Keep only the fields starterFieldComments explains so the example stays short
+		var content []*yaml.Node
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			key, value := item.Content[j], item.Content[j+1]
+			if _, ok := starterFieldComments[key.Value]; !ok {
+				continue
+			}
+			if i == 0 {
+				key.LineComment = starterFieldComments[key.Value]
+			}
+			content = append(content, key, value)
+		}
+		item.Content = content
+		changes.Content = append(changes.Content, &item)
+	}
+
+	root := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{
+				Kind:        yaml.ScalarNode,
+				Value:       "frequency_changes",
+				HeadComment: "Binaural beats session.\nEach frequency change sets the sound at its time; values are\ninterpolated linearly until the next change. Playback ends at the\nlast change.",
+			},
+			changes,
+		},
+	}
+
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+}
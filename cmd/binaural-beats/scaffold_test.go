@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStarterConfigParses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := writeStarterConfig(path, false); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig(path, true)
+	if err != nil {
+		t.Fatalf("starter config doesn't parse: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.FrequencyChanges, starterChanges) {
+		t.Errorf("starter config has changes %+v, want %+v", cfg.FrequencyChanges, starterChanges)
+	}
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		t.Errorf("starter config has problems: %v", problems)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, comment := range starterFieldComments {
+		if !strings.Contains(string(data), "# "+comment) {
+			t.Errorf("starter config doesn't explain %s", field)
+		}
+	}
+}
+
+func TestStarterConfigOverwrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		force   bool
+		wantErr bool
+	}{
+		{"refused without -force", false, true},
+		{"replaced with -force", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte("mine\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := writeStarterConfig(path, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeStarterConfig error = %v, want error %v", err, tt.wantErr)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := string(data) == "mine\n"; kept != tt.wantErr {
+				t.Errorf("existing file kept = %v, want %v", kept, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptThen is the keyword that separates the segments of a session script.
+const scriptThen = "then"
+
+// readScript reads the session script at path, or from stdin if path is "-".
+func readScript(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	return string(data), err
+}
+
+// parseScript builds a configuration from a session script, which describes a session as
+// segments separated by "then", for example
+//
+//	alpha 10Hz carrier 200Hz for 10m then theta 6Hz ramp 1m for 20m
+//
+// Line breaks count as spaces and # starts a comment that runs to the end of the line. Each
+// segment is a list of settings in any order, including "for" and its duration:
+//
+//	<band>            delta, theta, alpha, beta or gamma: the band's beat frequency, also used
+//	                  as the segment's label
+//	<n>Hz             the beat frequency, overriding a band's
+//	carrier <n>Hz     the carrier frequency
+//	tone <volume>     the tone volume, from 0 to 1
+//	noise <volume>    the pink noise volume, from 0 to 1
+//	ramp <duration>   glide into the next segment over the end of this one
+//	for <duration>    the length of the segment
+//
+// "Hz" may be written apart from the number, and durations use Go's syntax, such as 90s, 10m
+// or 1h30m. The beat frequency, carrier and volumes carry over to the next segment. The first
+// segment needs a band or beat frequency; the carrier and volumes default to those of -sweep.
+func parseScript(script string) (*Config, error) {
+	// Drop the comments and split the rest into lower-case words
+	var words []string
+	for _, line := range strings.Split(script, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		words = append(words, strings.Fields(strings.ToLower(line))...)
+	}
+	if len(words) == 0 {
+		return nil, errors.New("the script is empty")
+	}
+
+	cfg := &Config{}
+	current := ConfigFrequencyChange{Frequency: sweepFrequency, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume}
+	hasBeat := false
+	var start float64
+	segment := 1
+	for len(words) > 0 {
+		// Take the words up to the next "then"
+		end := len(words)
+		for i, word := range words {
+			if word == scriptThen {
+				end = i
+				break
+			}
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("segment %d is empty", segment)
+		}
+		seg, rest := words[:end], words[end:]
+		if len(rest) > 0 {
+			rest = rest[1:]
+			if len(rest) == 0 {
+				return nil, fmt.Errorf("the script ends with %q", scriptThen)
+			}
+		}
+
+		current.Label = ""
+		var duration, ramp float64
+		for len(seg) > 0 {
+			word := seg[0]
+			seg = seg[1:]
+			if band, ok := sweepBands[word]; ok {
+				current.BeatFrequency = sweepBeatFrequency(band)
+				current.Label = word
+				hasBeat = true
+				continue
+			}
+
+			var err error
+			switch word {
+			case "carrier":
+				current.Frequency, seg, err = scriptFrequency(seg)
+			case "tone":
+				current.ToneVolume, seg, err = scriptVolume(seg)
+			case "noise":
+				current.PinkNoiseVolume, seg, err = scriptVolume(seg)
+			case "ramp":
+				ramp, seg, err = scriptDuration(seg)
+			case "for":
+				duration, seg, err = scriptDuration(seg)
+			default:
+				// Anything else must be the beat frequency
+				current.BeatFrequency, seg, err = scriptFrequency(append([]string{word}, seg...))
+				if err != nil {
+					return nil, fmt.Errorf("segment %d: unexpected %q (expected a band, a beat frequency, carrier, tone, noise, ramp or for)", segment, word)
+				}
+				hasBeat = true
+			}
+			if err != nil {
+				return nil, fmt.Errorf("segment %d: %s %v", segment, word, err)
+			}
+		}
+
+		switch {
+		case !hasBeat:
+			return nil, fmt.Errorf("segment %d has no band or beat frequency", segment)
+		case duration == 0:
+			return nil, fmt.Errorf("segment %d has no duration; add for and a duration, such as for 10m", segment)
+		case ramp > duration:
+			return nil, fmt.Errorf("segment %d ramps for longer than it lasts", segment)
+		}
+
+		// Hold the segment's settings, then glide into the next segment over the ramp
+		change := current
+		change.Time = start
+		hold := change
+		hold.Time = start + duration
+		if len(rest) > 0 {
+			hold.Time -= ramp
+		}
+		cfg.FrequencyChanges = append(cfg.FrequencyChanges, change, hold)
+
+		start += duration
+		words = rest
+		segment++
+	}
+	return cfg, nil
+}
+
+// scriptFrequency parses a frequency such as 10Hz or 10 Hz from the start of words and returns
+// the words after it.
+func scriptFrequency(words []string) (float64, []string, error) {
+	if len(words) == 0 {
+		return 0, nil, errors.New("needs a frequency in Hz")
+	}
+	text, rest := words[0], words[1:]
+	if !strings.HasSuffix(text, "hz") {
+		if len(rest) == 0 || rest[0] != "hz" {
+			return 0, nil, fmt.Errorf("needs a Hz unit after %q", text)
+		}
+		rest = rest[1:]
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(text, "hz"), 64)
+	if err != nil || f < 0 {
+		return 0, nil, fmt.Errorf("has an invalid frequency %q", text)
+	}
+	return f, rest, nil
+}
+
+// scriptVolume parses a volume from 0 to 1 from the start of words and returns the words
+// after it.
+func scriptVolume(words []string) (float64, []string, error) {
+	if len(words) == 0 {
+		return 0, nil, errors.New("needs a volume from 0 to 1")
+	}
+	v, err := strconv.ParseFloat(words[0], 64)
+	if err != nil || v < 0 || v > 1 {
+		return 0, nil, fmt.Errorf("has an invalid volume %q (expected 0 to 1)", words[0])
+	}
+	return v, words[1:], nil
+}
+
+// scriptDuration parses a duration such as 10m from the start of words and returns the words
+// after it in seconds.
+func scriptDuration(words []string) (float64, []string, error) {
+	if len(words) == 0 {
+		return 0, nil, errors.New("needs a duration, such as 10m")
+	}
+	d, err := time.ParseDuration(words[0])
+	if err != nil || d <= 0 {
+		return 0, nil, fmt.Errorf("has an invalid duration %q (expected a duration such as 90s, 10m or 1h30m)", words[0])
+	}
+	return d.Seconds(), words[1:], nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []ConfigFrequencyChange
+	}{
+		{
+			"bands and beats",
+			"alpha 10Hz carrier 200Hz for 10m then theta 6Hz for 20m",
+			[]ConfigFrequencyChange{
+				{Time: 0, Frequency: 200, BeatFrequency: 10, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume, Label: "alpha"},
+				{Time: 600, Frequency: 200, BeatFrequency: 10, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume, Label: "alpha"},
+				{Time: 600, Frequency: 200, BeatFrequency: 6, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume, Label: "theta"},
+				{Time: 1800, Frequency: 200, BeatFrequency: 6, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume, Label: "theta"},
+			},
+		},
+		{
+			"ramps, volumes and comments",
+			`# Wind down
+Beta carrier 300 Hz tone 0.5 noise 0.1 ramp 30s for 2m # ramp into the next
+then 4 hz for 1m30s   # settings carry over
+then delta noise 0 for 1h`,
+			[]ConfigFrequencyChange{
+				{Time: 0, Frequency: 300, BeatFrequency: 19.7, ToneVolume: 0.5, PinkNoiseVolume: 0.1, Label: "beta"},
+				{Time: 90, Frequency: 300, BeatFrequency: 19.7, ToneVolume: 0.5, PinkNoiseVolume: 0.1, Label: "beta"},
+				{Time: 120, Frequency: 300, BeatFrequency: 4, ToneVolume: 0.5, PinkNoiseVolume: 0.1},
+				{Time: 210, Frequency: 300, BeatFrequency: 4, ToneVolume: 0.5, PinkNoiseVolume: 0.1},
+				{Time: 210, Frequency: 300, BeatFrequency: 1.4, ToneVolume: 0.5, PinkNoiseVolume: 0, Label: "delta"},
+				{Time: 3810, Frequency: 300, BeatFrequency: 1.4, ToneVolume: 0.5, PinkNoiseVolume: 0, Label: "delta"},
+			},
+		},
+		{
+			// The ramp of the last segment has nothing to glide into
+			"ramp on the last segment",
+			"8hz ramp 10s for 1m",
+			[]ConfigFrequencyChange{
+				{Time: 0, Frequency: sweepFrequency, BeatFrequency: 8, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume},
+				{Time: 60, Frequency: sweepFrequency, BeatFrequency: 8, ToneVolume: sweepToneVolume, PinkNoiseVolume: sweepPinkNoiseVolume},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseScript(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.FrequencyChanges) != len(tt.want) {
+				t.Fatalf("script compiled to %d changes, want %d: %+v", len(cfg.FrequencyChanges), len(tt.want), cfg.FrequencyChanges)
+			}
+			for i, want := range tt.want {
+				if got := cfg.FrequencyChanges[i]; !reflect.DeepEqual(got, want) {
+					t.Errorf("change %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if problems := validateConfig(cfg); len(problems) > 0 {
+				t.Errorf("compiled config is invalid: %v", problems)
+			}
+		})
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"  # only a comment\n", "the script is empty"},
+		{"alpha for 1m then", `the script ends with "then"`},
+		{"alpha for 1m then then theta for 1m", "segment 2 is empty"},
+		{"carrier 200hz for 1m", "segment 1 has no band or beat frequency"},
+		{"alpha", "segment 1 has no duration"},
+		{"alpha ramp 2m for 1m", "segment 1 ramps for longer than it lasts"},
+		{"alpha for 1m then loud for 1m", `segment 2: unexpected "loud"`},
+		{"alpha tone 1.5 for 1m", `segment 1: tone has an invalid volume "1.5"`},
+		{"alpha noise", "segment 1: noise needs a volume from 0 to 1"},
+		{"alpha carrier 200 for 1m", `segment 1: carrier needs a Hz unit after "200"`},
+		{"alpha carrier -5hz for 1m", `segment 1: carrier has an invalid frequency "-5hz"`},
+		{"alpha for ten", `segment 1: for has an invalid duration "ten"`},
+	}
+	for _, tt := range tests {
+		_, err := parseScript(tt.script)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseScript(%q) error = %v, want %q", tt.script, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Parameters of the pink noise spectrum check run by -selftest.
+const (
+	selfTestSeed        = 1
+	selfTestFFTSize     = 4096
+	selfTestSegments    = 256
+	selfTestLowHz       = 1000.0  // Lowest frequency in the fit
+	selfTestHighHz      = 16000.0 // Highest frequency in the fit
+	selfTestTargetDB    = -3.0    // Slope of pink noise in dB per octave
+	selfTestToleranceDB = 1.0     // Allowed deviation from the target slope in dB per octave
+)
+
+// runSelfTest generates seeded pink noise, measures its spectral slope and returns an error if
+// the slope isn't within tolerance of pink. The five Voss-McCartney rows only shape the top
+// five octaves, so the fit covers selfTestLowHz to selfTestHighHz.
+func runSelfTest(sampleRate float64) (slope float64, err error) {
+	noise := NewPinkNoise(selfTestSeed)
+	power := averagePowerSpectrum(noise.nextSample, selfTestFFTSize, selfTestSegments)
+	slope = spectralSlope(power, sampleRate, selfTestLowHz, selfTestHighHz)
+	if math.Abs(slope-selfTestTargetDB) > selfTestToleranceDB {
+		return slope, fmt.Errorf("pink noise slope is %.2f dB/octave, expected %.1f ± %.1f", slope, selfTestTargetDB, selfTestToleranceDB)
+	}
+	return slope, nil
+}
+
+// averagePowerSpectrum returns the power of each frequency bin of next, averaged over segments
+// Hann-windowed blocks of size samples. size must be a power of two.
+func averagePowerSpectrum(next func() float64, size, segments int) []float64 {
+	power := make([]float64, size/2+1)
+	block := make([]complex128, size)
+	for s := 0; s < segments; s++ {
+		for i := range block {
+			window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+			block[i] = complex(next()*window, 0)
+		}
+		fft(block)
+		for k := range power {
+			power[k] += real(block[k])*real(block[k]) + imag(block[k])*imag(block[k])
+		}
+	}
+	for k := range power {
+		power[k] /= float64(segments)
+	}
+	return power
+}
+
+// spectralSlope fits a line to the power spectrum in dB against frequency in octaves between
+// low and high Hz and returns its slope in dB per octave. Bins are weighted by 1/f so every
+// octave counts equally.
+func spectralSlope(power []float64, sampleRate, low, high float64) float64 {
+	binHz := sampleRate / float64(2*(len(power)-1))
+	var sumW, sumX, sumY, sumXX, sumXY float64
+	for k := 1; k < len(power); k++ {
+		f := float64(k) * binHz
+		if f < low || f > high || power[k] <= 0 {
+			continue
+		}
+		w := 1 / f
+		x := math.Log2(f)
+		y := 10 * math.Log10(power[k])
+		sumW += w
+		sumX += w * x
+		sumY += w * y
+		sumXX += w * x * x
+		sumXY += w * x * y
+	}
+	return (sumW*sumXY - sumX*sumY) / (sumW*sumXX - sumX*sumX)
+}
+
+// fft computes the discrete Fourier transform of x in place with the iterative radix-2
+// Cooley-Tukey algorithm. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Reorder the input by bit-reversed index
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	slope, err := runSelfTest(44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(slope-selfTestTargetDB) > selfTestToleranceDB {
+		t.Errorf("pink noise slope = %.2f dB/octave, want %.1f ± %.1f", slope, selfTestTargetDB, selfTestToleranceDB)
+	}
+}
+
+func TestSpectralSlope(t *testing.T) {
+	const size = 4096
+	tests := []struct {
+		name  string
+		power func(f float64) float64
+		want  float64
+	}{
+		{"pink", func(f float64) float64 { return 1 / f }, -10 * math.Log10(2)},
+		{"white", func(f float64) float64 { return 1 }, 0},
+		{"brown", func(f float64) float64 { return 1 / (f * f) }, -20 * math.Log10(2)},
+	}
+	for _, tt := range tests {
+		power := make([]float64, size/2+1)
+		binHz := 44100.0 / size
+		for k := 1; k < len(power); k++ {
+			power[k] = tt.power(float64(k) * binHz)
+		}
+		if got := spectralSlope(power, 44100, selfTestLowHz, selfTestHighHz); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s slope = %.4f dB/octave, want %.4f", tt.name, got, tt.want)
+		}
+	}
+
+	// White noise measures flat, so it would fail the self-test
+	r := rand.New(rand.NewSource(1))
+	power := averagePowerSpectrum(func() float64 { return r.Float64()*2 - 1 }, size, 64)
+	if slope := spectralSlope(power, 44100, selfTestLowHz, selfTestHighHz); math.Abs(slope) > 0.3 {
+		t.Errorf("white noise slope = %.2f dB/octave, want about 0", slope)
+	}
+}
+
+func TestFFTMatchesDFT(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	x := make([]complex128, 64)
+	for i := range x {
+		x[i] = complex(r.Float64()-0.5, r.Float64()-0.5)
+	}
+	want := make([]complex128, len(x))
+	for k := range want {
+		for n, v := range x {
+			want[k] += v * cmplx.Exp(complex(0, -2*math.Pi*float64(k*n)/float64(len(x))))
+		}
+	}
+	fft(x)
+	for k := range x {
+		if cmplx.Abs(x[k]-want[k]) > 1e-9 {
+			t.Fatalf("bin %d = %v, want %v", k, x[k], want[k])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gopxl/beep"
+)
+
+// streamLead is how far ahead of real time the /stream endpoint may send audio, so the client
+// can keep a small buffer without the server synthesizing the whole session at once.
+const streamLead = 2 * time.Second
+
+// streamChunkSize is the number of frames written to a /stream client at a time.
+const streamChunkSize = 2048
+
+// realtimeStreamer paces a stream to the wall clock, so no more than lead of audio is delivered
+// ahead of the time elapsed since the first call to Stream.
+type realtimeStreamer struct {
+	ctx       context.Context
+	stream    beep.Streamer
+	sr        beep.SampleRate
+	lead      time.Duration
+	start     time.Time
+	delivered int // Number of frames delivered so far
+}
+
+// Stream waits until the samples are due and then streams them from the wrapped streamer. It
+// stops early if the context is done.
+func (rs *realtimeStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	if rs.start.IsZero() {
+		rs.start = time.Now()
+	}
+
+	due := rs.start.Add(rs.sr.D(rs.delivered) - rs.lead)
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-rs.ctx.Done():
+			timer.Stop()
+			return 0, false
+		}
+	}
+
+	n, ok = rs.stream.Stream(samples)
+	rs.delivered += n
+	return n, ok
+}
+
+// Err returns the context error, or the error state of the wrapped streamer.
+func (rs *realtimeStreamer) Err() error {
+	if err := rs.ctx.Err(); err != nil {
+		return err
+	}
+	return rs.stream.Err()
+}
+
+// writeStreamingWAVHeader writes a WAV header whose sizes are set to the maximum, as is usual
+// for WAV streams of unknown length.
+func writeStreamingWAVHeader(w io.Writer, format beep.Format) error {
+	h := wavHeader{
+		RiffMark:      [4]byte{'R', 'I', 'F', 'F'},
+		FileSize:      math.MaxUint32,
+		WaveMark:      [4]byte{'W', 'A', 'V', 'E'},
+		FmtMark:       [4]byte{'f', 'm', 't', ' '},
+		FormatSize:    16,
+		FormatType:    1, // PCM
+		NumChans:      uint16(format.NumChannels),
+		SampleRate:    uint32(format.SampleRate),
+		ByteRate:      uint32(int(format.SampleRate) * format.Width()),
+		BytesPerFrame: uint16(format.Width()),
+		BitsPerSample: uint16(format.Precision * 8),
+		DataMark:      [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      math.MaxUint32,
+	}
+	return binary.Write(w, binary.LittleEndian, &h)
+}
+
+// handleStream synthesizes the posted configuration in real time and streams it to the client
+// as a WAV of unknown length. Synthesis stops when the client disconnects.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, problems, err := readAPIConfig(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), readErrorStatus(err))
+		return
+	}
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(validationResponse{Valid: false, Errors: problems})
+		return
+	}
+
+	sr := apiSampleRate
+	session, err := newSession(cfg, sr, defaultSessionOptions(time.Now().UnixNano()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+	streamer := &realtimeStreamer{ctx: r.Context(), stream: session.streamer, sr: sr, lead: streamLead}
+
+	format := beep.Format{
+		SampleRate:  sr,
+		NumChannels: 2,
+		Precision:   2, // 16-bit audio
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	if err := writeStreamingWAVHeader(w, format); err != nil {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	samples := make([][2]float64, streamChunkSize)
+	buf := make([]byte, streamChunkSize*format.Width())
+	for {
+		n, ok := streamer.Stream(samples)
+		out := buf
+		for _, sample := range samples[:n] {
+			out = out[format.EncodeSigned(out, sample):]
+		}
+		if _, err := w.Write(buf[:n*format.Width()]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRealtimeStreamerPacesToWallClock(t *testing.T) {
+	tests := []struct {
+		name string
+		lead time.Duration
+	}{
+		{"no lead", 0},
+		{"with lead", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &realtimeStreamer{ctx: context.Background(), stream: ones(), sr: testSampleRate, lead: tt.lead}
+			const run = 300 * time.Millisecond
+			samples := make([][2]float64, 80) // 10 ms at the test rate
+			start := time.Now()
+			delivered := 0
+			for time.Since(start) < run {
+				n, _ := rs.Stream(samples)
+				delivered += n
+			}
+			elapsed := time.Since(start)
+
+			// Delivery runs ahead of the clock by the lead and the chunk released at once
+			want := float64(testSampleRate.N(elapsed+tt.lead) + len(samples))
+			if math.Abs(float64(delivered)-want) > float64(testSampleRate.N(50*time.Millisecond)) {
+				t.Errorf("delivered %d frames in %v, want about %.0f (%d per second)", delivered, elapsed, want, testSampleRate)
+			}
+		})
+	}
+}
+
+func TestRealtimeStreamerStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &realtimeStreamer{ctx: ctx, stream: ones(), sr: testSampleRate}
+	samples := make([][2]float64, int(testSampleRate)) // A second of audio per call
+	if n, ok := rs.Stream(samples); n != len(samples) || !ok {
+		t.Fatalf("first call streamed %d frames, ok %v, want them at once", n, ok)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if n, ok := rs.Stream(samples); n != 0 || ok {
+		t.Errorf("cancelled call streamed %d frames, ok %v, want none", n, ok)
+	}
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("cancelled call returned after %v, want it to stop waiting", waited)
+	}
+	if rs.Err() != context.Canceled {
+		t.Errorf("Err() = %v, want %v", rs.Err(), context.Canceled)
+	}
+}
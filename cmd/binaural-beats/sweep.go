@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sweepBands maps brainwave band names to their beat frequency range in Hz.
+var sweepBands = map[string][2]float64{
+	"delta": {0.5, 4},
+	"theta": {4, 8},
+	"alpha": {8, 13},
+	"beta":  {13, 30},
+	"gamma": {30, 50},
+}
+
+// Settings shared by every change of a generated sweep.
+const (
+	sweepFrequency       = 200.0
+	sweepToneVolume      = 0.2
+	sweepPinkNoiseVolume = 0.3
+	sweepMaxTransition   = 30.0 // Longest glide between bands, in seconds
+)
+
+// sweepBeatFrequency returns the representative beat frequency of a band: the geometric centre
+// of its range, since the bands are spaced roughly logarithmically.
+func sweepBeatFrequency(band [2]float64) float64 {
+	return math.Round(math.Sqrt(band[0]*band[1])*10) / 10
+}
+
+// sweepBand is one band of a sweep with its duration in seconds.
+type sweepBand struct {
+	name     string
+	beat     float64
+	duration float64
+}
+
+// parseSweep builds a configuration from a list such as "delta:5m,theta:5m". Each band holds
+// its beat frequency and glides into the next band over the end of its duration.
+func parseSweep(spec string) (*Config, error) {
+	var bands []sweepBand
+	for _, part := range strings.Split(spec, ",") {
+		name, durationText, found := strings.Cut(strings.TrimSpace(part), ":")
+		name = strings.ToLower(name)
+		band, known := sweepBands[name]
+		if !found || !known {
+			return nil, fmt.Errorf("invalid sweep band %q (expected <band>:<duration> with a band of %s)", part, strings.Join(sweepBandNames(), ", "))
+		}
+		duration, err := time.ParseDuration(durationText)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q for sweep band %s", durationText, name)
+		}
+		bands = append(bands, sweepBand{name: name, beat: sweepBeatFrequency(band), duration: duration.Seconds()})
+	}
+
+	cfg := &Config{}
+	var start float64
+	for i, band := range bands {
+		change := ConfigFrequencyChange{
+			Time:            start,
+			Frequency:       sweepFrequency,
+			BeatFrequency:   band.beat,
+			PinkNoiseVolume: sweepPinkNoiseVolume,
+			ToneVolume:      sweepToneVolume,
+			Label:           band.name,
+		}
+
+		// Hold the band, then glide so the next band's beat is reached when it starts
+		hold := change
+		hold.Time = start + band.duration
+		if i+1 < len(bands) {
+			hold.Time -= math.Min(sweepMaxTransition, band.duration/4)
+			hold.Easing = easingEaseInOut
+		}
+
+		cfg.FrequencyChanges = append(cfg.FrequencyChanges, change, hold)
+		start += band.duration
+	}
+	return cfg, nil
+}
+
+// sweepBandNames returns the names of the sweep bands in order of frequency.
+func sweepBandNames() []string {
+	names := make([]string, 0, len(sweepBands))
+	for name := range sweepBands {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return sweepBands[names[i]][0] < sweepBands[names[j]][0] })
+	return names
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseSweep(t *testing.T) {
+	cfg, err := parseSweep("delta:5m,theta:5m,alpha:5m,beta:5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		t.Fatalf("generated config is invalid: %v", problems)
+	}
+	if total := getTotalPlaybackTime(cfg.FrequencyChanges); total != 1200 {
+		t.Errorf("sweep lasts %v seconds, want 1200", total)
+	}
+
+	beat := createBeatFreqFunc(cfg.FrequencyChanges)
+	bands := []struct {
+		name  string
+		start float64
+		want  float64
+	}{
+		{"delta", 0, 1.4},
+		{"theta", 300, 5.7},
+		{"alpha", 600, 10.2},
+		{"beta", 900, 19.7},
+	}
+	for i, band := range bands {
+		// Each band holds its beat through its middle and has reached it at its start
+		for _, at := range []float64{band.start, band.start + 150} {
+			if got := beat(at); math.Abs(got-band.want) > 1e-9 {
+				t.Errorf("beat at %vs in %s = %v Hz, want %v", at, band.name, got, band.want)
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		// The glide into the band rises monotonically from the previous band's beat
+		previous := bands[i-1].want
+		last := previous
+		for at := band.start - sweepMaxTransition; at < band.start; at++ {
+			got := beat(at)
+			if got < last || got > band.want {
+				t.Fatalf("beat at %vs gliding into %s = %v Hz, want it rising from %v to %v", at, band.name, got, previous, band.want)
+			}
+			last = got
+		}
+	}
+}
+
+func TestParseSweepErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"epsilon:5m", `invalid sweep band "epsilon:5m"`},
+		{"alpha", `invalid sweep band "alpha"`},
+		{"alpha:5", `invalid duration "5" for sweep band alpha`},
+		{"alpha:-5m", `invalid duration "-5m" for sweep band alpha`},
+		{"alpha:5m,", `invalid sweep band ""`},
+	}
+	for _, tt := range tests {
+		_, err := parseSweep(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseSweep(%q) error = %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// telemetryInterval is the time between the rows written by -telemetry, in seconds.
+const telemetryInterval = 1.0
+
+// telemetryHeader names the columns written by -telemetry.
+var telemetryHeader = []string{"time_seconds", "base_frequency", "beat_frequency", "tone_volume", "noise_on", "pink_noise_volume"}
+
+// writeTelemetry writes the session's parameters to filename as CSV, one row every
+// telemetryInterval seconds from the start of the session to its end. The values come from the
+// session's parameter functions, so no audio is synthesized. Times are relative to the start
+// of the session, after any intro.
+func writeTelemetry(filename string, session *Session) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(telemetryHeader); err != nil {
+		return err
+	}
+	for i := 0; float64(i)*telemetryInterval <= session.totalPlaybackTime; i++ {
+		t := float64(i) * telemetryInterval
+		noise := session.pinkNoiseFunc(t)
+		row := []string{
+			formatTelemetryValue(t),
+			formatTelemetryValue(session.baseFreqFunc(t)),
+			formatTelemetryValue(session.beatFreqFunc(t)),
+			formatTelemetryValue(session.volumeFunc(t)),
+			strconv.FormatBool(noise > 0),
+			formatTelemetryValue(noise),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// formatTelemetryValue formats a value for the telemetry CSV with up to 6 decimal places.
+func formatTelemetryValue(v float64) string {
+	return strconv.FormatFloat(roundTo(v, 6), 'f', -1, 64)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteTelemetry(t *testing.T) {
+	cfg := mustParseConfig(t, `
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.2, pink_noise_volume: 0}
+  - {time: 4, frequency: 300, beat_frequency: 6, tone_volume: 0.6, pink_noise_volume: 0.4}
+  - {time: 5.5, frequency: 300, beat_frequency: 6, tone_volume: 0.6, pink_noise_volume: 0.4}
+`)
+	session, err := newSession(cfg, testSampleRate, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	path := filepath.Join(t.TempDir(), "telemetry.csv")
+	if err := writeTelemetry(path, session); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One row per whole second up to the 5.5 s end, after the header
+	want := [][]string{
+		telemetryHeader,
+		{"0", "200", "10", "0.2", "false", "0"},
+		{"1", "225", "9", "0.3", "true", "0.1"},
+		{"2", "250", "8", "0.4", "true", "0.2"},
+		{"3", "275", "7", "0.5", "true", "0.3"},
+		{"4", "300", "6", "0.6", "true", "0.4"},
+		{"5", "300", "6", "0.6", "true", "0.4"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("telemetry rows = %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "math"
+
+// Parameters of the true-peak detector's polyphase upsampler. 4x oversampling with 12 taps
+// per phase follows the ITU-R BS.1770 true-peak meter.
+const (
+	truePeakOversampling = 4
+	truePeakTapsPerPhase = 12
+)
+
+// truePeakDetector estimates the peak of the continuous signal between samples, which a DAC
+// can reproduce above the largest sample, by upsampling each channel and measuring that.
+type truePeakDetector struct {
+	phases  [][]float64  // FIR coefficients of each polyphase branch, newest sample first
+	history [2][]float64 // Most recent input samples of each channel, newest first
+	peak    float64      // Largest absolute upsampled value so far
+}
+
+// newTruePeakDetector returns a detector with a windowed-sinc interpolation filter split into
+// truePeakOversampling phases.
+func newTruePeakDetector() *truePeakDetector {
+	length := truePeakOversampling * truePeakTapsPerPhase
+	center := float64(length-1) / 2
+	prototype := make([]float64, length)
+	for n := range prototype {
+		x := (float64(n) - center) / truePeakOversampling
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		// Blackman window
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(length-1)) + 0.08*math.Cos(4*math.Pi*float64(n)/float64(length-1))
+		prototype[n] = sinc * w
+	}
+
+	tpd := &truePeakDetector{}
+	for p := 0; p < truePeakOversampling; p++ {
+		phase := make([]float64, truePeakTapsPerPhase)
+		var sum float64
+		for k := range phase {
+			phase[k] = prototype[p+k*truePeakOversampling]
+			sum += phase[k]
+		}
+		// Normalize each phase to unity gain at DC, so a constant signal reads its own level
+		for k := range phase {
+			phase[k] /= sum
+		}
+		tpd.phases = append(tpd.phases, phase)
+	}
+	for c := range tpd.history {
+		tpd.history[c] = make([]float64, truePeakTapsPerPhase)
+	}
+	return tpd
+}
+
+// add feeds one stereo frame to the detector.
+func (tpd *truePeakDetector) add(sample [2]float64) {
+	for c, v := range sample {
+		h := tpd.history[c]
+		copy(h[1:], h[:len(h)-1])
+		h[0] = v
+		tpd.peak = math.Max(tpd.peak, math.Abs(v))
+		for _, phase := range tpd.phases {
+			var y float64
+			for k, coef := range phase {
+				y += coef * h[k]
+			}
+			tpd.peak = math.Max(tpd.peak, math.Abs(y))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTruePeakDetector(t *testing.T) {
+	tests := []struct {
+		name       string
+		signal     func(n int) float64
+		samplePeak float64
+		truePeak   float64
+		tolerance  float64 // dB
+	}{
+		// A quarter of the sample rate, sampled 45° off its peaks: every sample is at 0.707 of
+		// the peak the DAC reconstructs between them
+		{"inter-sample peak", func(n int) float64 { return 0.9 * math.Sin(math.Pi/2*float64(n)+math.Pi/4) }, 0.9 / math.Sqrt2, 0.9, 0.3},
+		{"sampled at its peak", func(n int) float64 { return 0.5 * math.Sin(2*math.Pi*100*float64(n)/8000) }, 0.5, 0.5, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpd := newTruePeakDetector()
+			var samplePeak float64
+			for n := 0; n < 4000; n++ {
+				v := tt.signal(n)
+				samplePeak = math.Max(samplePeak, math.Abs(v))
+				tpd.add([2]float64{v, v / 2})
+			}
+			if math.Abs(samplePeak-tt.samplePeak) > 1e-3 {
+				t.Fatalf("sample peak = %.4f, want %.4f", samplePeak, tt.samplePeak)
+			}
+			if d := linearToDB(tpd.peak) - linearToDB(tt.truePeak); math.Abs(d) > tt.tolerance {
+				t.Errorf("true peak = %.2f dBTP, want %.2f ± %.1f", linearToDB(tpd.peak), linearToDB(tt.truePeak), tt.tolerance)
+			}
+			if tpd.peak < samplePeak {
+				t.Errorf("true peak %.4f is below the sample peak %.4f", tpd.peak, samplePeak)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchInterval is how often -watch checks the config file for changes.
+const watchInterval = 500 * time.Millisecond
+
+// configWatcher detects changes to a file by polling its modification time and size, which
+// also catches editors that save by replacing the file.
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// newConfigWatcher returns a watcher for path that reports changes made after this call.
+func newConfigWatcher(path string) (*configWatcher, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &configWatcher{path: path, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// changed reports whether the file has changed since the last change was reported. A file
+// that can't be read, such as one in the middle of being replaced, is reported as unchanged
+// so the check is repeated on the next poll.
+func (cw *configWatcher) changed() bool {
+	info, err := os.Stat(cw.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(cw.modTime) && info.Size() == cw.size {
+		return false
+	}
+	cw.modTime, cw.size = info.ModTime(), info.Size()
+	return true
+}
+
+// watch polls the file every interval and sends on the returned channel each time it changes.
+// Changes made while a previous one is still waiting to be received are merged into it.
+func (cw *configWatcher) watch(interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if cw.changed() {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes
+}
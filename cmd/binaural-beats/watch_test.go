@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigWatcherChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("frequency_changes: []\n")
+	cw, err := newConfigWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	steps := []struct {
+		name   string
+		change func()
+		want   bool
+	}{
+		{"untouched", func() {}, false},
+		{"rewritten with a new size", func() { write("frequency_changes: [ ]\n") }, true},
+		{"reported once", func() {}, false},
+		{"touched", func() { os.Chtimes(path, later, later) }, true},
+		{"removed while being replaced", func() { os.Remove(path) }, false},
+		{"replaced by a rename", func() {
+			tmp := path + ".tmp"
+			os.WriteFile(tmp, []byte("# replaced\nfrequency_changes: []\n"), 0644)
+			os.Rename(tmp, path)
+		}, true},
+	}
+	for _, step := range steps {
+		step.change()
+		if got := cw.changed(); got != step.want {
+			t.Errorf("%s: changed() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestConfigWatcherWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.yaml")
+	if err := os.WriteFile(path, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cw, err := newConfigWatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := cw.watch(5 * time.Millisecond)
+
+	select {
+	case <-changes:
+		t.Fatal("change reported before the file was modified")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := os.WriteFile(path, []byte("ab"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("no change reported after the file was modified")
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gopxl/beep"
+)
+
+// wavHeaderSize is the size of the RIFF, fmt and data chunk headers written by WAVWriter.
+const wavHeaderSize = 44
+
+// floatWAVHeaderSize is the size of the headers of a float WAV file, which add the extension
+// size to the fmt chunk and a fact chunk before the data.
+const floatWAVHeaderSize = 58
+
+// wavFloatPrecision is the format precision in bytes at which WAVWriter writes 32-bit IEEE
+// float samples instead of integer PCM.
+const wavFloatPrecision = 4
+
+// maxWAVChannels is the most channels WAVWriter writes, enough for third-order ambisonics.
+const maxWAVChannels = 16
+
+// WAV format tags.
+const (
+	wavFormatPCM       = 1
+	wavFormatIEEEFloat = 3
+)
+
+// wavHeader is the canonical 44-byte header of a PCM WAV file.
+type wavHeader struct {
+	RiffMark      [4]byte
+	FileSize      uint32
+	WaveMark      [4]byte
+	FmtMark       [4]byte
+	FormatSize    uint32
+	FormatType    uint16
+	NumChans      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BytesPerFrame uint16
+	BitsPerSample uint16
+	DataMark      [4]byte
+	DataSize      uint32
+}
+
+// floatWAVHeader is the header of a WAV file of IEEE float samples. Formats other than PCM
+// need the extension size in the fmt chunk and a fact chunk holding the length in frames.
+type floatWAVHeader struct {
+	RiffMark      [4]byte
+	FileSize      uint32
+	WaveMark      [4]byte
+	FmtMark       [4]byte
+	FormatSize    uint32
+	FormatType    uint16
+	NumChans      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BytesPerFrame uint16
+	BitsPerSample uint16
+	ExtensionSize uint16
+	FactMark      [4]byte
+	FactSize      uint32
+	SampleLength  uint32
+	DataMark      [4]byte
+	DataSize      uint32
+}
+
+// WAVWriter writes PCM audio to a WAV file incrementally. A placeholder header is written
+// first and the RIFF and data chunk sizes are filled in by Close, so frames can be appended
+// as they are produced.
+type WAVWriter struct {
+	w       io.WriteSeeker
+	bw      *bufio.Writer
+	header  wavHeader
+	format  beep.Format
+	buf     []byte
+	written int64
+	info    [][2]string // INFO tag IDs and values, in the order they are written
+	closed  bool
+}
+
+// NewWAVWriter writes a placeholder WAV header for format to w and returns a writer for the
+// frames. The format precision must be 1, 2 or 3 bytes for integer PCM, or wavFloatPrecision
+// for 32-bit float samples.
+func NewWAVWriter(w io.WriteSeeker, format beep.Format) (*WAVWriter, error) {
+	if format.NumChannels < 1 || format.NumChannels > maxWAVChannels {
+		return nil, fmt.Errorf("wav: unsupported number of channels %d (expected 1 to %d)", format.NumChannels, maxWAVChannels)
+	}
+	if format.Precision < 1 || format.Precision > wavFloatPrecision {
+		return nil, fmt.Errorf("wav: unsupported precision %d (expected 1, 2 or 3 bytes, or 4 for float)", format.Precision)
+	}
+	formatType := uint16(wavFormatPCM)
+	if format.Precision == wavFloatPrecision {
+		formatType = wavFormatIEEEFloat
+	}
+
+	ww := &WAVWriter{
+		w: w,
+		header: wavHeader{
+			RiffMark:      [4]byte{'R', 'I', 'F', 'F'},
+			WaveMark:      [4]byte{'W', 'A', 'V', 'E'},
+			FmtMark:       [4]byte{'f', 'm', 't', ' '},
+			FormatSize:    16,
+			FormatType:    formatType,
+			NumChans:      uint16(format.NumChannels),
+			SampleRate:    uint32(format.SampleRate),
+			ByteRate:      uint32(int(format.SampleRate) * format.Width()),
+			BytesPerFrame: uint16(format.Width()),
+			BitsPerSample: uint16(format.Precision * 8),
+			DataMark:      [4]byte{'d', 'a', 't', 'a'},
+		},
+		format: format,
+	}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	ww.bw = bufio.NewWriter(w)
+	return ww, nil
+}
+
+// isFloat reports whether the writer writes IEEE float samples.
+func (ww *WAVWriter) isFloat() bool {
+	return ww.header.FormatType == wavFormatIEEEFloat
+}
+
+// headerSize returns the size of the headers before the audio.
+func (ww *WAVWriter) headerSize() int64 {
+	if ww.isFloat() {
+		return floatWAVHeaderSize
+	}
+	return wavHeaderSize
+}
+
+// writeHeader writes the headers at the current position of the underlying writer.
+func (ww *WAVWriter) writeHeader() error {
+	if !ww.isFloat() {
+		return binary.Write(ww.w, binary.LittleEndian, &ww.header)
+	}
+
+	h := ww.header
+	return binary.Write(ww.w, binary.LittleEndian, &floatWAVHeader{
+		RiffMark:      h.RiffMark,
+		FileSize:      h.FileSize,
+		WaveMark:      h.WaveMark,
+		FmtMark:       h.FmtMark,
+		FormatSize:    18,
+		FormatType:    h.FormatType,
+		NumChans:      h.NumChans,
+		SampleRate:    h.SampleRate,
+		ByteRate:      h.ByteRate,
+		BytesPerFrame: h.BytesPerFrame,
+		BitsPerSample: h.BitsPerSample,
+		FactMark:      [4]byte{'f', 'a', 'c', 't'},
+		FactSize:      4,
+		SampleLength:  h.DataSize / uint32(h.BytesPerFrame),
+		DataMark:      h.DataMark,
+		DataSize:      h.DataSize,
+	})
+}
+
+// Write encodes the samples and appends them to the data chunk. It writes mono and stereo
+// files; use WriteFrames for more channels.
+func (ww *WAVWriter) Write(samples [][2]float64) error {
+	if ww.closed {
+		return errors.New("wav: write to closed writer")
+	}
+	if ww.format.NumChannels > 2 {
+		return fmt.Errorf("wav: Write takes stereo samples; use WriteFrames for %d channels", ww.format.NumChannels)
+	}
+
+	size := len(samples) * ww.format.Width()
+	if len(ww.buf) < size {
+		ww.buf = make([]byte, size)
+	}
+	buf := ww.buf[:size]
+	for _, sample := range samples {
+		// 8-bit WAV is unsigned, wider samples are signed, and float samples are written as
+		// they are, even beyond full scale
+		if ww.isFloat() {
+			buf = ww.encodeFloat(buf, sample)
+		} else if ww.format.Precision == 1 {
+			buf = buf[ww.format.EncodeUnsigned(buf, sample):]
+		} else {
+			buf = buf[ww.format.EncodeSigned(buf, sample):]
+		}
+	}
+
+	n, err := ww.bw.Write(ww.buf[:size])
+	ww.written += int64(n)
+	return err
+}
+
+// WriteFrames encodes frames holding a sample for each channel of the file and appends them to
+// the data chunk.
+func (ww *WAVWriter) WriteFrames(frames [][]float64) error {
+	if ww.closed {
+		return errors.New("wav: write to closed writer")
+	}
+
+	size := len(frames) * ww.format.Width()
+	if len(ww.buf) < size {
+		ww.buf = make([]byte, size)
+	}
+	buf := ww.buf[:size]
+	mono := ww.format
+	mono.NumChannels = 1
+	for i, frame := range frames {
+		if len(frame) != ww.format.NumChannels {
+			return fmt.Errorf("wav: frame %d has %d samples for %d channels", i, len(frame), ww.format.NumChannels)
+		}
+		// Integer samples are encoded as mono frames, which beep encodes as the average of both sides
+		for _, v := range frame {
+			sample := [2]float64{v, v}
+			if ww.isFloat() {
+				binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(v)))
+				buf = buf[4:]
+			} else if ww.format.Precision == 1 {
+				buf = buf[mono.EncodeUnsigned(buf, sample):]
+			} else {
+				buf = buf[mono.EncodeSigned(buf, sample):]
+			}
+		}
+	}
+
+	n, err := ww.bw.Write(ww.buf[:size])
+	ww.written += int64(n)
+	return err
+}
+
+// encodeFloat writes sample to buf as 32-bit floats and returns the rest of buf. Mono frames
+// hold the average of both channels, as beep encodes them.
+func (ww *WAVWriter) encodeFloat(buf []byte, sample [2]float64) []byte {
+	if ww.format.NumChannels == 1 {
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(float32((sample[0]+sample[1])/2)))
+		return buf[4:]
+	}
+	binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(sample[0])))
+	binary.LittleEndian.PutUint32(buf[4:], math.Float32bits(float32(sample[1])))
+	return buf[8:]
+}
+
+// SetInfo sets a tag such as "INAM" (title) in the LIST INFO chunk written after the audio
+// on Close. Empty values are left out.
+func (ww *WAVWriter) SetInfo(id, value string) {
+	if value != "" {
+		ww.info = append(ww.info, [2]string{id, value})
+	}
+}
+
+// Close flushes the buffered frames, appends the INFO tags and rewrites the header with the
+// final chunk sizes. It does not close the underlying writer.
+func (ww *WAVWriter) Close() error {
+	if ww.closed {
+		return nil
+	}
+	ww.closed = true
+
+	// Chunks start on even offsets, so an odd-sized data chunk is followed by a pad byte
+	trailer := infoChunk(ww.info)
+	if ww.written%2 == 1 {
+		trailer = append([]byte{0}, trailer...)
+	}
+	if _, err := ww.bw.Write(trailer); err != nil {
+		return err
+	}
+	if err := ww.bw.Flush(); err != nil {
+		return err
+	}
+
+	// The RIFF size covers everything after its own 8-byte chunk header
+	size := ww.written + int64(len(trailer))
+	if size > math.MaxUint32-(ww.headerSize()-8) {
+		return fmt.Errorf("wav: %d bytes of audio is too large for a WAV file", ww.written)
+	}
+	ww.header.DataSize = uint32(ww.written)
+	ww.header.FileSize = uint32(ww.headerSize() - 8 + size)
+
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// infoChunk encodes tags as a LIST chunk of type INFO, or returns nil if there are none. Each
+// value is NUL-terminated and padded to an even length.
+func infoChunk(tags [][2]string) []byte {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	body := []byte("INFO")
+	for _, tag := range tags {
+		value := append([]byte(tag[1]), 0)
+		body = append(body, tag[0]...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(value)))
+		body = append(body, value...)
+		if len(value)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	chunk := []byte("LIST")
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(body)))
+	return append(chunk, body...)
+}
+
+// encodeWAV writes all audio streamed from s to w in WAV format using a WAVWriter, with meta
+// as INFO tags. Like wav.Encode, it leaves checking s.Err() to the caller.
+func encodeWAV(w io.WriteSeeker, s beep.Streamer, format beep.Format, meta exportMetadata) error {
+	ww, err := NewWAVWriter(w, format)
+	if err != nil {
+		return err
+	}
+	ww.SetInfo("INAM", meta.Title)
+	ww.SetInfo("IART", meta.Artist)
+	ww.SetInfo("ICMT", meta.Comment)
+
+	samples := make([][2]float64, 512)
+	for {
+		n, ok := s.Stream(samples)
+		if err := ww.Write(samples[:n]); err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return ww.Close()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopxl/beep"
+)
+
+// testFrames returns n stereo frames of a different ramp in each channel, within full scale.
+func testFrames(n int) [][2]float64 {
+	frames := make([][2]float64, n)
+	for i := range frames {
+		x := float64(i) / float64(n)
+		frames[i] = [2]float64{0.9 * (2*x - 1), 0.5 * math.Sin(2*math.Pi*3*x)}
+	}
+	return frames
+}
+
+func TestWAVWriter(t *testing.T) {
+	tests := []struct {
+		precision, channels, frames int
+		info                        bool
+	}{
+		{1, 1, 101, false}, // Odd data chunk without a trailer
+		{1, 1, 101, true},  // Odd data chunk before the INFO chunk
+		{1, 2, 100, false},
+		{2, 1, 100, true},
+		{2, 2, 99, false},
+		{3, 1, 101, false}, // Odd data chunk of 24-bit mono
+		{3, 2, 100, true},
+		{4, 1, 100, false},
+		{4, 2, 101, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d-bit %d channels %d frames info %v", tt.precision*8, tt.channels, tt.frames, tt.info), func(t *testing.T) {
+			format := beep.Format{SampleRate: testSampleRate, NumChannels: tt.channels, Precision: tt.precision}
+			path := filepath.Join(t.TempDir(), "test.wav")
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ww, err := NewWAVWriter(file, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.info {
+				ww.SetInfo("INAM", "Odd")
+				ww.SetInfo("ICMT", "Even")
+			}
+			frames := testFrames(tt.frames)
+			// Write in uneven pieces, as the exporters do
+			for start := 0; start < len(frames); start += 37 {
+				if err := ww.Write(frames[start:min(start+37, len(frames))]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ww.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The chunk sizes match the file, and every chunk starts on an even offset
+			header := wavHeaderSize
+			if tt.precision == wavFloatPrecision {
+				header = floatWAVHeaderSize
+			}
+			dataSize := tt.frames * format.Width()
+			if got := int(binary.LittleEndian.Uint32(data[4:])); got != len(data)-8 {
+				t.Errorf("RIFF size = %d, want the %d bytes after its header", got, len(data)-8)
+			}
+			if got := int(binary.LittleEndian.Uint32(data[header-4:])); got != dataSize {
+				t.Errorf("data size = %d, want %d", got, dataSize)
+			}
+			trailer := data[header+dataSize:]
+			if dataSize%2 == 1 {
+				if len(trailer) == 0 || trailer[0] != 0 {
+					t.Fatalf("odd data chunk isn't followed by a pad byte")
+				}
+				trailer = trailer[1:]
+			}
+			if len(data)%2 == 1 {
+				t.Errorf("file is %d bytes, want an even size", len(data))
+			}
+			if tt.info {
+				want := infoChunk([][2]string{{"INAM", "Odd"}, {"ICMT", "Even"}})
+				if !bytes.Equal(trailer, want) {
+					t.Errorf("trailer = %q, want the INFO chunk %q", trailer, want)
+				}
+			} else if len(trailer) > 0 {
+				t.Errorf("trailer = %q, want none", trailer)
+			}
+
+			// The samples read back within a quantization step
+			want := frames
+			if tt.channels == 1 {
+				want = make([][2]float64, len(frames))
+				for i, f := range frames {
+					mono := (f[0] + f[1]) / 2
+					want[i] = [2]float64{mono, mono}
+				}
+			}
+			var got [][2]float64
+			if tt.precision == wavFloatPrecision {
+				// beep/wav doesn't decode float files
+				samples := data[header : header+dataSize]
+				for i := 0; i < tt.frames; i++ {
+					var frame [2]float64
+					for c := 0; c < 2; c++ {
+						ch := min(c, tt.channels-1)
+						frame[c] = float64(math.Float32frombits(binary.LittleEndian.Uint32(samples[(i*tt.channels+ch)*4:])))
+					}
+					got = append(got, frame)
+				}
+			} else {
+				decodedFormat, decoded := readTestWAV(t, path)
+				if decodedFormat != format {
+					t.Errorf("decoded format = %+v, want %+v", decodedFormat, format)
+				}
+				got = decoded
+			}
+			if len(got) != len(want) {
+				t.Fatalf("read back %d frames, want %d", len(got), len(want))
+			}
+			step := 1 / (math.Exp2(float64(tt.precision*8-1)) - 1)
+			if tt.precision == wavFloatPrecision {
+				step = 1e-7
+			}
+			for i := range want {
+				for c := 0; c < 2; c++ {
+					if math.Abs(got[i][c]-want[i][c]) > step {
+						t.Fatalf("frame %d = %v, want %v", i, got[i], want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWAVWriterMultichannel(t *testing.T) {
+	format := beep.Format{SampleRate: testSampleRate, NumChannels: 4, Precision: 2}
+	path := filepath.Join(t.TempDir(), "quad.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	ww, err := NewWAVWriter(file, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Write(testFrames(1)); err == nil {
+		t.Error("Write accepted stereo samples for a 4-channel file")
+	}
+	if err := ww.WriteFrames([][]float64{{0.1, 0.2, 0.3}}); err == nil {
+		t.Error("WriteFrames accepted a 3-sample frame for a 4-channel file")
+	}
+	if err := ww.WriteFrames([][]float64{{0.1, 0.2, 0.3, 0.4}, {-0.1, -0.2, -0.3, -0.4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.WriteFrames([][]float64{{0, 0, 0, 0}}); err == nil {
+		t.Error("WriteFrames succeeded after Close")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != wavHeaderSize+16 {
+		t.Fatalf("file is %d bytes, want %d", len(data), wavHeaderSize+16)
+	}
+	for i, want := range []float64{0.1, 0.2, 0.3, 0.4, -0.1, -0.2, -0.3, -0.4} {
+		got := float64(int16(binary.LittleEndian.Uint16(data[wavHeaderSize+2*i:]))) / math.MaxInt16
+		if math.Abs(got-want) > 1.0/math.MaxInt16 {
+			t.Errorf("sample %d = %.5f, want %.5f", i, got, want)
+		}
+	}
+}
+
+func TestNewWAVWriterRejectsFormats(t *testing.T) {
+	tests := []beep.Format{
+		{SampleRate: testSampleRate, NumChannels: 0, Precision: 2},
+		{SampleRate: testSampleRate, NumChannels: maxWAVChannels + 1, Precision: 2},
+		{SampleRate: testSampleRate, NumChannels: 2, Precision: 0},
+		{SampleRate: testSampleRate, NumChannels: 2, Precision: 5},
+	}
+	for _, format := range tests {
+		file, err := os.Create(filepath.Join(t.TempDir(), "test.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewWAVWriter(file, format); err == nil {
+			t.Errorf("NewWAVWriter accepted %+v", format)
+		}
+		file.Close()
+	}
+}
+
+func TestFloatExportHeader(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.yaml")
+	if err := os.WriteFile(config, []byte(`
+frequency_changes:
+  - {time: 0, frequency: 200, beat_frequency: 10, tone_volume: 0.5, pink_noise_volume: 0.3}
+  - {time: 1.5, frequency: 300, beat_frequency: 6, tone_volume: 0.4, pink_noise_volume: 0.2}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		channels int
+	}{
+		{"stereo", nil, 2},
+		{"mono", []string{"-downmix-beat"}, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, tt.name+".wav")
+			runMain(t, append([]string{"-config", config, "-seed", "1", "-float", "-output", output}, tt.args...)...)
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h floatWAVHeader
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h); err != nil {
+				t.Fatal(err)
+			}
+			if string(h.FmtMark[:]) != "fmt " || string(h.FactMark[:]) != "fact" || string(h.DataMark[:]) != "data" {
+				t.Fatalf("chunks are %q, %q and %q, want fmt, fact and data", h.FmtMark, h.FactMark, h.DataMark)
+			}
+			if h.FormatType != wavFormatIEEEFloat || h.BitsPerSample != 32 || int(h.NumChans) != tt.channels {
+				t.Errorf("format tag %d, %d bits, %d channels, want IEEE float (3), 32 bits, %d channels", h.FormatType, h.BitsPerSample, h.NumChans, tt.channels)
+			}
+			// The fact chunk and the data chunk both hold the session's 1.5 s of frames
+			want := int(h.SampleRate) * 3 / 2
+			if frames := int(h.DataSize) / int(h.BytesPerFrame); frames != want || int(h.SampleLength) != want {
+				t.Errorf("data chunk holds %d frames and the fact chunk counts %d, want %d", frames, h.SampleLength, want)
+			}
+			if got := int(binary.LittleEndian.Uint32(data[4:])); got != len(data)-8 {
+				t.Errorf("RIFF size = %d, want the %d bytes after its header", got, len(data)-8)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Gnaural voice types.
+const (
+	gnauralVoiceBinaural  = 0
+	gnauralVoicePinkNoise = 1
+)
+
+// gnauralSchedule is the root element of a Gnaural XML schedule.
+type gnauralSchedule struct {
+	XMLName xml.Name       `xml:"schedule"`
+	Voices  []gnauralVoice `xml:"voice"`
+}
+
+// gnauralVoice is a single voice with its list of timed entries.
+type gnauralVoice struct {
+	Description string         `xml:"description"`
+	Type        int            `xml:"type"`
+	Entries     []gnauralEntry `xml:"entries>entry"`
+}
+
+// gnauralEntry is a single point of a Gnaural voice. Its values apply at its start and glide
+// linearly toward the next entry over its duration.
+type gnauralEntry struct {
+	Duration    float64 `xml:"duration,attr"`
+	VolumeLeft  float64 `xml:"volume_left,attr"`
+	VolumeRight float64 `xml:"volume_right,attr"`
+	BeatFreq    float64 `xml:"beatfreq,attr"`
+	BaseFreq    float64 `xml:"basefreq,attr"`
+}
+
+// gnauralPoint is an entry placed at its absolute start time.
+type gnauralPoint struct {
+	time  float64
+	entry gnauralEntry
+}
+
+// isGnaural reports whether the input looks like a Gnaural schedule, either by its extension
+// or by starting with XML.
+func isGnaural(filename string, data []byte) bool {
+	if strings.EqualFold(filepath.Ext(filename), ".gnaural") {
+		return true
+	}
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<schedule"))
+}
+
+// parseGnaural converts a Gnaural XML schedule into frequency changes. The first binaural
+// voice provides the tone and the first pink noise voice provides the noise volume; other
+// voices are skipped with a warning.
+func parseGnaural(data []byte) ([]FrequencyChange, error) {
+	var schedule gnauralSchedule
+	if err := xml.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("error parsing Gnaural XML: %v", err)
+	}
+
+	var tone, noise []gnauralPoint
+	for _, voice := range schedule.Voices {
+		switch {
+		case voice.Type == gnauralVoiceBinaural && tone == nil:
+			tone = gnauralPoints(voice.Entries)
+		case voice.Type == gnauralVoicePinkNoise && noise == nil:
+			noise = gnauralPoints(voice.Entries)
+		default:
+			log.Printf("Skipping Gnaural voice '%s' (type %d)", voice.Description, voice.Type)
+		}
+	}
+	if tone == nil {
+		return nil, errors.New("no binaural voice found")
+	}
+
+	// Sample every voice at the union of all entry times
+	times := make(map[float64]bool)
+	for _, points := range [][]gnauralPoint{tone, noise} {
+		for _, p := range points {
+			times[p.time] = true
+		}
+	}
+	sorted := make([]float64, 0, len(times))
+	for t := range times {
+		sorted = append(sorted, t)
+	}
+	sort.Float64s(sorted)
+
+	frequencyChanges := make([]FrequencyChange, 0, len(sorted))
+	for _, t := range sorted {
+		fc := FrequencyChange{
+			Time:          t,
+			Frequency:     gnauralValueAt(tone, t, func(e gnauralEntry) float64 { return e.BaseFreq }),
+			BeatFrequency: gnauralValueAt(tone, t, func(e gnauralEntry) float64 { return e.BeatFreq }),
+			ToneVolume:    gnauralValueAt(tone, t, gnauralVolume),
+		}
+		if noise != nil {
+			fc.PinkNoiseVolume = gnauralValueAt(noise, t, gnauralVolume)
+		}
+		frequencyChanges = append(frequencyChanges, fc)
+	}
+
+	return frequencyChanges, nil
+}
+
+// gnauralPoints places each entry at its absolute start time. As in Gnaural, the last entry
+// glides back to the values of the first one, so a closing point is added at the end.
+func gnauralPoints(entries []gnauralEntry) []gnauralPoint {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	points := make([]gnauralPoint, 0, len(entries)+1)
+	var t float64
+	for _, entry := range entries {
+		points = append(points, gnauralPoint{time: t, entry: entry})
+		t += entry.Duration
+	}
+	return append(points, gnauralPoint{time: t, entry: entries[0]})
+}
+
+// gnauralVolume returns the mean of an entry's left and right volumes.
+func gnauralVolume(e gnauralEntry) float64 {
+	return (e.VolumeLeft + e.VolumeRight) / 2
+}
+
+// gnauralValueAt linearly interpolates the value selected by field at time t.
+func gnauralValueAt(points []gnauralPoint, t float64, field func(gnauralEntry) float64) float64 {
+	if t <= points[0].time {
+		return field(points[0].entry)
+	}
+	for i := 0; i < len(points)-1; i++ {
+		if t < points[i+1].time {
+			t1, t2 := points[i].time, points[i+1].time
+			v1, v2 := field(points[i].entry), field(points[i+1].entry)
+			return v1 + (v2-v1)*(t-t1)/(t2-t1)
+		}
+	}
+	return field(points[len(points)-1].entry)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testGnaural = `<?xml version="1.0"?>
+<schedule>
+  <voice>
+    <description>Beat</description>
+    <type>0</type>
+    <entries>
+      <entry duration="60" volume_left="0.4" volume_right="0.6" beatfreq="10" basefreq="200"/>
+      <entry duration="120" volume_left="0.5" volume_right="0.5" beatfreq="4" basefreq="150"/>
+    </entries>
+  </voice>
+  <voice>
+    <description>Noise</description>
+    <type>1</type>
+    <entries>
+      <entry duration="120" volume_left="0.25" volume_right="0.25"/>
+      <entry duration="60" volume_left="0.75" volume_right="0.75"/>
+    </entries>
+  </voice>
+</schedule>
+`
+
+func TestParseGnaural(t *testing.T) {
+	changes, err := parseGnaural([]byte(testGnaural))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := yaml.Marshal(&Config{FrequencyChanges: changes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tone glides from each entry to the next, and from the last back to the first; the
+	// noise is sampled at the tone's times and the tone at the noise's
+	want := `frequency_changes:
+    - time: 0
+      frequency: 200
+      beat_frequency: 10
+      pink_noise_volume: 0.25
+      tone_volume: 0.5
+    - time: 60
+      frequency: 150
+      beat_frequency: 4
+      pink_noise_volume: 0.5
+      tone_volume: 0.5
+    - time: 120
+      frequency: 175
+      beat_frequency: 7
+      pink_noise_volume: 0.75
+      tone_volume: 0.5
+    - time: 180
+      frequency: 200
+      beat_frequency: 10
+      pink_noise_volume: 0.25
+      tone_volume: 0.5
+`
+	if string(got) != want {
+		t.Errorf("converted YAML:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseGnauralErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not XML", "frequency_changes: []"},
+		{"no binaural voice", `<schedule><voice><type>1</type><entries><entry duration="10"/></entries></voice></schedule>`},
+		{"binaural voice without entries", `<schedule><voice><type>0</type></voice></schedule>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseGnaural([]byte(tt.data)); err == nil {
+				t.Error("parseGnaural succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestIsGnaural(t *testing.T) {
+	tests := []struct {
+		filename, data string
+		want           bool
+	}{
+		{"session.gnaural", "", true},
+		{"session.GNAURAL", "", true},
+		{"session.xml", `<?xml version="1.0"?><schedule/>`, true},
+		{"session.txt", "  \n<schedule></schedule>", true},
+		{"session.sbg", "alpha: 200+10/50", false},
+	}
+	for _, tt := range tests {
+		if got := isGnaural(tt.filename, []byte(tt.data)); got != tt.want {
+			t.Errorf("isGnaural(%q) = %v, want %v", tt.filename, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"regexp"
 	"sort"
@@ -22,6 +25,9 @@ type ToneSet struct {
 	BeatFrequency   float64
 	PinkNoiseVolume float64
 	ToneVolume      float64
+	MixFile         string   // Soundtrack referenced by a mix/ specification
+	Parsed          []string // Tokens converted into the frequency change
+	Skipped         []string // Tokens with no equivalent in the YAML configuration
 }
 
 // FrequencyChange represents a single frequency change in the YAML output.
@@ -31,6 +37,15 @@ type FrequencyChange struct {
 	BeatFrequency   float64 `yaml:"beat_frequency"`
 	PinkNoiseVolume float64 `yaml:"pink_noise_volume"`
 	ToneVolume      float64 `yaml:"tone_volume"`
+	MixFile         string  `yaml:"mix_file,omitempty"`
+}
+
+// SbagenOptions holds the Sbagen command line options given on option lines at the top of a
+// file that affect the length of the session.
+type SbagenOptions struct {
+	StartAtFirst bool    // -S: start playback at the first time-sequence entry
+	EndAtLast    bool    // -E: end playback at the last time-sequence entry
+	Length       float64 // -L: total length of the session in seconds, or 0 if not given
 }
 
 // Config represents the overall YAML configuration.
@@ -40,32 +55,59 @@ type Config struct {
 
 func main() {
 	// Parse command-line arguments
-	inputFile := flag.String("input", "", "Path to the Sbagen input file")
+	inputFile := flag.String("input", "", "Path to the Sbagen or Gnaural input file")
 	outputFile := flag.String("output", "", "Path to the YAML output file (optional, defaults to stdout)")
+	strict := flag.Bool("strict", false, "Fail on out-of-range Sbagen amplitudes instead of clamping them with a warning")
+	explain := flag.Bool("explain", false, "Print how each Sbagen tone-set and time-sequence line was converted to stderr")
+	finalHold := flag.Float64("final-hold", 0, "Seconds to hold an audible last Sbagen tone-set when the file gives no -L length or -E (0 leaves it with no duration)")
 	flag.Parse()
 
 	// Validate input
 	if *inputFile == "" {
-		log.Fatal("Input file is required. Use -input <path> to specify the Sbagen file.")
+		log.Fatal("Input file is required. Use -input <path> to specify the Sbagen or Gnaural file.")
 	}
 
-	// Open input file
-	file, err := os.Open(*inputFile)
+	// Read input file
+	data, err := os.ReadFile(*inputFile)
 	if err != nil {
-		log.Fatalf("Failed to open input file: %v", err)
+		log.Fatalf("Failed to read input file: %v", err)
 	}
-	defer file.Close()
 
-	// Read and parse the Sbagen file
-	toneSets, timeSequence, err := parseSbagen(file)
-	if err != nil {
-		log.Fatalf("Failed to parse Sbagen file: %v", err)
+	var frequencyChanges []FrequencyChange
+	if isGnaural(*inputFile, data) {
+		// Parse the Gnaural schedule
+		frequencyChanges, err = parseGnaural(data)
+		if err != nil {
+			log.Fatalf("Failed to parse Gnaural file: %v", err)
+		}
+		if *explain {
+			log.Println("-explain only applies to Sbagen files")
+		}
+	} else {
+		// Read and parse the Sbagen file
+		toneSets, timeSequence, options, err := parseSbagen(bytes.NewReader(data), *strict)
+		if err != nil {
+			log.Fatalf("Failed to parse Sbagen file: %v", err)
+		}
+
+		// Convert time-sequence to frequency changes
+		frequencyChanges, err = convertToFrequencyChanges(toneSets, timeSequence)
+		if err != nil {
+			log.Fatalf("Failed to convert to frequency changes: %v", err)
+		}
+
+		if *explain {
+			explainSbagen(os.Stderr, toneSets, timeSequence, frequencyChanges)
+		}
+
+		frequencyChanges = applySbagenLength(frequencyChanges, options, *finalHold)
 	}
 
-	// Convert time-sequence to frequency changes
-	frequencyChanges, err := convertToFrequencyChanges(toneSets, timeSequence)
-	if err != nil {
-		log.Fatalf("Failed to convert to frequency changes: %v", err)
+	for _, fc := range frequencyChanges {
+		if fc.MixFile != "" {
+			log.Printf("Warning: mix_file %q is kept in the output, but the player does not mix soundtracks yet", fc.MixFile)
+			break
+		}
 	}
 
 	// Sort frequencyChanges by Time
@@ -95,12 +137,15 @@ func main() {
 	}
 }
 
-// parseSbagen parses the Sbagen configuration from the given file.
-// It returns a map of tone-set names to ToneSet structs and a slice of time-sequence lines.
-func parseSbagen(file *os.File) (map[string]ToneSet, []string, error) {
-	scanner := bufio.NewScanner(file)
+// parseSbagen parses the Sbagen configuration from the given reader.
+// It returns a map of tone-set names to ToneSet structs, a slice of time-sequence lines and the
+// options given on option lines before the tone-sets.
+// Out-of-range amplitudes are clamped with a warning, or rejected when strict is set.
+func parseSbagen(r io.Reader, strict bool) (map[string]ToneSet, []string, SbagenOptions, error) {
+	scanner := bufio.NewScanner(r)
 	toneSets := make(map[string]ToneSet)
 	var timeSequence []string
+	var options SbagenOptions
 
 	// Regular expressions
 	toneSetRegex := regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9_-]*):\s*(.*)$`)
@@ -117,14 +162,22 @@ func parseSbagen(file *os.File) (map[string]ToneSet, []string, error) {
 			continue
 		}
 
+		// Option lines, such as -SE or -L 1:00, come before the tone-sets
+		if parsingToneSets && len(toneSets) == 0 && strings.HasPrefix(line, "-") {
+			if err := parseSbagenOptions(line, &options); err != nil {
+				return nil, nil, options, err
+			}
+			continue
+		}
+
 		if parsingToneSets {
 			// Check if the line is a tone-set definition
 			if matches := toneSetRegex.FindStringSubmatch(line); matches != nil {
 				name := matches[1]
 				specs := matches[2]
-				toneSet, err := parseToneSet(name, specs)
+				toneSet, err := parseToneSet(name, specs, strict)
 				if err != nil {
-					return nil, nil, fmt.Errorf("error parsing tone-set '%s': %v", name, err)
+					return nil, nil, options, fmt.Errorf("error parsing tone-set '%s': %v", name, err)
 				}
 				toneSets[name] = toneSet
 			} else {
@@ -138,28 +191,60 @@ func parseSbagen(file *os.File) (map[string]ToneSet, []string, error) {
 			if matches := timeSeqRegex.FindStringSubmatch(line); matches != nil {
 				timeSequence = append(timeSequence, line)
 			} else {
-				return nil, nil, fmt.Errorf("invalid time-sequence line: '%s'", line)
+				return nil, nil, options, fmt.Errorf("invalid time-sequence line: '%s'", line)
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, nil, fmt.Errorf("error reading input file: %v", err)
+		return nil, nil, options, fmt.Errorf("error reading input file: %v", err)
 	}
 
 	if len(toneSets) == 0 {
-		return nil, nil, errors.New("no tone-set definitions found")
+		return nil, nil, options, errors.New("no tone-set definitions found")
 	}
 
 	if len(timeSequence) == 0 {
-		return nil, nil, errors.New("no time-sequence definitions found")
+		return nil, nil, options, errors.New("no time-sequence definitions found")
 	}
 
-	return toneSets, timeSequence, nil
+	return toneSets, timeSequence, options, nil
+}
+
+// parseSbagenOptions reads the options on an option line into options. Only -S, -E and -L
+// affect the converted schedule; the others are ignored, along with their arguments.
+func parseSbagenOptions(line string, options *SbagenOptions) error {
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "-") {
+			continue // An argument of an ignored option
+		}
+		for _, option := range strings.TrimPrefix(fields[i], "-") {
+			switch option {
+			case 'S':
+				options.StartAtFirst = true
+			case 'E':
+				options.EndAtLast = true
+			case 'L':
+				if i+1 >= len(fields) {
+					return errors.New("option -L needs a length")
+				}
+				i++
+				length, err := parseTimeToSeconds(fields[i])
+				if err != nil {
+					return fmt.Errorf("invalid -L length '%s': %v", fields[i], err)
+				}
+				options.Length = length
+			default:
+				log.Printf("Warning: Sbagen option -%c has no equivalent and is ignored", option)
+			}
+		}
+	}
+	return nil
 }
 
 // parseToneSet parses a single tone-set definition line.
-func parseToneSet(name, specs string) (ToneSet, error) {
+func parseToneSet(name, specs string, strict bool) (ToneSet, error) {
 	toneSet := ToneSet{
 		Name: name,
 	}
@@ -170,6 +255,7 @@ func parseToneSet(name, specs string) (ToneSet, error) {
 		toneSet.BeatFrequency = 0.0
 		toneSet.PinkNoiseVolume = 0.0
 		toneSet.ToneVolume = 0.0
+		toneSet.Parsed = []string{specs}
 		return toneSet, nil
 	}
 
@@ -184,13 +270,22 @@ func parseToneSet(name, specs string) (ToneSet, error) {
 				return toneSet, fmt.Errorf("invalid pink noise amplitude: '%s'", ampStr)
 			}
 			toneSet.PinkNoiseVolume = amp / 100.0
+			toneSet.Parsed = append(toneSet.Parsed, part)
 		} else if strings.HasPrefix(part, "mix/") {
-			// Soundtrack input mix (not handled in frequency_changes)
-			// Skipping as it's not relevant to frequency_changes
+			// Soundtrack input mix. A numeric value is the amplitude of the soundtrack given to
+			// Sbagen with -m, which isn't part of the file; anything else names the soundtrack.
+			mix := strings.TrimPrefix(part, "mix/")
+			if _, err := strconv.ParseFloat(mix, 64); err != nil {
+				toneSet.MixFile = mix
+				toneSet.Parsed = append(toneSet.Parsed, part)
+			} else {
+				toneSet.Skipped = append(toneSet.Skipped, part)
+			}
 			continue
 		} else if strings.HasPrefix(part, "bell") || strings.HasPrefix(part, "spin:") || strings.HasPrefix(part, "wave") {
 			// Other sound types (not handled in frequency_changes)
 			// Skipping as it's not relevant to frequency_changes
+			toneSet.Skipped = append(toneSet.Skipped, part)
 			continue
 		} else {
 			// Assume it's a binaural tone or sine-wave
@@ -238,12 +333,38 @@ func parseToneSet(name, specs string) (ToneSet, error) {
 			toneSet.Frequency = carrier
 			toneSet.BeatFrequency = beatFreq
 			toneSet.ToneVolume += amp // Accumulate if multiple tones
+			toneSet.Parsed = append(toneSet.Parsed, part)
 		}
 	}
 
+	var err error
+	toneSet.PinkNoiseVolume, err = clampVolume(name, "pink noise", toneSet.PinkNoiseVolume, strict)
+	if err != nil {
+		return toneSet, err
+	}
+	toneSet.ToneVolume, err = clampVolume(name, "tone", toneSet.ToneVolume, strict)
+	if err != nil {
+		return toneSet, err
+	}
+
 	return toneSet, nil
 }
 
+// clampVolume limits a converted volume to the 0.0 to 1.0 range the player accepts, logging a
+// warning when it had to be changed. With strict set, an out-of-range volume is an error.
+func clampVolume(name, kind string, volume float64, strict bool) (float64, error) {
+	if volume >= 0 && volume <= 1 {
+		return volume, nil
+	}
+	if strict {
+		return volume, fmt.Errorf("%s volume %g is outside 0.0 to 1.0", kind, volume)
+	}
+
+	clamped := math.Max(0, math.Min(1, volume))
+	log.Printf("Warning: tone-set '%s': %s volume %g is outside 0.0 to 1.0, clamped to %g", name, kind, volume, clamped)
+	return clamped, nil
+}
+
 // convertToFrequencyChanges converts the parsed tone-sets and time-sequence into frequency changes.
 func convertToFrequencyChanges(toneSets map[string]ToneSet, timeSequence []string) ([]FrequencyChange, error) {
 	var frequencyChanges []FrequencyChange
@@ -298,6 +419,7 @@ func convertToFrequencyChanges(toneSets map[string]ToneSet, timeSequence []strin
 			BeatFrequency:   toneSet.BeatFrequency,
 			PinkNoiseVolume: toneSet.PinkNoiseVolume,
 			ToneVolume:      toneSet.ToneVolume,
+			MixFile:         toneSet.MixFile,
 		}
 		frequencyChanges = append(frequencyChanges, fc)
 	}
@@ -305,6 +427,78 @@ func convertToFrequencyChanges(toneSets map[string]ToneSet, timeSequence []strin
 	return frequencyChanges, nil
 }
 
+// applySbagenLength adjusts the sorted frequency changes to the session length the Sbagen file
+// implies. The schedule gives no duration to its last entry, so when that entry is audible the
+// player would end as soon as it is reached. The length is worked out as follows:
+//
+//   - With -S, the changes are shifted so the first entry is at the start.
+//   - With -L, the last entry is held until the given length.
+//   - Otherwise with -E, playback ends at the last entry, as Sbagen does.
+//   - Otherwise an audible last entry is held for finalHold seconds, or left with no duration
+//     and a warning if finalHold is 0.
+func applySbagenLength(changes []FrequencyChange, options SbagenOptions, finalHold float64) []FrequencyChange {
+	if len(changes) == 0 {
+		return changes
+	}
+
+	if options.StartAtFirst && changes[0].Time > 0 {
+		shift := changes[0].Time
+		for i := range changes {
+			changes[i].Time -= shift
+		}
+	}
+
+	last := changes[len(changes)-1]
+	audible := last.ToneVolume > 0 || last.PinkNoiseVolume > 0
+	switch {
+	case options.Length > 0:
+		if options.Length < last.Time {
+			log.Printf("Warning: the schedule runs to %.0f s, past the -L length of %.0f s; it is kept in full", last.Time, options.Length)
+			return changes
+		}
+		last.Time = options.Length
+	case options.EndAtLast || !audible:
+		return changes
+	case finalHold > 0:
+		last.Time += finalHold
+	default:
+		log.Printf("Warning: the last time-sequence entry is audible but has no duration, so playback ends as it is reached; use -final-hold to hold it")
+		return changes
+	}
+	if last.Time > changes[len(changes)-1].Time {
+		changes = append(changes, last)
+	}
+	return changes
+}
+
+// explainSbagen writes which tokens of each tone-set were converted or skipped, and the
+// frequency change produced by each time-sequence line. The changes must be in the order
+// returned by convertToFrequencyChanges, one per time-sequence line.
+func explainSbagen(w io.Writer, toneSets map[string]ToneSet, timeSequence []string, frequencyChanges []FrequencyChange) {
+	names := make([]string, 0, len(toneSets))
+	for name := range toneSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Tone-sets:")
+	for _, name := range names {
+		toneSet := toneSets[name]
+		fmt.Fprintf(w, "  %s: parsed [%s], skipped [%s]\n", name, strings.Join(toneSet.Parsed, " "), strings.Join(toneSet.Skipped, " "))
+	}
+
+	fmt.Fprintln(w, "Time-sequence:")
+	for i, line := range timeSequence {
+		fc := frequencyChanges[i]
+		fmt.Fprintf(w, "  %q -> time %.0f s: frequency %g, beat_frequency %g, pink_noise_volume %g, tone_volume %g",
+			line, fc.Time, fc.Frequency, fc.BeatFrequency, fc.PinkNoiseVolume, fc.ToneVolume)
+		if fc.MixFile != "" {
+			fmt.Fprintf(w, ", mix_file %s", fc.MixFile)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
 // parseTimeToSeconds parses a time string in "hh:mm" or "hh:mm:ss" format to total seconds.
 func parseTimeToSeconds(timeStr string) (float64, error) {
 	parts := strings.Split(timeStr, ":")
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// convertSbagen parses an Sbagen file and converts its time sequence to frequency changes.
+func convertSbagen(t *testing.T, input string, strict bool) (map[string]ToneSet, []FrequencyChange) {
+	t.Helper()
+	toneSets, timeSequence, _, err := parseSbagen(strings.NewReader(input), strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := convertToFrequencyChanges(toneSets, timeSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return toneSets, changes
+}
+
+func TestSbagenMixFile(t *testing.T) {
+	toneSets, changes := convertSbagen(t, `
+rain: mix/song.ogg 200+10/50 pink/30
+quiet: mix/80 200+10/50
+off: -
+
+NOW rain
++00:05:00 quiet
++00:10:00 off
+`, false)
+
+	if got := toneSets["rain"].MixFile; got != "song.ogg" {
+		t.Errorf("mix file of rain = %q, want song.ogg", got)
+	}
+	// A mix amplitude describes the soundtrack given on the command line, not a file
+	if got := toneSets["quiet"].MixFile; got != "" {
+		t.Errorf("mix file of quiet = %q, want none for mix/80", got)
+	}
+	if skipped := toneSets["quiet"].Skipped; len(skipped) != 1 || skipped[0] != "mix/80" {
+		t.Errorf("skipped parts of quiet = %q, want mix/80", skipped)
+	}
+
+	out, err := yaml.Marshal(&Config{FrequencyChanges: changes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(out), "mix_file: song.ogg"); n != 1 {
+		t.Errorf("output has %d mix_file entries, want 1:\n%s", n, out)
+	}
+	if changes[0].MixFile != "song.ogg" || changes[1].MixFile != "" {
+		t.Errorf("mix files of the changes = %q, %q, want song.ogg then none", changes[0].MixFile, changes[1].MixFile)
+	}
+}
+
+func TestExplainSbagen(t *testing.T) {
+	input := `
+alpha: 200+10/50 bell+800/20 pink/30
+drift: 150+4/40 spin:300+4.2/80 wave01:100+1/30 mix/80
+off: -
+
+NOW alpha
++00:01:00 drift
+00:05:30 off
+`
+	toneSets, timeSequence, _, err := parseSbagen(strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := convertToFrequencyChanges(toneSets, timeSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	explainSbagen(&out, toneSets, timeSequence, changes)
+
+	want := `Tone-sets:
+  alpha: parsed [200+10/50 pink/30], skipped [bell+800/20]
+  drift: parsed [150+4/40], skipped [spin:300+4.2/80 wave01:100+1/30 mix/80]
+  off: parsed [-], skipped []
+Time-sequence:
+  "NOW alpha" -> time 0 s: frequency 200, beat_frequency 10, pink_noise_volume 0.3, tone_volume 0.5
+  "+00:01:00 drift" -> time 60 s: frequency 150, beat_frequency 4, pink_noise_volume 0, tone_volume 0.4
+  "00:05:30 off" -> time 330 s: frequency 0, beat_frequency 0, pink_noise_volume 0, tone_volume 0
+`
+	if out.String() != want {
+		t.Errorf("explanation:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestParseToneSetClampsVolumes(t *testing.T) {
+	tests := []struct {
+		name       string
+		specs      string
+		strict     bool
+		pink, tone float64
+		warning    string // Expected in the log, or empty for none
+		wantErr    bool
+	}{
+		{"in range", "200+10/50 pink/30", false, 0.3, 0.5, "", false},
+		{"pink noise above range", "200+10/50 pink/150", false, 1, 0.5, "pink noise volume 1.5 is outside 0.0 to 1.0, clamped to 1", false},
+		{"accumulated tones above range", "200+10/50 300+5/100", false, 0, 1, "tone volume 1.5 is outside 0.0 to 1.0, clamped to 1", false},
+		{"strict pink noise", "pink/150", true, 0, 0, "", true},
+		{"strict tones", "200+10/70 300+5/60", true, 0, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			toneSet, err := parseToneSet("test", tt.specs, tt.strict)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseToneSet(%q) succeeded under -strict", tt.specs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(toneSet.PinkNoiseVolume-tt.pink) > 1e-9 || math.Abs(toneSet.ToneVolume-tt.tone) > 1e-9 {
+				t.Errorf("volumes = pink %g, tone %g, want pink %g, tone %g", toneSet.PinkNoiseVolume, toneSet.ToneVolume, tt.pink, tt.tone)
+			}
+			if tt.warning == "" && logged.Len() > 0 {
+				t.Errorf("unexpected warning: %s", logged.String())
+			} else if !strings.Contains(logged.String(), tt.warning) {
+				t.Errorf("warning %q, want it to contain %q", logged.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestApplySbagenLength(t *testing.T) {
+	const schedule = `
+alpha: 200+10/50 pink/30
+theta: 150+6/50 pink/30
+off: -
+
+00:10:00 alpha
+00:20:00 theta
+`
+	tests := []struct {
+		name      string
+		options   string // Option lines before the tone-sets
+		last      string // Tone-set of the last entry
+		finalHold float64
+		times     []float64 // Times of the resulting changes
+		warning   string    // Expected in the log, or empty for none
+	}{
+		{"no options", "", "theta", 0, []float64{600, 1200}, "has no duration"},
+		{"final hold", "", "theta", 300, []float64{600, 1200, 1500}, ""},
+		{"all-off last entry", "", "off", 300, []float64{600, 1200}, ""},
+		{"end at last", "-E", "theta", 300, []float64{600, 1200}, ""},
+		{"start at first", "-S", "theta", 60, []float64{0, 600, 660}, ""},
+		{"length", "-SE\n-L 0:30", "theta", 60, []float64{0, 600, 1800}, ""},
+		{"length shorter than the schedule", "-L 0:15", "theta", 0, []float64{600, 1200}, "past the -L length"},
+		{"ignored option", "-q -L 00:25:00", "theta", 0, []float64{600, 1200, 1500}, "option -q has no equivalent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			input := tt.options + "\n" + strings.Replace(schedule, "00:20:00 theta", "00:20:00 "+tt.last, 1)
+			toneSets, timeSequence, options, err := parseSbagen(strings.NewReader(input), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err := convertToFrequencyChanges(toneSets, timeSequence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes = applySbagenLength(changes, options, tt.finalHold)
+
+			var times []float64
+			for _, c := range changes {
+				times = append(times, c.Time)
+			}
+			if !reflect.DeepEqual(times, tt.times) {
+				t.Errorf("change times = %v, want %v", times, tt.times)
+			}
+			// A held last entry repeats the values of the entry it holds
+			if n := len(changes); n > 2 && changes[n-1].BeatFrequency != changes[n-2].BeatFrequency {
+				t.Errorf("held entry has a beat of %g, want the last entry's %g", changes[n-1].BeatFrequency, changes[n-2].BeatFrequency)
+			}
+			if tt.warning == "" && logged.Len() > 0 || !strings.Contains(logged.String(), tt.warning) {
+				t.Errorf("log = %q, want %q", logged.String(), tt.warning)
+			}
+		})
+	}
+
+	if _, _, _, err := parseSbagen(strings.NewReader("-L\nalpha: 200+10/50\nNOW alpha\n"), false); err == nil || !strings.Contains(err.Error(), "-L needs a length") {
+		t.Errorf("-L without a length gave %v, want an error", err)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/Wundark/binaural-beats
 
-go 1.20
+go 1.21
 
 require (
 	github.com/gopxl/beep v1.4.1
@@ -10,6 +10,9 @@ require (
 require (
 	github.com/ebitengine/oto/v3 v3.1.0 // indirect
 	github.com/ebitengine/purego v0.7.1 // indirect
+	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
+	github.com/jfreymuth/oggvorbis v1.0.5 // indirect
+	github.com/jfreymuth/vorbis v1.0.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 )